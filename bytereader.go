@@ -0,0 +1,128 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"encoding/binary"
+	"io"
+
+	"hz.tools/rfcap/internal"
+	"hz.tools/sdr"
+)
+
+// byteReader is like sdr.ByteReader, except it will hold on to any partial
+// sample returned by the underlying io.Reader until the rest of it arrives,
+// and won't fail when a stream in a foreign byte order ends partway through
+// the buffer passed to Read.
+type byteReader struct {
+	r          io.Reader
+	byteSwap   bool
+	sampleRate uint
+	format     sdr.SampleFormat
+
+	carry []byte
+}
+
+func newByteReader(
+	r io.Reader,
+	byteOrder binary.ByteOrder,
+	sampleRate uint,
+	format sdr.SampleFormat,
+) *byteReader {
+	return &byteReader{
+		r:          r,
+		byteSwap:   byteOrder != nil && byteOrder != internal.NativeEndian,
+		sampleRate: sampleRate,
+		format:     format,
+		carry:      make([]byte, 0, format.Size()),
+	}
+}
+
+func (br *byteReader) SampleRate() uint {
+	return br.sampleRate
+}
+
+func (br *byteReader) SampleFormat() sdr.SampleFormat {
+	return br.format
+}
+
+func (br *byteReader) Read(s sdr.Samples) (int, error) {
+	if s.Format() != br.format {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if s.Length() == 0 {
+		return 0, nil
+	}
+
+	buf, err := sdr.UnsafeSamplesAsBytes(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		size  = br.format.Size()
+		total = copy(buf, br.carry)
+	)
+
+	for total < size {
+		var n int
+		n, err = br.r.Read(buf[total:])
+		total += n
+		if err != nil {
+			break
+		}
+	}
+
+	whole := total / size
+	br.carry = append(br.carry[:0], buf[whole*size:total]...)
+
+	if br.byteSwap {
+		swapBytes(br.format, buf[:whole*size])
+	}
+
+	if whole > 0 && err == io.EOF {
+		// We'll return the io.EOF next time around.
+		err = nil
+	}
+	return whole, err
+}
+
+// swapBytes will convert samples between big and little endian in place.
+func swapBytes(format sdr.SampleFormat, buf []byte) {
+	var width int
+	switch format {
+	case sdr.SampleFormatI16:
+		width = 2
+	case sdr.SampleFormatC64:
+		width = 4
+	default:
+		return
+	}
+
+	for i := 0; i+width <= len(buf); i += width {
+		word := buf[i : i+width]
+		for j, k := 0, width-1; j < k; j, k = j+1, k-1 {
+			word[j], word[k] = word[k], word[j]
+		}
+	}
+}
+
+// vim: foldmethod=marker
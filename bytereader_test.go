@@ -0,0 +1,88 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func TestReaderPartialSamples(t *testing.T) {
+	ref := sdr.SamplesI16{{1, -1}, {258, -258}, {1000, -1000}}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		capture := captureBuffer(t, rfcap.Header{
+			Magic:        rfcap.MagicVersion1,
+			SampleRate:   1000,
+			SampleFormat: sdr.SampleFormatI16,
+			Endianness:   order,
+		}, ref)
+
+		// Every Read will return a single byte, which is only part of a
+		// sample.
+		r, _, err := rfcap.Reader(iotest.OneByteReader(capture))
+		assert.NoError(t, err)
+
+		out := make(sdr.SamplesI16, 8)
+		n, err := sdr.ReadAtLeast(r, out, len(ref))
+		assert.NoError(t, err)
+		assert.Equal(t, len(ref), n)
+		assert.Equal(t, ref, out[:n])
+
+		_, err = r.Read(out)
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestReaderTrailingPartialSample(t *testing.T) {
+	ref := sdr.SamplesI16{{1, -1}, {258, -258}}
+	capture := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.BigEndian,
+	}, ref)
+	capture.Write([]byte{0x01, 0x02})
+
+	r, _, err := rfcap.Reader(iotest.DataErrReader(bytes.NewReader(capture.Bytes())))
+	assert.NoError(t, err)
+
+	// The stream ends partway through the buffer, and partway through a
+	// sample, which is dropped.
+	out := make(sdr.SamplesI16, 8)
+	n, err := r.Read(out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ref), n)
+	assert.Equal(t, ref, out[:n])
+
+	_, err = r.Read(out)
+	assert.Equal(t, io.EOF, err)
+}
+
+// vim: foldmethod=marker
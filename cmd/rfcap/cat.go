@@ -0,0 +1,99 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"hz.tools/rfcap"
+)
+
+func catMain(args []string) error {
+	flags := flag.NewFlagSet("cat", flag.ExitOnError)
+	var (
+		output    = flags.String("o", "-", "capture to write")
+		fill      = flags.Bool("fill", false, "fill gaps between captures with silence")
+		tolerance = flags.Duration("tolerance", time.Millisecond, "largest gap or overlap treated as contiguous")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap cat [flags] <in.rfcap> ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	inputs := []io.Reader{}
+	for _, path := range flags.Args() {
+		fd, err := openCapture(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		inputs = append(inputs, fd)
+	}
+
+	out, err := createCapture(*output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hdr, events, err := rfcap.Concat(out, rfcap.ConcatOptions{
+		FillGaps:  *fill,
+		Tolerance: *tolerance,
+	}, inputs...)
+	if err != nil {
+		switch err := err.(type) {
+		case rfcap.IncompatibleError:
+			return fmt.Errorf(
+				"%s can't be joined to %s: %s",
+				flags.Arg(err.Index), flags.Arg(0),
+				strings.Join(err.Differences, ", "),
+			)
+		case rfcap.OverlapError:
+			return fmt.Errorf(
+				"%s starts %s before %s ends",
+				flags.Arg(err.Index), err.Overlap, flags.Arg(err.Index-1),
+			)
+		default:
+			return err
+		}
+	}
+
+	for _, event := range events {
+		fmt.Fprintf(
+			os.Stderr, "gap of %s (%d samples) at sample %d\n",
+			hdr.Duration(int64(event.Length)), event.Length, event.Sample,
+		)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
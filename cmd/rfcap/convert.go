@@ -0,0 +1,97 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func convertMain(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	var (
+		format     = flags.String("format", "", "target sample format (u8, i8, i16, c64)")
+		endianness = flags.String("endianness", "", "target byte order (little, big); defaults to the input's, or little for u8 input")
+		compress   = flags.Bool("compress", false, "pack i16 samples into 12 bits")
+		decompress = flags.Bool("decompress", false, "unpack 12 bit i16 samples")
+		lossy      = flags.Bool("lossy", false, "allow conversions that lose precision")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap convert [flags] <in.rfcap|-> <out.rfcap|->\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || (*compress && *decompress) {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, hdr, err := rfcap.Reader(in)
+	if err != nil {
+		return err
+	}
+
+	target := hdr
+	if hdr.SampleFormat == sdr.SampleFormatU8 {
+		// u8 samples have no byte order of their own, so there's nothing
+		// to carry over to a wider sample format.
+		target.Endianness = binary.LittleEndian
+	}
+	if *format != "" {
+		if target.SampleFormat, err = rfcap.ParseSampleFormat(*format); err != nil {
+			return err
+		}
+	}
+	if *endianness != "" {
+		if target.Endianness, err = rfcap.ParseByteOrder(*endianness); err != nil {
+			return err
+		}
+	}
+	switch {
+	case *compress:
+		target.Compressed = true
+	case *decompress:
+		target.Compressed = false
+	}
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return rfcap.ConvertWithOptions(r, hdr, out, target, rfcap.ConvertOptions{
+		AllowLossy: *lossy,
+	})
+}
+
+// vim: foldmethod=marker
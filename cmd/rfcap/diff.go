@@ -0,0 +1,134 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"hz.tools/rfcap"
+)
+
+// captureDiff is the format used by `rfcap diff --json`.
+type captureDiff struct {
+	Want              string   `json:"want"`
+	Got               string   `json:"got"`
+	Match             bool     `json:"match"`
+	HeaderDifferences []string `json:"header_differences"`
+	WantSamples       int64    `json:"want_samples"`
+	GotSamples        int64    `json:"got_samples"`
+	FirstDivergence   int64    `json:"first_divergence"`
+	MaxError          float64  `json:"max_error"`
+	MaxErrorSample    int64    `json:"max_error_sample"`
+	ErrorEnergy       float64  `json:"error_energy"`
+
+	// SNR is null if the captures are identical.
+	SNR *float64 `json:"snr_db"`
+}
+
+func diffMain(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	var (
+		abs         = flags.Float64("abs", 0, "absolute tolerance per sample, where 1 is full scale")
+		rel         = flags.Float64("rel", 0, "tolerance per sample, relative to the expected sample")
+		snr         = flags.Float64("snr", 0, "match by SNR in dB rather than per sample tolerance")
+		captureTime = flags.Bool("capture-time", false, "require capture times to match")
+		asJSON      = flags.Bool("json", false, "output a JSON object")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap diff [flags] <want.rfcap> <got.rfcap>\n\n")
+		fmt.Fprintf(flags.Output(), "Exits with status 1 if the captures don't match.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	want, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer want.Close()
+
+	got, err := openCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer got.Close()
+
+	report, err := rfcap.Diff(want, got, rfcap.DiffOptions{
+		AbsoluteTolerance:  *abs,
+		RelativeTolerance:  *rel,
+		MinSNR:             *snr,
+		CompareCaptureTime: *captureTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		result := captureDiff{
+			Want:              flags.Arg(0),
+			Got:               flags.Arg(1),
+			Match:             report.Match,
+			HeaderDifferences: report.HeaderDifferences,
+			WantSamples:       report.WantSamples,
+			GotSamples:        report.GotSamples,
+			FirstDivergence:   report.FirstDivergence,
+			MaxError:          report.MaxError,
+			MaxErrorSample:    report.MaxErrorSample,
+			ErrorEnergy:       report.ErrorEnergy,
+		}
+		if snr := report.SNR(); !math.IsInf(snr, 0) {
+			result.SNR = &snr
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			return err
+		}
+	} else {
+		if len(report.HeaderDifferences) > 0 {
+			fmt.Printf("header: %s\n", strings.Join(report.HeaderDifferences, ", "))
+		}
+		if report.WantSamples != report.GotSamples {
+			fmt.Printf("samples: %d != %d\n", report.GotSamples, report.WantSamples)
+		}
+		if report.FirstDivergence >= 0 {
+			fmt.Printf("first divergence: sample %d\n", report.FirstDivergence)
+		}
+		fmt.Printf("max error: %g (sample %d)\n", report.MaxError, report.MaxErrorSample)
+		fmt.Printf("error energy: %g\n", report.ErrorEnergy)
+		fmt.Printf("snr: %.2f dB\n", report.SNR())
+	}
+
+	if !report.Match {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,154 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"hz.tools/rfcap"
+)
+
+// envelopeFlags are the flags shared by encrypt and decrypt.
+type envelopeFlags struct {
+	keyFile        *string
+	passphraseFile *string
+}
+
+func addEnvelopeFlags(flags *flag.FlagSet) envelopeFlags {
+	return envelopeFlags{
+		keyFile:        flags.String("key-file", "", "file holding a hex encoded AES-128, AES-192 or AES-256 key"),
+		passphraseFile: flags.String("passphrase-file", "", "file holding a passphrase (default $RFCAP_PASSPHRASE)"),
+	}
+}
+
+// options will load the key or passphrase.
+func (ef envelopeFlags) options() (rfcap.EnvelopeOptions, error) {
+	opts := rfcap.EnvelopeOptions{}
+	switch {
+	case *ef.keyFile != "" && *ef.passphraseFile != "":
+		return opts, fmt.Errorf("only one of --key-file or --passphrase-file can be used")
+	case *ef.keyFile != "":
+		b, err := ioutil.ReadFile(*ef.keyFile)
+		if err != nil {
+			return opts, err
+		}
+		if opts.Key, err = hex.DecodeString(strings.TrimSpace(string(b))); err != nil {
+			return opts, fmt.Errorf("%s: %w", *ef.keyFile, err)
+		}
+	case *ef.passphraseFile != "":
+		b, err := ioutil.ReadFile(*ef.passphraseFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Passphrase = strings.TrimRight(string(b), "\r\n")
+	default:
+		opts.Passphrase = os.Getenv("RFCAP_PASSPHRASE")
+	}
+	if opts.Key == nil && opts.Passphrase == "" {
+		return opts, fmt.Errorf("a key or passphrase is needed")
+	}
+	return opts, nil
+}
+
+func encryptMain(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	var (
+		ef            = addEnvelopeFlags(flags)
+		encryptHeader = flags.Bool("encrypt-header", false, "encrypt the header as well as the samples")
+		chunkSize     = flags.Int("chunk-size", rfcap.EnvelopeDefaultChunkSize, "bytes of samples in each authenticated chunk")
+		iterations    = flags.Int("iterations", rfcap.EnvelopeDefaultIterations, "PBKDF2 iterations for a passphrase")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap encrypt [flags] <in.rfcap|-> <out|->\n\n")
+		fmt.Fprintf(flags.Output(), "Seals a capture in an authenticated AES-GCM envelope.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	opts, err := ef.options()
+	if err != nil {
+		return err
+	}
+	opts.EncryptHeader = *encryptHeader
+	opts.ChunkSize = *chunkSize
+	opts.Iterations = *iterations
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return rfcap.Seal(in, out, opts)
+}
+
+func decryptMain(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	ef := addEnvelopeFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap decrypt [flags] <in|-> <out.rfcap|->\n\n")
+		fmt.Fprintf(flags.Output(), "Opens a capture sealed by encrypt, failing if it was tampered with.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	opts, err := ef.options()
+	if err != nil {
+		return err
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return rfcap.Open(in, out, opts)
+}
+
+// vim: foldmethod=marker
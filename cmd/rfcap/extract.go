@@ -0,0 +1,111 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+)
+
+// parseChannel will parse a channel and its output, such as
+// "100.1MHz=out.rfcap" or "100.1MHz/12.5kHz=out.rfcap".
+func parseChannel(value string, bandwidth rf.Hz) (rfcap.Channel, string, error) {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return rfcap.Channel{}, "", fmt.Errorf("%q is not <freq>[/<bandwidth>]=<out.rfcap>", value)
+	}
+	spec, path := value[:i], value[i+1:]
+
+	if j := strings.Index(spec, "/"); j >= 0 {
+		var err error
+		if bandwidth, err = rf.ParseHz(spec[j+1:]); err != nil {
+			return rfcap.Channel{}, "", err
+		}
+		spec = spec[:j]
+	}
+	if bandwidth == 0 {
+		return rfcap.Channel{}, "", fmt.Errorf("%q has no bandwidth, and -bandwidth wasn't given", value)
+	}
+
+	freq, err := rf.ParseHz(spec)
+	if err != nil {
+		return rfcap.Channel{}, "", err
+	}
+	return rfcap.Channel{CenterFrequency: freq, Bandwidth: bandwidth}, path, nil
+}
+
+func extractMain(args []string) error {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	bandwidth := flags.String("bandwidth", "", "bandwidth of every channel that doesn't give its own")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap extract [flags] <in.rfcap|-> <freq>[/<bandwidth>]=<out.rfcap> ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	var defaultBandwidth rf.Hz
+	if *bandwidth != "" {
+		var err error
+		if defaultBandwidth, err = rf.ParseHz(*bandwidth); err != nil {
+			return err
+		}
+	}
+
+	var (
+		channels = []rfcap.Channel{}
+		outs     = []io.Writer{}
+	)
+	for _, arg := range flags.Args()[1:] {
+		ch, path, err := parseChannel(arg, defaultBandwidth)
+		if err != nil {
+			return err
+		}
+		fd, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		channels = append(channels, ch)
+		outs = append(outs, fd)
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = rfcap.ExtractChannels(in, channels, outs)
+	return err
+}
+
+// vim: foldmethod=marker
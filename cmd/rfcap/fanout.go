@@ -0,0 +1,131 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// parseSlowClientPolicy will parse the name of a rfcap.SlowClientPolicy.
+func parseSlowClientPolicy(name string) (rfcap.SlowClientPolicy, error) {
+	for _, policy := range []rfcap.SlowClientPolicy{
+		rfcap.SlowClientDrop,
+		rfcap.SlowClientDisconnect,
+	} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slow client policy %q", name)
+}
+
+// openStream will read the capture, optionally paced to its sample rate as
+// if it were coming off a live radio.
+func openStream(in io.Reader, realtime bool) (sdr.Reader, rfcap.Header, error) {
+	if !realtime {
+		return rfcap.Reader(in)
+	}
+
+	replay, err := rfcap.ReplaySdr(in, rfcap.ReplayOptions{Realtime: true})
+	if err != nil {
+		return nil, rfcap.Header{}, err
+	}
+	r, err := replay.StartRx()
+	if err != nil {
+		return nil, rfcap.Header{}, err
+	}
+
+	// The Replay hands back complex64, whatever the capture was.
+	hdr := replay.Header()
+	hdr.SampleFormat = sdr.SampleFormatC64
+	hdr.Endianness = binary.LittleEndian
+	hdr.Compressed = false
+	return r, hdr, nil
+}
+
+func fanoutMain(args []string) error {
+	flags := flag.NewFlagSet("fanout", flag.ExitOnError)
+	var (
+		addr     = flags.String("addr", ":9000", "address to listen on")
+		queue    = flags.Int("queue", 64, "buffers queued for a client before it's considered slow")
+		slow     = flags.String("slow", "drop", "what to do with slow clients (drop, disconnect)")
+		realtime = flags.Bool("realtime", false, "pace a capture file to its sample rate, as if it were live")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap fanout [flags] <in.rfcap|->\n\n")
+		fmt.Fprintf(flags.Output(), "Streams one capture, such as a radio piped in on stdin, to any\n")
+		fmt.Fprintf(flags.Output(), "number of TCP clients. Each client gets its own rfcap header.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	policy, err := parseSlowClientPolicy(*slow)
+	if err != nil {
+		return err
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, hdr, err := openStream(in, *realtime)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Printf("streaming %s on %s", flags.Arg(0), l.Addr())
+
+	fanout := rfcap.NewFanout(r, hdr, rfcap.FanoutOptions{
+		QueueLength: *queue,
+		SlowClients: policy,
+		OnEvent: func(addr net.Addr, event rfcap.Event) {
+			if event.Kind == rfcap.EventDisconnect {
+				log.Printf("%s: disconnected for falling behind at sample %d", addr, event.Sample)
+				return
+			}
+			log.Printf("%s: dropped %d samples at sample %d", addr, event.Length, event.Sample)
+		},
+	})
+	go fanout.Serve(l)
+	return fanout.Run()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,191 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hz.tools/rfcap"
+)
+
+// captureInfo is everything we know about a capture, and is the format
+// used by `rfcap info --json`.
+type captureInfo struct {
+	Path     string        `json:"path"`
+	Header   rfcap.Header  `json:"header"`
+	Samples  int64         `json:"samples"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// openCapture will open the named capture, or stdin if the path is "-".
+// Captures on a web server can be opened by passing an http or https URL.
+func openCapture(path string) (io.ReadCloser, error) {
+	switch {
+	case path == "-":
+		return os.Stdin, nil
+	case isRemote(path):
+		return rfcap.OpenURL(path, rfcap.RemoteOptions{})
+	}
+	return os.Open(path)
+}
+
+// isRemote will return true if the path is an http or https URL.
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// captureSize will return the size of the opened capture in bytes, if it's
+// something with a known size.
+func captureSize(fd io.ReadCloser) (int64, bool) {
+	switch fd := fd.(type) {
+	case *rfcap.RemoteCapture:
+		return fd.Size(), true
+	case *os.File:
+		if stat, err := fd.Stat(); err == nil && stat.Mode().IsRegular() {
+			return stat.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// createCapture will create the named capture, or use stdout if the path
+// is "-".
+func createCapture(path string) (*os.File, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func readInfo(path string) (captureInfo, error) {
+	fd, err := openCapture(path)
+	if err != nil {
+		return captureInfo{}, err
+	}
+	defer fd.Close()
+
+	header, err := rfcap.ReadHeader(fd)
+	if err != nil {
+		return captureInfo{}, err
+	}
+
+	var length int64
+	if size, ok := captureSize(fd); ok {
+		offset, err := header.DataOffset()
+		if err != nil {
+			return captureInfo{}, err
+		}
+		length = size - offset
+	} else {
+		// This isn't a file we know the size of, so we've got to count it the
+		// hard way.
+		if length, err = io.Copy(ioutil.Discard, fd); err != nil {
+			return captureInfo{}, err
+		}
+	}
+
+	samples := header.SampleCount(length)
+	return captureInfo{
+		Path:     path,
+		Header:   header,
+		Samples:  samples,
+		Duration: header.Duration(samples),
+	}, nil
+}
+
+func printInfo(out io.Writer, info captureInfo) {
+	var (
+		h = info.Header
+		w = tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	)
+
+	fmt.Fprintf(w, "%s\n", info.Path)
+	fmt.Fprintf(w, "  Version:\t%s\n", h.Magic)
+	fmt.Fprintf(w, "  Capture Time:\t%s\n", h.CaptureTime.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "  Center Frequency:\t%s\n", h.CenterFrequency)
+	fmt.Fprintf(w, "  Sample Rate:\t%d\n", h.SampleRate)
+	fmt.Fprintf(w, "  Sample Format:\t%s (%s)\n", rfcap.SampleFormatName(h.SampleFormat), h.SampleFormat)
+	fmt.Fprintf(w, "  Endianness:\t%s\n", rfcap.ByteOrderName(h.Endianness))
+	fmt.Fprintf(w, "  Compressed:\t%t\n", h.Compressed)
+	fmt.Fprintf(w, "  Samples:\t%d\n", info.Samples)
+	fmt.Fprintf(w, "  Duration:\t%s\n", info.Duration)
+
+	if ext := h.Extension; ext != nil {
+		if hw := ext.HardwareInfo; hw.Manufacturer != "" || hw.Product != "" {
+			fmt.Fprintf(w, "  Hardware:\t%s\n", strings.TrimSpace(hw.Manufacturer+" "+hw.Product))
+		}
+		if hw := ext.HardwareInfo; hw.Serial != "" {
+			fmt.Fprintf(w, "  Serial:\t%s\n", hw.Serial)
+		}
+		if len(ext.GainStages) > 0 {
+			gains := []string{}
+			for _, gs := range ext.GainStages {
+				gains = append(gains, fmt.Sprintf("%s=%g", gs.Name, gs.Gain))
+			}
+			fmt.Fprintf(w, "  Gain:\t%s\n", strings.Join(gains, " "))
+		}
+		if ext.AutomaticGain {
+			fmt.Fprintf(w, "  Automatic Gain:\t%t\n", ext.AutomaticGain)
+		}
+	}
+	w.Flush()
+}
+
+func infoMain(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "output one JSON object per capture")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap info [--json] <file.rfcap|-> ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, path := range flags.Args() {
+		info, err := readInfo(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if *asJSON {
+			if err := enc.Encode(info); err != nil {
+				return err
+			}
+			continue
+		}
+		printInfo(os.Stdout, info)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
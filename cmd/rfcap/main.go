@@ -0,0 +1,114 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Command rfcap is a tool to inspect and work with rfcap captures.
+//
+// Usage:
+//
+//	rfcap <command> [arguments]
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// command is a single rfcap subcommand.
+type command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{Name: "info", Usage: "print the header and length of rfcap files", Run: infoMain},
+		{Name: "convert", Usage: "change sample format, byte order or compression", Run: convertMain},
+		{Name: "slice", Usage: "extract a range of samples or time", Run: sliceMain},
+		{Name: "cat", Usage: "join captures, checking they are compatible", Run: catMain},
+		{Name: "verify", Usage: "check captures for problems, and optionally repair them", Run: verifyMain},
+		{Name: "shift", Usage: "move the center frequency of a capture", Run: shiftMain},
+		{Name: "decimate", Usage: "reduce the sample rate of a capture", Run: decimateMain},
+		{Name: "gain", Usage: "change the amplitude of a capture", Run: gainMain},
+		{Name: "filter", Usage: "low pass or band pass filter a capture", Run: filterMain},
+		{Name: "tee", Usage: "copy a capture from stdin to stdout and files", Run: teeMain},
+		{Name: "diff", Usage: "compare two captures within a tolerance", Run: diffMain},
+		{Name: "extract", Usage: "extract narrowband channels from a wideband capture", Run: extractMain},
+		{Name: "serve", Usage: "serve a directory of captures over HTTP", Run: serveMain},
+		{Name: "serve-rtltcp", Usage: "play back a capture to rtl_tcp clients such as gqrx", Run: serveRtlTcpMain},
+		{Name: "record-rtltcp", Usage: "record a capture from a remote rtl_tcp server", Run: recordRtlTcpMain},
+		{Name: "fanout", Usage: "stream one capture or radio to many TCP clients", Run: fanoutMain},
+		{Name: "udp-send", Usage: "send a capture or radio as UDP datagrams, such as to a multicast group", Run: udpSendMain},
+		{Name: "udp-recv", Usage: "record a capture sent by udp-send", Run: udpRecvMain},
+		{Name: "sync", Usage: "write or decode a stream that can be joined partway through", Run: syncMain},
+		{Name: "encrypt", Usage: "seal a capture in an authenticated, encrypted envelope", Run: encryptMain},
+		{Name: "decrypt", Usage: "open a capture sealed by encrypt", Run: decryptMain},
+		{Name: "stats", Usage: "report signal statistics such as power and DC offset", Run: statsMain},
+		{Name: "spectrogram", Usage: "render a waterfall plot as a PNG", Run: spectrogramMain},
+	}
+}
+
+// exitCode is returned by a command that wants to exit with a specific exit
+// code, without printing an error.
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: rfcap <command> [arguments]\n\ncommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Name, cmd.Usage)
+	}
+	w.Flush()
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name != os.Args[1] {
+			continue
+		}
+		err := cmd.Run(os.Args[2:])
+		switch err := err.(type) {
+		case nil:
+			return
+		case exitCode:
+			os.Exit(int(err))
+		default:
+			fmt.Fprintf(os.Stderr, "rfcap %s: %s\n", cmd.Name, err)
+			os.Exit(1)
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,216 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+)
+
+// parseOffset will parse a frequency such as "-100kHz", which may be
+// negative, unlike rf.ParseHz.
+func parseOffset(value string) (rf.Hz, error) {
+	if strings.HasPrefix(value, "-") {
+		hz, err := rf.ParseHz(value[1:])
+		return -hz, err
+	}
+	return rf.ParseHz(strings.TrimPrefix(value, "+"))
+}
+
+// runPipeline will parse the flags for a pipeline subcommand, and apply the
+// Transforms returned by build to the capture read from the input (stdin by
+// default), writing the result to the output (stdout by default).
+func runPipeline(flags *flag.FlagSet, args []string, build func() ([]rfcap.Transform, error)) error {
+	usage := flags.Usage
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap %s [flags] [in.rfcap|-] [out.rfcap|-]\n", flags.Name())
+		if usage != nil {
+			usage()
+		}
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	transforms, err := build()
+	if err != nil {
+		return err
+	}
+
+	inPath, outPath := "-", "-"
+	if flags.NArg() > 0 {
+		inPath = flags.Arg(0)
+	}
+	if flags.NArg() > 1 {
+		outPath = flags.Arg(1)
+	}
+
+	in, err := openCapture(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = rfcap.Apply(in, out, transforms...)
+	return err
+}
+
+func shiftMain(args []string) error {
+	flags := flag.NewFlagSet("shift", flag.ExitOnError)
+	var (
+		by = flags.String("by", "", "move the center frequency by this much (such as -100kHz)")
+		to = flags.String("to", "", "move the center frequency to this frequency")
+	)
+	return runPipeline(flags, args, func() ([]rfcap.Transform, error) {
+		switch {
+		case (*by == "") == (*to == ""):
+			return nil, fmt.Errorf("exactly one of -by or -to must be given")
+		case *by != "":
+			offset, err := parseOffset(*by)
+			if err != nil {
+				return nil, err
+			}
+			return []rfcap.Transform{rfcap.Shift(offset)}, nil
+		default:
+			freq, err := rf.ParseHz(*to)
+			if err != nil {
+				return nil, err
+			}
+			return []rfcap.Transform{rfcap.ShiftTo(freq)}, nil
+		}
+	})
+}
+
+func decimateMain(args []string) error {
+	flags := flag.NewFlagSet("decimate", flag.ExitOnError)
+	factor := flags.Uint("factor", 0, "keep one of every this many samples")
+	return runPipeline(flags, args, func() ([]rfcap.Transform, error) {
+		if *factor == 0 {
+			return nil, fmt.Errorf("-factor must be given")
+		}
+		return []rfcap.Transform{rfcap.Decimate(*factor)}, nil
+	})
+}
+
+func gainMain(args []string) error {
+	flags := flag.NewFlagSet("gain", flag.ExitOnError)
+	db := flags.Float64("db", 0, "change in amplitude, in dB")
+	return runPipeline(flags, args, func() ([]rfcap.Transform, error) {
+		return []rfcap.Transform{rfcap.Gain(*db)}, nil
+	})
+}
+
+func filterMain(args []string) error {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	var (
+		cutoff = flags.String("cutoff", "", "low pass: keep everything within this of the center frequency")
+		low    = flags.String("low", "", "band pass: low edge, as an offset from the center frequency")
+		high   = flags.String("high", "", "band pass: high edge, as an offset from the center frequency")
+		taps   = flags.Int("taps", 0, "number of filter taps (0 picks a default)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "\nGive either -cutoff, or both -low and -high.\n\n")
+	}
+	return runPipeline(flags, args, func() ([]rfcap.Transform, error) {
+		if *cutoff != "" {
+			if *low != "" || *high != "" {
+				return nil, fmt.Errorf("-cutoff can't be used with -low or -high")
+			}
+			hz, err := rf.ParseHz(*cutoff)
+			if err != nil {
+				return nil, err
+			}
+			return []rfcap.Transform{rfcap.LowPass(hz, *taps)}, nil
+		}
+
+		if *low == "" || *high == "" {
+			return nil, fmt.Errorf("either -cutoff, or both -low and -high must be given")
+		}
+		lowHz, err := parseOffset(*low)
+		if err != nil {
+			return nil, err
+		}
+		highHz, err := parseOffset(*high)
+		if err != nil {
+			return nil, err
+		}
+		return []rfcap.Transform{rfcap.BandPass(lowHz, highHz, *taps)}, nil
+	})
+}
+
+func teeMain(args []string) error {
+	flags := flag.NewFlagSet("tee", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap tee <out.rfcap> ...\n\n")
+		fmt.Fprintf(flags.Output(), "Copies a capture from stdin to stdout, and to each file.\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	writers := []io.Writer{os.Stdout}
+	for _, path := range flags.Args() {
+		fd, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		writers = append(writers, fd)
+	}
+
+	// Make sure this is actually a capture before copying it anywhere.
+	hdr, err := rfcap.ReadHeader(os.Stdin)
+	if err != nil {
+		return err
+	}
+	header, err := hdr.Marshal()
+	if err != nil {
+		return err
+	}
+
+	out := io.MultiWriter(writers...)
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, os.Stdin)
+	return err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,209 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/rtltcp"
+	"hz.tools/sdr"
+)
+
+func serveRtlTcpMain(args []string) error {
+	flags := flag.NewFlagSet("serve-rtltcp", flag.ExitOnError)
+	var (
+		addr = flags.String("addr", ":1234", "address to listen on")
+		loop = flags.Bool("loop", false, "start the capture over once it ends")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap serve-rtltcp [flags] <file.rfcap>\n\n")
+		fmt.Fprintf(flags.Output(), "Plays back a capture to rtl_tcp clients in real time. Clients may\n")
+		fmt.Fprintf(flags.Output(), "tune anywhere within the bandwidth of the capture, and pick any\n")
+		fmt.Fprintf(flags.Output(), "sample rate that evenly divides the capture's.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// Each client gets its own copy of the capture, so it has to be
+	// something we can open more than once.
+	if flags.NArg() != 1 || flags.Arg(0) == "-" {
+		flags.Usage()
+		return exitCode(2)
+	}
+	path := flags.Arg(0)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving %s over rtl_tcp on %s", path, l.Addr())
+
+	server := rtltcp.Server{
+		Handler: func(conn net.Conn) (sdr.Receiver, error) {
+			log.Printf("%s: connected", conn.RemoteAddr())
+			fd, err := openCapture(path)
+			if err != nil {
+				return nil, err
+			}
+			replay, err := rfcap.ReplaySdr(fd, rfcap.ReplayOptions{
+				Loop:     *loop,
+				Realtime: true,
+			})
+			if err != nil {
+				fd.Close()
+				return nil, err
+			}
+			return replay, nil
+		},
+		OnRequest: func(conn net.Conn, req rtltcp.Request, err error) {
+			if err != nil {
+				log.Printf("%s: rejected %s: %s", conn.RemoteAddr(), req, err)
+				return
+			}
+			log.Printf("%s: %s", conn.RemoteAddr(), req)
+		},
+	}
+	return server.Serve(l)
+}
+
+func recordRtlTcpMain(args []string) error {
+	flags := flag.NewFlagSet("record-rtltcp", flag.ExitOnError)
+	var (
+		freq     = flags.String("freq", "", "center frequency to tune to, such as 100.1MHz")
+		rate     = flags.Uint("rate", 2048000, "sample rate")
+		gain     = flags.String("gain", "auto", "tuner gain in dB, or auto")
+		duration = flags.Duration("duration", 0, "how long to record for (default until the server hangs up)")
+		events   = flags.String("events", "", "write events, such as dropped samples, to this file as JSON lines")
+		hwinfo   = flags.Bool("hardware-info", false, "record the tuner and gain settings in the header (needs an rfcap v2 reader)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap record-rtltcp [flags] <host:port> <out.rfcap|->\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || *freq == "" {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	centerFrequency, err := rf.ParseHz(*freq)
+	if err != nil {
+		return err
+	}
+
+	client, err := rtltcp.Dial("tcp", flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.SetCenterFrequency(centerFrequency); err != nil {
+		return err
+	}
+	if err := client.SetSampleRate(*rate); err != nil {
+		return err
+	}
+	if *gain == "auto" {
+		err = client.SetAutomaticGain(true)
+	} else {
+		var db float64
+		if db, err = strconv.ParseFloat(*gain, 32); err != nil {
+			return fmt.Errorf("bad gain %q: %w", *gain, err)
+		}
+		stages, _ := client.GetGainStages()
+		err = client.SetGain(stages[0], float32(db))
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	opts := rfcap.RecordOptions{Extension: *hwinfo}
+	if *events != "" {
+		eventLog, err := os.Create(*events)
+		if err != nil {
+			return err
+		}
+		defer eventLog.Close()
+		opts.Events = eventLog
+	}
+
+	rec, err := rfcap.RecordWithOptions(client, out, opts)
+	if err != nil {
+		return err
+	}
+
+	rx, err := rec.StartRx()
+	if err != nil {
+		rec.Close()
+		return err
+	}
+
+	remaining := int64(-1)
+	if *duration > 0 {
+		remaining = int64(duration.Seconds() * float64(*rate))
+	}
+
+	buf := make(sdr.SamplesU8, 32*1024)
+	for remaining != 0 {
+		chunk := buf
+		if remaining > 0 && remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := rx.Read(chunk)
+		if remaining > 0 {
+			remaining -= int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rec.Close()
+			return err
+		}
+	}
+
+	if err := rec.Close(); err != nil {
+		return err
+	}
+	for _, event := range rec.Events() {
+		if event.Kind == rfcap.EventGap {
+			log.Printf("dropped %d samples at sample %d", event.Length, event.Sample)
+		}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
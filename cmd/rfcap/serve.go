@@ -0,0 +1,72 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/internal/websocket"
+)
+
+func serveMain(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		addr     = flags.String("addr", ":8080", "address to listen on")
+		realtime = flags.Bool("realtime", true, "pace WebSocket streams to their sample rate")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap serve [flags] <directory>\n\n")
+		fmt.Fprintf(flags.Output(), "Captures can also be streamed over a WebSocket, as samples or FFTs,\n")
+		fmt.Fprintf(flags.Output(), "by connecting to ws://host/name.rfcap?mode=fft.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	var (
+		root  = http.Dir(flags.Arg(0))
+		files = rfcap.FileServer(root)
+		ws    = rfcap.WebSocketHandler(func(r *http.Request) (io.Reader, error) {
+			return root.Open(path.Clean("/" + r.URL.Path))
+		}, rfcap.WebSocketOptions{Realtime: *realtime})
+	)
+
+	log.Printf("serving %s on %s", flags.Arg(0), *addr)
+	return http.ListenAndServe(*addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsUpgrade(r) {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+}
+
+// vim: foldmethod=marker
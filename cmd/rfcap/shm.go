@@ -0,0 +1,177 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// This runs after the init in main.go, since files are initialized in name
+// order.
+func init() {
+	commands = append(commands,
+		command{Name: "shm-send", Usage: "write a capture or radio into a shared memory ring", Run: shmSendMain},
+		command{Name: "shm-recv", Usage: "record a capture from a shared memory ring", Run: shmRecvMain},
+	)
+}
+
+// shmPath will put a bare name under /dev/shm, and leave paths alone.
+func shmPath(name string) string {
+	if strings.ContainsRune(name, filepath.Separator) {
+		return name
+	}
+	return filepath.Join("/dev/shm", name)
+}
+
+func shmSendMain(args []string) error {
+	flags := flag.NewFlagSet("shm-send", flag.ExitOnError)
+	var (
+		samples  = flags.Int("samples", rfcap.ShmDefaultSamples, "capacity of the ring, in samples")
+		realtime = flags.Bool("realtime", false, "pace a capture file to its sample rate, as if it were live")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap shm-send [flags] <in.rfcap|-> <name|path>\n\n")
+		fmt.Fprintf(flags.Output(), "A bare name is created under /dev/shm. The ring is removed once the\n")
+		fmt.Fprintf(flags.Output(), "input ends.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, hdr, err := openStream(in, *realtime)
+	if err != nil {
+		return err
+	}
+
+	w, err := rfcap.CreateShm(shmPath(flags.Arg(1)), hdr, rfcap.ShmOptions{
+		Samples: *samples,
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Make sure the ring is cleaned up if we're interrupted.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		w.Close()
+		os.Exit(1)
+	}()
+
+	_, err = sdr.Copy(w, r)
+	return err
+}
+
+func shmRecvMain(args []string) error {
+	flags := flag.NewFlagSet("shm-recv", flag.ExitOnError)
+	var (
+		duration = flags.Duration("duration", 0, "how long to record for (default until the sender stops)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap shm-recv [flags] <name|path> <out.rfcap|->\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	r, err := rfcap.OpenShm(shmPath(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hdr := r.Header()
+	w, err := rfcap.Writer(out, hdr)
+	if err != nil {
+		return err
+	}
+
+	remaining := int64(-1)
+	if *duration > 0 {
+		remaining = int64(duration.Seconds() * float64(hdr.SampleRate))
+	}
+
+	buf, err := sdr.MakeSamples(hdr.SampleFormat, 32*1024)
+	if err != nil {
+		return err
+	}
+	for remaining != 0 {
+		chunk := buf
+		if remaining > 0 && remaining < int64(chunk.Length()) {
+			chunk = buf.Slice(0, int(remaining))
+		}
+		n, err := r.Read(chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.Slice(0, n)); err != nil {
+			return err
+		}
+		if remaining > 0 {
+			remaining -= int64(n)
+		}
+	}
+
+	for _, event := range r.Events() {
+		log.Printf("dropped %d samples at sample %d", event.Length, event.Sample)
+	}
+	return rfcap.Flush(w)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,72 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"hz.tools/rfcap"
+)
+
+func sliceMain(args []string) error {
+	flags := flag.NewFlagSet("slice", flag.ExitOnError)
+	var (
+		start = flags.String("start", "", "first sample to keep (sample index, duration, or RFC 3339 time)")
+		end   = flags.String("end", "", "sample to stop at (sample index, duration, or RFC 3339 time)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap slice [flags] <in.rfcap|-> <out.rfcap|->\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	startPoint, err := rfcap.ParseSlicePoint(*start)
+	if err != nil {
+		return err
+	}
+	endPoint, err := rfcap.ParseSlicePoint(*end)
+	if err != nil {
+		return err
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = rfcap.Slice(in, out, startPoint, endPoint)
+	return err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,88 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"hz.tools/rfcap"
+)
+
+func spectrogramMain(args []string) error {
+	flags := flag.NewFlagSet("spectrogram", flag.ExitOnError)
+	var (
+		fftSize = flags.Int("fft-size", 1024, "number of frequency bins, which must be a power of two")
+		window  = flags.String("window", rfcap.WindowHann.String(), "window function (hann, hamming, blackman, rectangular)")
+		average = flags.Int("average", 1, "number of ffts averaged into each row")
+		height  = flags.Int("max-height", 4096, "most rows to plot, averaging more ffts into each row to fit")
+		minDB   = flags.Float64("min-db", 0, "power at the bottom of the color scale, in dBFS")
+		maxDB   = flags.Float64("max-db", 0, "power at the top of the color scale, in dBFS")
+		start   = flags.String("start", "", "first sample to render (sample index, duration, or RFC 3339 time)")
+		end     = flags.String("end", "", "sample to stop rendering at (sample index, duration, or RFC 3339 time)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap spectrogram [flags] <in.rfcap|-> <out.png|->\n\n")
+		fmt.Fprintf(flags.Output(), "If -min-db and -max-db are both 0, the range is picked from the capture.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	opts := rfcap.SpectrogramOptions{
+		FFTSize:   *fftSize,
+		Average:   *average,
+		MaxHeight: *height,
+		MinDB:     *minDB,
+		MaxDB:     *maxDB,
+	}
+
+	var err error
+	if opts.Window, err = rfcap.ParseWindow(*window); err != nil {
+		return err
+	}
+	if opts.Start, err = rfcap.ParseSlicePoint(*start); err != nil {
+		return err
+	}
+	if opts.End, err = rfcap.ParseSlicePoint(*end); err != nil {
+		return err
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return rfcap.WriteSpectrogram(in, out, opts)
+}
+
+// vim: foldmethod=marker
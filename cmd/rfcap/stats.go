@@ -0,0 +1,115 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// captureStats is the format used by `rfcap stats --json`.
+type captureStats struct {
+	Path string `json:"path"`
+	rfcap.StatsReport
+}
+
+func readStats(path string) (captureStats, error) {
+	fd, err := openCapture(path)
+	if err != nil {
+		return captureStats{}, err
+	}
+	defer fd.Close()
+
+	reader, _, err := rfcap.Reader(fd)
+	if err != nil {
+		return captureStats{}, err
+	}
+	sr := rfcap.NewStatsReader(reader)
+
+	buf, err := sdr.MakeSamples(sr.SampleFormat(), 32*1024)
+	if err != nil {
+		return captureStats{}, err
+	}
+	for {
+		if _, err := sr.Read(buf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return captureStats{}, err
+		}
+	}
+
+	return captureStats{Path: path, StatsReport: sr.Report()}, nil
+}
+
+func printStats(out io.Writer, stats captureStats) {
+	w := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "%s\n", stats.Path)
+	fmt.Fprintf(w, "  Samples:\t%d\n", stats.Samples)
+	fmt.Fprintf(w, "  Mean Power:\t%.2f dBFS\n", stats.MeanPower)
+	fmt.Fprintf(w, "  Peak Power:\t%.2f dBFS\n", stats.PeakPower)
+	fmt.Fprintf(w, "  Noise Floor:\t%.2f dBFS\n", stats.NoiseFloor)
+	fmt.Fprintf(w, "  DC Offset:\tI=%.6f Q=%.6f\n", stats.DCOffsetI, stats.DCOffsetQ)
+	fmt.Fprintf(w, "  IQ Gain Imbalance:\t%.3f dB\n", stats.GainImbalance)
+	fmt.Fprintf(w, "  IQ Phase Imbalance:\t%.3f degrees\n", stats.PhaseImbalance)
+	fmt.Fprintf(w, "  Clipped:\t%.4f%%\n", stats.Clipped*100)
+	w.Flush()
+}
+
+func statsMain(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "output one JSON object per capture")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap stats [--json] <file.rfcap|-> ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, path := range flags.Args() {
+		stats, err := readStats(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if *asJSON {
+			if err := enc.Encode(stats); err != nil {
+				return err
+			}
+			continue
+		}
+		printStats(os.Stdout, stats)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
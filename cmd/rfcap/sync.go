@@ -0,0 +1,95 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func syncMain(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	var (
+		interval = flags.Int("interval", 0, "samples between sync blocks (default one second)")
+		realtime = flags.Bool("realtime", false, "pace a capture file to its sample rate, as if it were live")
+		decode   = flags.Bool("decode", false, "turn a synced stream, joined at any point, back into a capture")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap sync [flags] <in|-> <out|->\n\n")
+		fmt.Fprintf(flags.Output(), "Without --decode, a capture is written as a synced stream, which a\n")
+		fmt.Fprintf(flags.Output(), "reader can start decoding from partway through, such as on a FIFO.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if *decode {
+		sr, err := rfcap.NewSyncReader(in)
+		if err != nil {
+			return err
+		}
+		w, err := rfcap.Writer(out, sr.Header())
+		if err != nil {
+			return err
+		}
+		_, err = sdr.Copy(w, sr)
+		for _, event := range sr.Events() {
+			log.Printf("lost %d samples at sample %d", event.Length, event.Sample)
+		}
+		if err != nil {
+			return err
+		}
+		return rfcap.Flush(w)
+	}
+
+	r, hdr, err := openStream(in, *realtime)
+	if err != nil {
+		return err
+	}
+	w, err := rfcap.NewSyncWriter(out, hdr, *interval)
+	if err != nil {
+		return err
+	}
+	_, err = sdr.Copy(w, r)
+	return err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,209 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// udpWriter sends each Write as a datagram to a fixed address.
+type udpWriter struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+func (uw udpWriter) Write(b []byte) (int, error) {
+	return uw.conn.WriteToUDP(b, uw.addr)
+}
+
+func udpSendMain(args []string) error {
+	flags := flag.NewFlagSet("udp-send", flag.ExitOnError)
+	var (
+		realtime       = flags.Bool("realtime", false, "pace a capture file to its sample rate, as if it were live")
+		headerInterval = flags.Duration("header-interval", 0, "how often to send the header (default 1s)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap udp-send [flags] <in.rfcap|-> <host:port>\n\n")
+		fmt.Fprintf(flags.Output(), "The host may be a multicast group, such as 239.1.2.3.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	in, err := openCapture(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, hdr, err := openStream(in, *realtime)
+	if err != nil {
+		return err
+	}
+
+	// This isn't connected, so that a unicast receiver going away doesn't
+	// cause later writes to fail.
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sender, err := rfcap.NewUDPSender(udpWriter{conn: conn, addr: addr}, hdr, rfcap.UDPSenderOptions{
+		HeaderInterval: *headerInterval,
+	})
+	if err != nil {
+		return err
+	}
+
+	buf, err := sdr.MakeSamples(r.SampleFormat(), 32*1024)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := sender.Write(buf.Slice(0, n)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func udpRecvMain(args []string) error {
+	flags := flag.NewFlagSet("udp-recv", flag.ExitOnError)
+	var (
+		iface    = flags.String("iface", "", "interface to join the multicast group on")
+		duration = flags.Duration("duration", 0, "how long to record for (default forever)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap udp-recv [flags] <host:port> <out.rfcap|->\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		var ifi *net.Interface
+		if *iface != "" {
+			if ifi, err = net.InterfaceByName(*iface); err != nil {
+				return err
+			}
+		}
+		conn, err = net.ListenMulticastUDP("udp", ifi, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Samples tend to arrive in bursts, so give the kernel plenty of room
+	// to hold on to them.
+	if err := conn.SetReadBuffer(16 * 1024 * 1024); err != nil {
+		return err
+	}
+
+	receiver := rfcap.NewUDPReceiver(conn)
+	hdr, err := receiver.Header()
+	if err != nil {
+		return err
+	}
+
+	out, err := createCapture(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := rfcap.Writer(out, hdr)
+	if err != nil {
+		return err
+	}
+
+	remaining := int64(-1)
+	if *duration > 0 {
+		remaining = int64(duration.Seconds() * float64(hdr.SampleRate))
+	}
+
+	buf, err := sdr.MakeSamples(hdr.SampleFormat, 32*1024)
+	if err != nil {
+		return err
+	}
+	for remaining != 0 {
+		chunk := buf
+		if remaining > 0 && remaining < int64(chunk.Length()) {
+			chunk = buf.Slice(0, int(remaining))
+		}
+		n, err := receiver.Read(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.Slice(0, n)); err != nil {
+			return err
+		}
+		if remaining > 0 {
+			remaining -= int64(n)
+		}
+	}
+
+	stats := receiver.Stats()
+	log.Printf(
+		"%d packets, %d lost, %d reordered, %d invalid, %d sender restarts",
+		stats.Packets, stats.Lost, stats.Reordered, stats.Invalid, stats.Restarts,
+	)
+	return rfcap.Flush(w)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,138 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"hz.tools/rfcap"
+)
+
+// captureVerify is the format used by `rfcap verify --json`.
+type captureVerify struct {
+	Path string `json:"path"`
+	rfcap.VerifyReport
+
+	// Repaired is true if the capture was truncated by --repair.
+	Repaired bool `json:"repaired"`
+}
+
+func verifyCapture(path string) (rfcap.VerifyReport, error) {
+	fd, err := openCapture(path)
+	if err != nil {
+		return rfcap.VerifyReport{}, err
+	}
+	defer fd.Close()
+	return rfcap.Verify(fd)
+}
+
+// repairable will return true if the only errors in the report can be fixed
+// by truncating the capture.
+func repairable(report rfcap.VerifyReport) bool {
+	found := false
+	for _, finding := range report.Findings {
+		if finding.Severity != rfcap.FindingError {
+			continue
+		}
+		if finding.Code != "partial-sample" {
+			return false
+		}
+		found = true
+	}
+	return found && report.ValidLength > 0
+}
+
+func verifyMain(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		asJSON = flags.Bool("json", false, "output one JSON object per capture")
+		repair = flags.Bool("repair", false, "truncate any partial sample at the end of the capture (local files only)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: rfcap verify [--json] [--repair] <file.rfcap|-> ...\n\n")
+		fmt.Fprintf(flags.Output(), "Exits with status 1 if any capture has errors.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitCode(2)
+	}
+	if *repair {
+		for _, path := range flags.Args() {
+			if isRemote(path) {
+				return fmt.Errorf("%s: --repair can only truncate local files, not URLs", path)
+			}
+		}
+	}
+
+	var (
+		enc    = json.NewEncoder(os.Stdout)
+		failed = false
+	)
+	for _, path := range flags.Args() {
+		report, err := verifyCapture(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		result := captureVerify{Path: path, VerifyReport: report}
+		if *repair && path != "-" && repairable(report) {
+			if err := os.Truncate(path, report.ValidLength); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			result.Repaired = true
+		}
+		if !report.OK() && !result.Repaired {
+			failed = true
+		}
+
+		if *asJSON {
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+			continue
+		}
+
+		status := "ok"
+		switch {
+		case result.Repaired:
+			status = fmt.Sprintf("repaired, truncated to %d bytes", report.ValidLength)
+		case !report.OK():
+			status = "FAILED"
+		}
+		fmt.Printf("%s: %s\n", path, status)
+		for _, finding := range report.Findings {
+			fmt.Printf("  %s\n", finding)
+		}
+	}
+
+	if failed {
+		return exitCode(1)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,212 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// IncompatibleError is returned by Concat when a capture can't be joined to
+// the first capture, along with every field that differs.
+type IncompatibleError struct {
+	// Index is the position of the incompatible capture in the list of
+	// captures passed to Concat.
+	Index int
+
+	// Differences describe each field that doesn't match the first capture,
+	// such as "sample rate 2000 != 1000".
+	Differences []string
+}
+
+func (e IncompatibleError) Error() string {
+	return fmt.Sprintf(
+		"rfcap: capture %d is not compatible with capture 0: %s",
+		e.Index, strings.Join(e.Differences, ", "),
+	)
+}
+
+// OverlapError is returned by Concat when a capture starts before the
+// previous capture ended.
+type OverlapError struct {
+	// Index is the position of the overlapping capture in the list of
+	// captures passed to Concat.
+	Index int
+
+	// Overlap is how long both captures cover.
+	Overlap time.Duration
+}
+
+func (e OverlapError) Error() string {
+	return fmt.Sprintf(
+		"rfcap: capture %d starts %s before capture %d ends",
+		e.Index, e.Overlap, e.Index-1,
+	)
+}
+
+// ConcatOptions control how Concat joins captures.
+type ConcatOptions struct {
+	// FillGaps will write silence into any gap between two captures, so
+	// that sample indexes in the output still line up with time. Otherwise,
+	// the captures are written back to back, and the gap is only reported
+	// as an Event.
+	FillGaps bool
+
+	// Tolerance is the amount of time that a capture may start after (or
+	// before) the end of the previous capture, and still be treated as
+	// contiguous. Timestamps are rarely exact, so a little slack is usually
+	// required for rotated segments.
+	Tolerance time.Duration
+}
+
+// compatible will return a description of every field in the Header that
+// prevents it from being joined to the first Header. Byte order and
+// compression don't matter, since every capture is decoded and written in
+// the format of the first.
+func compatible(first, h Header) []string {
+	differences := []string{}
+	if h.SampleRate != first.SampleRate {
+		differences = append(differences, fmt.Sprintf(
+			"sample rate %d != %d", h.SampleRate, first.SampleRate,
+		))
+	}
+	if h.SampleFormat != first.SampleFormat {
+		differences = append(differences, fmt.Sprintf(
+			"sample format %s != %s", h.SampleFormat, first.SampleFormat,
+		))
+	}
+	if h.CenterFrequency != first.CenterFrequency {
+		differences = append(differences, fmt.Sprintf(
+			"center frequency %s != %s", h.CenterFrequency, first.CenterFrequency,
+		))
+	}
+	return differences
+}
+
+// Concat will join the captures read from each io.Reader into one capture
+// written to the io.Writer, with the Header of the first capture. Every
+// capture must have the same sample rate, sample format and center
+// frequency, or an IncompatibleError is returned before anything is written.
+//
+// Captures are expected to be in order. A capture that starts after the
+// previous one ended (going by its CaptureTime and length) is reported as an
+// EventGap, and filled with silence if FillGaps is set. A capture that starts
+// before the previous one ended is an OverlapError.
+func Concat(out io.Writer, opts ConcatOptions, in ...io.Reader) (Header, []Event, error) {
+	if len(in) == 0 {
+		return Header{}, nil, fmt.Errorf("rfcap: no captures to concatenate")
+	}
+
+	headers := make([]Header, len(in))
+	for i, r := range in {
+		h, err := ReadHeader(r)
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("rfcap: capture %d: %w", i, err)
+		}
+		headers[i] = h
+		if i == 0 {
+			continue
+		}
+		if differences := compatible(headers[0], h); len(differences) > 0 {
+			return Header{}, nil, IncompatibleError{Index: i, Differences: differences}
+		}
+	}
+
+	hdr := headers[0]
+	w, err := Writer(out, hdr)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	silence, err := makeSilence(hdr.SampleFormat, 32*1024)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	var (
+		events = []Event{}
+		sample int64
+		end    time.Time
+	)
+	for i, r := range in {
+		h := headers[i]
+
+		if i > 0 {
+			gap := h.CaptureTime.Sub(end)
+			switch {
+			case gap < -opts.Tolerance:
+				return Header{}, nil, OverlapError{Index: i, Overlap: -gap}
+			case gap > opts.Tolerance:
+				length := int64(gap.Seconds() * float64(hdr.SampleRate))
+				events = append(events, Event{
+					Kind:   EventGap,
+					Sample: uint64(sample),
+					Time:   end,
+					Length: uint64(length),
+				})
+				if opts.FillGaps {
+					if err := writeSilence(w, silence, length); err != nil {
+						return Header{}, nil, err
+					}
+					sample += length
+				}
+			}
+		}
+
+		body, err := bodyReader(r, h)
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("rfcap: capture %d: %w", i, err)
+		}
+		n, err := copySamples(w, body, -1)
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("rfcap: capture %d: %w", i, err)
+		}
+		sample += n
+		end = h.CaptureTime.Add(h.Duration(n))
+	}
+
+	if err := Flush(w); err != nil {
+		return Header{}, nil, err
+	}
+	return hdr, events, nil
+}
+
+// writeSilence will write n samples of silence to the sdr.Writer, using
+// the provided buffer of silence as many times as needed.
+func writeSilence(w sdr.Writer, silence sdr.Samples, n int64) error {
+	for n > 0 {
+		chunk := silence
+		if n < int64(chunk.Length()) {
+			chunk = silence.Slice(0, int(n))
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		n -= int64(chunk.Length())
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,153 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// concatSegment is 4 samples of value at 4 samples per second, starting at
+// offset from testEpoch.
+func concatSegment(t *testing.T, offset time.Duration, value int8, order binary.ByteOrder) *bytes.Buffer {
+	samples := sdr.SamplesI8{{value, value}, {value, value}, {value, value}, {value, value}}
+	return testCapture(t, samples, func(h *rfcap.Header) {
+		h.CaptureTime = h.CaptureTime.Add(offset)
+		h.SampleRate = 4
+		h.Endianness = order
+	})
+}
+
+func readConcat(t *testing.T, out *bytes.Buffer) sdr.SamplesI8 {
+	r, _, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	samples := sdr.SamplesI8{}
+	buf := make(sdr.SamplesI8, 3)
+	for {
+		n, err := r.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples
+		}
+		assert.NoError(t, err)
+	}
+}
+
+func TestConcatContiguous(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, events, err := rfcap.Concat(out, rfcap.ConcatOptions{},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		concatSegment(t, time.Second, 2, binary.BigEndian),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, testEpoch.Unix(), hdr.CaptureTime.Unix())
+	assert.Empty(t, events)
+
+	samples := readConcat(t, out)
+	assert.Equal(t, 8, len(samples))
+	assert.Equal(t, [2]int8{1, 1}, samples[3])
+	assert.Equal(t, [2]int8{2, 2}, samples[4])
+}
+
+func TestConcatGap(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, events, err := rfcap.Concat(out, rfcap.ConcatOptions{},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		concatSegment(t, 2*time.Second, 2, binary.LittleEndian),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []rfcap.Event{{
+		Kind:   rfcap.EventGap,
+		Sample: 4,
+		Time:   testEpoch.Add(time.Second),
+		Length: 4,
+	}}, events)
+	assert.Equal(t, 8, len(readConcat(t, out)))
+}
+
+func TestConcatFillGap(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, events, err := rfcap.Concat(out, rfcap.ConcatOptions{FillGaps: true},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		concatSegment(t, 2*time.Second, 2, binary.LittleEndian),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+
+	samples := readConcat(t, out)
+	assert.Equal(t, 12, len(samples))
+	assert.Equal(t, [2]int8{1, 1}, samples[3])
+	assert.Equal(t, [2]int8{0, 0}, samples[4])
+	assert.Equal(t, [2]int8{0, 0}, samples[7])
+	assert.Equal(t, [2]int8{2, 2}, samples[8])
+}
+
+func TestConcatTolerance(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, events, err := rfcap.Concat(out, rfcap.ConcatOptions{Tolerance: 100 * time.Millisecond},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		concatSegment(t, time.Second+50*time.Millisecond, 2, binary.LittleEndian),
+		concatSegment(t, 2*time.Second, 3, binary.LittleEndian),
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestConcatOverlap(t *testing.T) {
+	_, _, err := rfcap.Concat(&bytes.Buffer{}, rfcap.ConcatOptions{},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		concatSegment(t, 500*time.Millisecond, 2, binary.LittleEndian),
+	)
+	assert.Equal(t, rfcap.OverlapError{Index: 1, Overlap: 500 * time.Millisecond}, err)
+}
+
+func TestConcatIncompatible(t *testing.T) {
+	other := testCapture(t, sdr.SamplesI8{{1, 1}}, func(h *rfcap.Header) {
+		h.CaptureTime = h.CaptureTime.Add(time.Second)
+		h.CenterFrequency = 200 * rf.MHz
+		h.SampleRate = 8
+	})
+
+	out := &bytes.Buffer{}
+	_, _, err := rfcap.Concat(out, rfcap.ConcatOptions{},
+		concatSegment(t, 0, 1, binary.LittleEndian),
+		other,
+	)
+	assert.Equal(t, rfcap.IncompatibleError{
+		Index: 1,
+		Differences: []string{
+			"sample rate 8 != 4",
+			"center frequency 200MHz != 100MHz",
+		},
+	}, err)
+	assert.Equal(t, 0, out.Len())
+}
+
+// vim: foldmethod=marker
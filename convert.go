@@ -0,0 +1,151 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+
+	"hz.tools/sdr"
+)
+
+var (
+	// ErrLossyConversion will be returned when a conversion would lose
+	// precision, and lossy conversions were not explicitly allowed.
+	ErrLossyConversion error = fmt.Errorf("rfcap: conversion would lose precision")
+)
+
+// ConvertOptions controls how Convert will transcode a capture.
+type ConvertOptions struct {
+	// AllowLossy will allow conversions that may lose precision, such as
+	// converting complex64 samples to int16 samples, or packing int16
+	// samples into 12 bits.
+	AllowLossy bool
+}
+
+// sampleFormatBits returns the number of bits of precision a sample format
+// is able to carry, including the effect of compression.
+func sampleFormatBits(sf sdr.SampleFormat, compressed bool) int {
+	switch sf {
+	case sdr.SampleFormatU8, sdr.SampleFormatI8:
+		return 8
+	case sdr.SampleFormatI16:
+		if compressed {
+			return 12
+		}
+		return 16
+	case sdr.SampleFormatC64:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// checkLossless will return an ErrLossyConversion if converting a capture
+// from one Header to the other may lose precision. This is decided from the
+// Headers alone, before any samples are read, so packing int16 samples is
+// always treated as lossy, even if every sample happens to fit in 12 bits.
+func checkLossless(from, to Header) error {
+	var (
+		fromBits = sampleFormatBits(from.SampleFormat, from.Compressed)
+		toBits   = sampleFormatBits(to.SampleFormat, to.Compressed)
+	)
+
+	if from.SampleFormat == sdr.SampleFormatC64 && to.SampleFormat != sdr.SampleFormatC64 {
+		return fmt.Errorf("%w: %s to %s", ErrLossyConversion, from.SampleFormat, to.SampleFormat)
+	}
+
+	if toBits < fromBits {
+		return fmt.Errorf(
+			"%w: %d bits to %d bits", ErrLossyConversion, fromBits, toBits,
+		)
+	}
+	return nil
+}
+
+// Convert will read samples from the sdr.Reader, which are described by hdr,
+// and write them to the io.Writer as a new rfcap capture, in a single
+// streaming pass. Only the SampleFormat, Endianness and Compressed fields of
+// the target Header are used; everything else is taken from hdr.
+//
+// Conversions that would lose precision will return an ErrLossyConversion.
+// Use ConvertWithOptions to allow them.
+func Convert(r sdr.Reader, hdr Header, w io.Writer, target Header) error {
+	return ConvertWithOptions(r, hdr, w, target, ConvertOptions{})
+}
+
+// ConvertWithOptions will Convert a capture, as controlled by the provided
+// ConvertOptions.
+func ConvertWithOptions(
+	r sdr.Reader,
+	hdr Header,
+	w io.Writer,
+	target Header,
+	opts ConvertOptions,
+) error {
+	if r.SampleFormat() != hdr.SampleFormat {
+		return sdr.ErrSampleFormatMismatch
+	}
+
+	out := hdr
+	out.SampleFormat = target.SampleFormat
+	out.Endianness = target.Endianness
+	out.Compressed = target.Compressed
+
+	if !opts.AllowLossy {
+		if err := checkLossless(hdr, out); err != nil {
+			return err
+		}
+	}
+
+	cr, err := newConvertReader(r, out.SampleFormat)
+	if err != nil {
+		return err
+	}
+
+	writer, err := Writer(w, out)
+	if err != nil {
+		return err
+	}
+
+	buf, err := sdr.MakeSamples(out.SampleFormat, 32*1024)
+	if err != nil {
+		return err
+	}
+
+	for {
+		n, err := cr.Read(buf)
+		if n > 0 {
+			if _, werr := writer.Write(buf.Slice(0, n)); werr != nil {
+				return werr
+			}
+		}
+		switch err {
+		case nil:
+		case io.EOF:
+			return Flush(writer)
+		default:
+			return err
+		}
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,168 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// testEpoch is the CaptureTime of every testHeader.
+var testEpoch = time.Unix(1600000000, 0)
+
+// testHeader will return the Header used by most tests: a little endian
+// capture at 100 MHz and 1 ksps, starting at testEpoch.
+func testHeader(format sdr.SampleFormat) rfcap.Header {
+	return rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     testEpoch,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    format,
+		Endianness:      binary.LittleEndian,
+	}
+}
+
+// testCapture will write the samples to a capture with a testHeader, after
+// passing the Header to mutate, if it's not nil.
+func testCapture(t *testing.T, samples sdr.Samples, mutate func(*rfcap.Header)) *bytes.Buffer {
+	hdr := testHeader(samples.Format())
+	if mutate != nil {
+		mutate(&hdr)
+	}
+	return captureBuffer(t, hdr, samples)
+}
+
+// captureBuffer will write the samples to a capture with the provided Header.
+func captureBuffer(t *testing.T, header rfcap.Header, samples sdr.Samples) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	w, err := rfcap.Writer(buf, header)
+	assert.NoError(t, err)
+	_, err = w.Write(samples)
+	assert.NoError(t, err)
+	assert.NoError(t, rfcap.Flush(w))
+	return buf
+}
+
+func TestConvertI16ToC64(t *testing.T) {
+	in := captureBuffer(t, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CenterFrequency: rf.MustParseHz("100MHz"),
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.BigEndian,
+	}, sdr.SamplesI16{{32767, -32767}, {0, 0}})
+
+	r, hdr, err := rfcap.Reader(in)
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, rfcap.Convert(r, hdr, out, rfcap.Header{
+		SampleFormat: sdr.SampleFormatC64,
+		Endianness:   binary.LittleEndian,
+	}))
+
+	r, hdr, err = rfcap.Reader(out)
+	assert.NoError(t, err)
+	assert.Equal(t, sdr.SampleFormatC64, hdr.SampleFormat)
+	assert.Equal(t, binary.LittleEndian, hdr.Endianness)
+	assert.Equal(t, rf.MustParseHz("100MHz"), hdr.CenterFrequency)
+
+	samples := make(sdr.SamplesC64, 2)
+	n, err := sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, sdr.SamplesC64{complex(1, -1), 0}, samples)
+}
+
+func TestConvertLossy(t *testing.T) {
+	header := rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+	}
+
+	for _, target := range []rfcap.Header{
+		{SampleFormat: sdr.SampleFormatU8},
+		{SampleFormat: sdr.SampleFormatI16, Compressed: true, Endianness: binary.LittleEndian},
+	} {
+		r, hdr, err := rfcap.Reader(captureBuffer(t, header, sdr.SamplesI16{{1, 1}}))
+		assert.NoError(t, err)
+		err = rfcap.Convert(r, hdr, &bytes.Buffer{}, target)
+		assert.True(t, errors.Is(err, rfcap.ErrLossyConversion))
+	}
+
+	r, hdr, err := rfcap.Reader(captureBuffer(t, header, sdr.SamplesI16{{1, 1}}))
+	assert.NoError(t, err)
+	assert.NoError(t, rfcap.ConvertWithOptions(r, hdr, &bytes.Buffer{}, rfcap.Header{
+		SampleFormat: sdr.SampleFormatU8,
+	}, rfcap.ConvertOptions{AllowLossy: true}))
+}
+
+func TestConvertCompress(t *testing.T) {
+	ref := sdr.SamplesU8{{0, 255}, {1, 254}, {2, 253}, {3, 252}, {4, 251}, {5, 250}, {6, 249}, {7, 248}}
+	in := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatU8,
+	}, ref)
+
+	r, hdr, err := rfcap.Reader(in)
+	assert.NoError(t, err)
+
+	packed := &bytes.Buffer{}
+	assert.NoError(t, rfcap.Convert(r, hdr, packed, rfcap.Header{
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+		Compressed:   true,
+	}))
+
+	r, hdr, err = rfcap.Reader(packed)
+	assert.NoError(t, err)
+	assert.True(t, hdr.Compressed)
+
+	// This is lossy in general, but not for samples that started out as
+	// uint8 samples.
+	out := &bytes.Buffer{}
+	assert.NoError(t, rfcap.ConvertWithOptions(r, hdr, out, rfcap.Header{
+		SampleFormat: sdr.SampleFormatU8,
+	}, rfcap.ConvertOptions{AllowLossy: true}))
+
+	r, _, err = rfcap.Reader(out)
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesU8, len(ref))
+	n, err := sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ref), n)
+	assert.Equal(t, ref, samples)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,216 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// DiffOptions control how closely two captures must match for Diff.
+type DiffOptions struct {
+	// AbsoluteTolerance is how far apart two samples may be, where 1 is
+	// full scale, and still be considered the same.
+	AbsoluteTolerance float64
+
+	// RelativeTolerance is how far apart two samples may be, as a fraction
+	// of the magnitude of the expected sample, and still be considered the
+	// same. This is added to the AbsoluteTolerance.
+	RelativeTolerance float64
+
+	// MinSNR is the ratio of the expected signal to the error, in dB, at or
+	// above which the captures are considered to match. If this is set,
+	// captures are matched by SNR rather than sample by sample.
+	MinSNR float64
+
+	// CompareCaptureTime will also require the CaptureTime of both captures
+	// to match. This is off by default, since output from the same pipeline
+	// run at different times should still match.
+	CompareCaptureTime bool
+}
+
+// DiffReport is the result of comparing two captures with Diff.
+type DiffReport struct {
+	// HeaderDifferences describe each field of the Header that doesn't
+	// match. The sample format, byte order and compression don't need to
+	// match, since samples are compared after converting to complex64.
+	HeaderDifferences []string
+
+	// WantSamples and GotSamples are the number of samples in each capture.
+	WantSamples int64
+	GotSamples  int64
+
+	// FirstDivergence is the index of the first sample outside of the
+	// tolerance, or -1 if every sample is within it.
+	FirstDivergence int64
+
+	// MaxError is the largest distance between two samples, where 1 is
+	// full scale, and MaxErrorSample is the index at which it happened.
+	MaxError       float64
+	MaxErrorSample int64
+
+	// ErrorEnergy is the sum of the squared distance between every pair of
+	// samples, and SignalEnergy is the sum of the squared magnitude of
+	// every expected sample.
+	ErrorEnergy  float64
+	SignalEnergy float64
+
+	// Match is true if the captures match given the DiffOptions.
+	Match bool
+}
+
+// SNR will return the ratio of the expected signal to the error, in dB. If
+// the captures are identical, this is +Inf.
+func (r DiffReport) SNR() float64 {
+	if r.ErrorEnergy == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(r.SignalEnergy/r.ErrorEnergy)
+}
+
+// diffHeaders will describe every field that differs between the Headers.
+func diffHeaders(want, got Header, opts DiffOptions) []string {
+	differences := []string{}
+	if want.SampleRate != got.SampleRate {
+		differences = append(differences, fmt.Sprintf(
+			"sample rate %d != %d", got.SampleRate, want.SampleRate,
+		))
+	}
+	if want.CenterFrequency != got.CenterFrequency {
+		differences = append(differences, fmt.Sprintf(
+			"center frequency %s != %s", got.CenterFrequency, want.CenterFrequency,
+		))
+	}
+	if opts.CompareCaptureTime && !want.CaptureTime.Equal(got.CaptureTime) {
+		differences = append(differences, fmt.Sprintf(
+			"capture time %s != %s",
+			got.CaptureTime.UTC().Format(time.RFC3339Nano),
+			want.CaptureTime.UTC().Format(time.RFC3339Nano),
+		))
+	}
+	return differences
+}
+
+// Diff will compare the capture read from got against the expected capture
+// read from want, sample by sample, after converting both to complex64, so
+// captures in different sample formats can be compared. This is intended for
+// comparing the output of DSP code against known good output.
+func Diff(want, got io.Reader, opts DiffOptions) (DiffReport, error) {
+	wantReader, wantHdr, err := Reader(want)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("rfcap: want: %w", err)
+	}
+	gotReader, gotHdr, err := Reader(got)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("rfcap: got: %w", err)
+	}
+
+	if wantReader, err = newConvertReader(wantReader, sdr.SampleFormatC64); err != nil {
+		return DiffReport{}, err
+	}
+	if gotReader, err = newConvertReader(gotReader, sdr.SampleFormatC64); err != nil {
+		return DiffReport{}, err
+	}
+
+	report := DiffReport{
+		HeaderDifferences: diffHeaders(wantHdr, gotHdr, opts),
+		FirstDivergence:   -1,
+	}
+
+	var (
+		wantBuf = make(sdr.SamplesC64, 32*1024)
+		gotBuf  = make(sdr.SamplesC64, 32*1024)
+		wantEOF bool
+		gotEOF  bool
+	)
+	for !wantEOF || !gotEOF {
+		var wantN, gotN int
+		if !wantEOF {
+			wantN, err = readSamples(wantReader, wantBuf)
+			switch err {
+			case nil:
+			case io.EOF:
+				wantEOF = true
+			default:
+				return DiffReport{}, fmt.Errorf("rfcap: want: %w", err)
+			}
+		}
+		if !gotEOF {
+			gotN, err = readSamples(gotReader, gotBuf)
+			switch err {
+			case nil:
+			case io.EOF:
+				gotEOF = true
+			default:
+				return DiffReport{}, fmt.Errorf("rfcap: got: %w", err)
+			}
+		}
+
+		n := wantN
+		if gotN < n {
+			n = gotN
+		}
+		for i := 0; i < n; i++ {
+			var (
+				w     = complex128(wantBuf[i])
+				dist  = cmplx.Abs(complex128(gotBuf[i]) - w)
+				index = report.WantSamples + int64(i)
+			)
+			report.ErrorEnergy += dist * dist
+			report.SignalEnergy += real(w)*real(w) + imag(w)*imag(w)
+			if dist > report.MaxError {
+				report.MaxError = dist
+				report.MaxErrorSample = index
+			}
+			if report.FirstDivergence < 0 &&
+				dist > opts.AbsoluteTolerance+opts.RelativeTolerance*cmplx.Abs(w) {
+				report.FirstDivergence = index
+			}
+		}
+
+		report.WantSamples += int64(wantN)
+		report.GotSamples += int64(gotN)
+	}
+
+	if report.WantSamples != report.GotSamples && report.FirstDivergence < 0 {
+		shortest := report.WantSamples
+		if report.GotSamples < shortest {
+			shortest = report.GotSamples
+		}
+		report.FirstDivergence = shortest
+	}
+
+	report.Match = len(report.HeaderDifferences) == 0 &&
+		report.WantSamples == report.GotSamples
+	if opts.MinSNR != 0 {
+		report.Match = report.Match && report.SNR() >= opts.MinSNR
+	} else {
+		report.Match = report.Match && report.FirstDivergence < 0
+	}
+	return report, nil
+}
+
+// vim: foldmethod=marker
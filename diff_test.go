@@ -0,0 +1,135 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func TestDiffIdentical(t *testing.T) {
+	samples := sdr.SamplesC64{0.5, 0.25i, -0.5}
+	report, err := rfcap.Diff(testCapture(t, samples, nil), testCapture(t, samples, nil), rfcap.DiffOptions{})
+	assert.NoError(t, err)
+	assert.True(t, report.Match)
+	assert.Equal(t, int64(-1), report.FirstDivergence)
+	assert.Equal(t, int64(3), report.WantSamples)
+	assert.Equal(t, float64(0), report.ErrorEnergy)
+	assert.True(t, math.IsInf(report.SNR(), 1))
+}
+
+func TestDiffTolerance(t *testing.T) {
+	var (
+		want = sdr.SamplesC64{0.5, 0.5, 0.5, 0.5}
+		got  = sdr.SamplesC64{0.5, 0.501, 0.6, 0.5}
+	)
+
+	report, err := rfcap.Diff(testCapture(t, want, nil), testCapture(t, got, nil), rfcap.DiffOptions{
+		AbsoluteTolerance: 0.01,
+	})
+	assert.NoError(t, err)
+	assert.False(t, report.Match)
+	assert.Equal(t, int64(2), report.FirstDivergence)
+	assert.Equal(t, int64(2), report.MaxErrorSample)
+	assert.InDelta(t, 0.1, report.MaxError, 1e-6)
+	assert.InDelta(t, 0.001*0.001+0.1*0.1, report.ErrorEnergy, 1e-6)
+
+	report, err = rfcap.Diff(testCapture(t, want, nil), testCapture(t, got, nil), rfcap.DiffOptions{
+		RelativeTolerance: 0.25,
+	})
+	assert.NoError(t, err)
+	assert.True(t, report.Match)
+}
+
+func TestDiffSNR(t *testing.T) {
+	var (
+		want = sdr.SamplesC64{1, 1, 1, 1}
+		got  = sdr.SamplesC64{1, 1, 1, 1.1}
+	)
+
+	// Signal energy is 4, and error energy is 0.01, so the SNR is ~26 dB.
+	report, err := rfcap.Diff(testCapture(t, want, nil), testCapture(t, got, nil), rfcap.DiffOptions{
+		MinSNR: 20,
+	})
+	assert.NoError(t, err)
+	assert.True(t, report.Match)
+	assert.InDelta(t, 26.02, report.SNR(), 0.01)
+
+	report, err = rfcap.Diff(testCapture(t, want, nil), testCapture(t, got, nil), rfcap.DiffOptions{
+		MinSNR: 30,
+	})
+	assert.NoError(t, err)
+	assert.False(t, report.Match)
+}
+
+func TestDiffAcrossFormats(t *testing.T) {
+	var (
+		want = sdr.SamplesC64{0.5, -0.5i}
+		got  = sdr.SamplesI16{{16384, 0}, {0, -16384}}
+	)
+	report, err := rfcap.Diff(testCapture(t, want, nil), testCapture(t, got, func(h *rfcap.Header) {
+		h.Endianness = binary.BigEndian
+	}), rfcap.DiffOptions{
+		AbsoluteTolerance: 1.0 / 32768,
+	})
+	assert.NoError(t, err)
+	assert.True(t, report.Match)
+}
+
+func TestDiffLength(t *testing.T) {
+	report, err := rfcap.Diff(
+		testCapture(t, sdr.SamplesC64{1, 1, 1}, nil),
+		testCapture(t, sdr.SamplesC64{1, 1}, nil),
+		rfcap.DiffOptions{},
+	)
+	assert.NoError(t, err)
+	assert.False(t, report.Match)
+	assert.Equal(t, int64(3), report.WantSamples)
+	assert.Equal(t, int64(2), report.GotSamples)
+	assert.Equal(t, int64(2), report.FirstDivergence)
+}
+
+func TestDiffHeader(t *testing.T) {
+	samples := sdr.SamplesC64{1}
+	report, err := rfcap.Diff(testCapture(t, samples, nil), testCapture(t, samples, func(h *rfcap.Header) {
+		h.SampleRate = 2000
+		h.CaptureTime = h.CaptureTime.Add(time.Hour)
+	}), rfcap.DiffOptions{})
+	assert.NoError(t, err)
+	assert.False(t, report.Match)
+	assert.Equal(t, []string{"sample rate 2000 != 1000"}, report.HeaderDifferences)
+
+	report, err = rfcap.Diff(testCapture(t, samples, nil), testCapture(t, samples, func(h *rfcap.Header) {
+		h.CaptureTime = h.CaptureTime.Add(time.Hour)
+	}), rfcap.DiffOptions{CompareCaptureTime: true})
+	assert.NoError(t, err)
+	assert.False(t, report.Match)
+	assert.Equal(t, 1, len(report.HeaderDifferences))
+}
+
+// vim: foldmethod=marker
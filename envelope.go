@@ -0,0 +1,646 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"hz.tools/rfcap/internal/hkdf"
+	"hz.tools/rfcap/internal/pbkdf2"
+	"hz.tools/sdr"
+)
+
+// An envelope wraps an rfcap stream in chunked AES-GCM, so that it can be
+// stored or sent somewhere it might be read or tampered with. It starts with
+// a preamble, with all integers little endian:
+//
+//	bytes 0-7    magic, "RFCAPENC"
+//	byte 8       version, 1
+//	byte 9       flags; bit 0 is set if the Header is encrypted
+//	byte 10      key derivation; 0 for a raw key, 1 for PBKDF2-HMAC-SHA256
+//	byte 11      key length, in bytes
+//	bytes 12-15  chunk size, in bytes of plaintext
+//	bytes 16-19  PBKDF2 iterations
+//	bytes 20-35  salt, random for each envelope
+//	bytes 36-42  nonce prefix, random for each envelope
+//	byte 43      reserved
+//	bytes 44-47  length of the plaintext Header
+//
+// followed by the marshaled Header, unless it's encrypted. The rest of the
+// stream (the Header, if it's encrypted, and the samples) is split into
+// chunks, each of which is a uint32 of the ciphertext length, with the top
+// bit set on the last chunk, followed by the ciphertext.
+//
+// The AES key for each envelope is derived with HKDF-SHA256 from the salt and
+// either the raw key, or the key derived from the passphrase with PBKDF2.
+// Since every envelope has its own key, nonces can't be reused between
+// envelopes sealed with the same key, whatever happens to the nonce prefix.
+//
+// Each chunk's nonce is the nonce prefix, a big endian uint32 chunk counter,
+// and a byte set to 1 for the last chunk. The preamble and plaintext Header
+// are authenticated along with every chunk, so none of it can be changed,
+// and chunks can't be reordered, dropped, or cut off at the end without the
+// reader noticing.
+const (
+	envelopePreambleLength = 48
+
+	envelopeFlagHeaderEncrypted = 1 << 0
+
+	envelopeKDFRaw    = 0
+	envelopeKDFPBKDF2 = 1
+
+	envelopeLastChunk = 1 << 31
+
+	// envelopeMaxChunk and envelopeMaxHeader keep a corrupt preamble from
+	// making the reader allocate huge buffers.
+	envelopeMaxChunk  = 16 * 1024 * 1024
+	envelopeMaxHeader = 1024 * 1024
+
+	// EnvelopeDefaultIterations is the number of PBKDF2 iterations used to
+	// derive a key from a passphrase if none is given.
+	EnvelopeDefaultIterations = 600000
+
+	// envelopeMaxIterations keeps a corrupt or malicious preamble from
+	// making the reader spend forever deriving a key.
+	envelopeMaxIterations = 10 * EnvelopeDefaultIterations
+
+	// EnvelopeDefaultChunkSize is the chunk size used if none is given.
+	EnvelopeDefaultChunkSize = 64 * 1024
+)
+
+var (
+	envelopeMagic = []byte("RFCAPENC")
+
+	// envelopeKeyInfo is the HKDF info used to derive the key for an
+	// envelope.
+	envelopeKeyInfo = []byte("rfcap envelope key")
+)
+
+var (
+	// ErrEnvelopeAuth is returned when reading an envelope that has been
+	// tampered with, or was sealed with a different key.
+	ErrEnvelopeAuth = errors.New("rfcap: envelope failed authentication")
+
+	// ErrHeaderEncrypted is returned by ReadEnvelopeHeader if the Header
+	// can't be read without the key.
+	ErrHeaderEncrypted = errors.New("rfcap: envelope header is encrypted")
+)
+
+// EnvelopeOptions control how an envelope is sealed and opened.
+type EnvelopeOptions struct {
+	// Key is a raw AES-128, AES-192 or AES-256 key. Exactly one of Key or
+	// Passphrase must be set.
+	Key []byte
+
+	// Passphrase is turned into an AES-256 key with PBKDF2-HMAC-SHA256 and a
+	// random salt.
+	Passphrase string
+
+	// Iterations is the number of PBKDF2 iterations used when sealing with
+	// a Passphrase. This defaults to EnvelopeDefaultIterations, and may be
+	// at most 10 times that. When opening, the count stored in the envelope
+	// is used.
+	Iterations int
+
+	// ChunkSize is the number of bytes of plaintext in each chunk, and
+	// defaults to EnvelopeDefaultChunkSize. Nothing is read back until a
+	// whole chunk has been authenticated, so smaller chunks mean less
+	// latency on a live stream. This is only used when sealing.
+	ChunkSize int
+
+	// EncryptHeader will encrypt the Header as well as the samples. By
+	// default, the Header is left readable (but can't be changed), so that
+	// tools can see what a capture is without the key.
+	EncryptHeader bool
+}
+
+// envelopePreamble is the decoded preamble of an envelope.
+type envelopePreamble struct {
+	flags      byte
+	kdf        byte
+	keyLength  byte
+	chunkSize  uint32
+	iterations uint32
+	salt       [16]byte
+	prefix     [7]byte
+	header     []byte
+}
+
+func (p envelopePreamble) marshal() []byte {
+	b := make([]byte, envelopePreambleLength, envelopePreambleLength+len(p.header))
+	copy(b, envelopeMagic)
+	b[8] = 1
+	b[9] = p.flags
+	b[10] = p.kdf
+	b[11] = p.keyLength
+	binary.LittleEndian.PutUint32(b[12:16], p.chunkSize)
+	binary.LittleEndian.PutUint32(b[16:20], p.iterations)
+	copy(b[20:36], p.salt[:])
+	copy(b[36:43], p.prefix[:])
+	binary.LittleEndian.PutUint32(b[44:48], uint32(len(p.header)))
+	return append(b, p.header...)
+}
+
+func readEnvelopePreamble(in io.Reader) (envelopePreamble, []byte, error) {
+	b := make([]byte, envelopePreambleLength)
+	if _, err := io.ReadFull(in, b); err != nil {
+		return envelopePreamble{}, nil, err
+	}
+	if !bytes.Equal(b[:8], envelopeMagic) {
+		return envelopePreamble{}, nil, fmt.Errorf("rfcap: not an rfcap envelope")
+	}
+	if b[8] != 1 {
+		return envelopePreamble{}, nil, fmt.Errorf("rfcap: unsupported envelope version %d", b[8])
+	}
+
+	p := envelopePreamble{
+		flags:      b[9],
+		kdf:        b[10],
+		keyLength:  b[11],
+		chunkSize:  binary.LittleEndian.Uint32(b[12:16]),
+		iterations: binary.LittleEndian.Uint32(b[16:20]),
+	}
+	copy(p.salt[:], b[20:36])
+	copy(p.prefix[:], b[36:43])
+
+	if p.chunkSize == 0 || p.chunkSize > envelopeMaxChunk {
+		return envelopePreamble{}, nil, fmt.Errorf("rfcap: envelope chunk size %d is invalid", p.chunkSize)
+	}
+	if p.kdf == envelopeKDFPBKDF2 && (p.iterations == 0 || p.iterations > envelopeMaxIterations) {
+		return envelopePreamble{}, nil, fmt.Errorf("rfcap: envelope iterations %d is invalid", p.iterations)
+	}
+	headerLength := binary.LittleEndian.Uint32(b[44:48])
+	if headerLength > envelopeMaxHeader {
+		return envelopePreamble{}, nil, fmt.Errorf("rfcap: envelope header is too large")
+	}
+	p.header = make([]byte, headerLength)
+	if _, err := io.ReadFull(in, p.header); err != nil {
+		return envelopePreamble{}, nil, err
+	}
+	return p, append(b, p.header...), nil
+}
+
+// key will return the AES key for the envelope.
+func (p envelopePreamble) key(opts EnvelopeOptions) ([]byte, error) {
+	var secret []byte
+	switch p.kdf {
+	case envelopeKDFRaw:
+		if opts.Key == nil {
+			return nil, fmt.Errorf("rfcap: envelope was sealed with a key, not a passphrase")
+		}
+		if len(opts.Key) != int(p.keyLength) {
+			return nil, ErrEnvelopeAuth
+		}
+		secret = opts.Key
+	case envelopeKDFPBKDF2:
+		if opts.Passphrase == "" {
+			return nil, fmt.Errorf("rfcap: envelope was sealed with a passphrase, not a key")
+		}
+		secret = pbkdf2.Key(
+			[]byte(opts.Passphrase), p.salt[:], int(p.iterations), int(p.keyLength),
+		)
+	default:
+		return nil, fmt.Errorf("rfcap: unknown envelope key derivation %d", p.kdf)
+	}
+	return hkdf.Key(secret, p.salt[:], envelopeKeyInfo, int(p.keyLength)), nil
+}
+
+// nonce will return the nonce of the provided chunk.
+func (p envelopePreamble) nonce(chunk uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, p.prefix[:])
+	binary.BigEndian.PutUint32(nonce[7:11], chunk)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeWriter is the io.WriteCloser that seals the chunks of an
+// envelope.
+type envelopeWriter struct {
+	out      io.Writer
+	preamble envelopePreamble
+	aad      []byte
+	aead     cipher.AEAD
+	buf      []byte
+	chunk    uint32
+	closed   bool
+}
+
+func newEnvelopeWriter(out io.Writer, header []byte, opts EnvelopeOptions) (*envelopeWriter, error) {
+	p := envelopePreamble{chunkSize: uint32(opts.ChunkSize)}
+	if opts.ChunkSize == 0 {
+		p.chunkSize = EnvelopeDefaultChunkSize
+	}
+	if opts.ChunkSize < 0 || p.chunkSize > envelopeMaxChunk {
+		return nil, fmt.Errorf("rfcap: envelope chunk size %d is invalid", opts.ChunkSize)
+	}
+	if _, err := rand.Read(p.prefix[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(p.salt[:]); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.Key != nil && opts.Passphrase != "":
+		return nil, fmt.Errorf("rfcap: only one of a key or passphrase can be used")
+	case opts.Key != nil:
+		switch len(opts.Key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("rfcap: envelope key must be 16, 24 or 32 bytes")
+		}
+		p.kdf, p.keyLength = envelopeKDFRaw, byte(len(opts.Key))
+	case opts.Passphrase != "":
+		p.kdf, p.keyLength, p.iterations = envelopeKDFPBKDF2, 32, uint32(opts.Iterations)
+		if opts.Iterations == 0 {
+			p.iterations = EnvelopeDefaultIterations
+		}
+		if opts.Iterations < 0 || opts.Iterations > envelopeMaxIterations {
+			return nil, fmt.Errorf("rfcap: envelope iterations %d is invalid", opts.Iterations)
+		}
+	default:
+		return nil, fmt.Errorf("rfcap: a key or passphrase is needed")
+	}
+
+	key, err := p.key(opts)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.EncryptHeader {
+		p.flags |= envelopeFlagHeaderEncrypted
+	} else {
+		p.header = header
+	}
+
+	ew := &envelopeWriter{
+		out:      out,
+		preamble: p,
+		aad:      p.marshal(),
+		aead:     aead,
+		buf:      make([]byte, 0, p.chunkSize),
+	}
+	if _, err := out.Write(ew.aad); err != nil {
+		return nil, err
+	}
+	if opts.EncryptHeader {
+		if _, err := ew.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return ew, nil
+}
+
+// seal will write out what's buffered as the next chunk.
+func (ew *envelopeWriter) seal(last bool) error {
+	if ew.chunk == ^uint32(0) && !last {
+		return fmt.Errorf("rfcap: envelope is out of chunks")
+	}
+
+	frame := make([]byte, 4, 4+len(ew.buf)+ew.aead.Overhead())
+	frame = ew.aead.Seal(frame, ew.preamble.nonce(ew.chunk, last), ew.buf, ew.aad)
+	length := uint32(len(frame) - 4)
+	if last {
+		length |= envelopeLastChunk
+	}
+	binary.LittleEndian.PutUint32(frame[:4], length)
+
+	// Each chunk is written in a single call, like the Header, so it's
+	// never split up on a socket.
+	if _, err := ew.out.Write(frame); err != nil {
+		return err
+	}
+	ew.chunk++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+func (ew *envelopeWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, fmt.Errorf("rfcap: write to a closed envelope")
+	}
+	var written int
+	for len(p) > 0 {
+		// A full chunk is held on to until there's more, since we can't
+		// know if it's the last one until then.
+		if len(ew.buf) == cap(ew.buf) {
+			if err := ew.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *envelopeWriter) Flush() error {
+	if ew.closed || len(ew.buf) == 0 {
+		return nil
+	}
+	return ew.seal(false)
+}
+
+func (ew *envelopeWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(true)
+}
+
+// EnvelopeWriter is an sdr.Writer that seals the samples written to it into
+// an envelope. Close must be called once all samples are written, to write
+// the last chunk; without it the envelope will look truncated to a reader.
+type EnvelopeWriter struct {
+	sdr.Writer
+	ew *envelopeWriter
+}
+
+// SealWriter will write the envelope preamble to the io.Writer, and return
+// an EnvelopeWriter of samples described by the Header.
+func SealWriter(out io.Writer, header Header, opts EnvelopeOptions) (*EnvelopeWriter, error) {
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+	marshaled, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	ew, err := newEnvelopeWriter(out, marshaled, opts)
+	if err != nil {
+		return nil, err
+	}
+	w, err := bodyWriter(ew, header)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvelopeWriter{Writer: w, ew: ew}, nil
+}
+
+// Flush will seal any samples written so far into a chunk of their own, so
+// that a reader on the other end of a live stream can get at them without
+// waiting for a whole chunk.
+func (w *EnvelopeWriter) Flush() error {
+	return w.ew.Flush()
+}
+
+// Close will write the last chunk. This does not close the underlying
+// io.Writer.
+func (w *EnvelopeWriter) Close() error {
+	if err := Flush(w.Writer); err != nil {
+		return err
+	}
+	return w.ew.Close()
+}
+
+// envelopeReader is an io.Reader of the plaintext of an envelope, which
+// will only return bytes from a chunk once the whole chunk has been
+// authenticated.
+type envelopeReader struct {
+	in       io.Reader
+	preamble envelopePreamble
+	aad      []byte
+	aead     cipher.AEAD
+	chunk    uint32
+	buf      []byte
+	plain    []byte
+	last     bool
+}
+
+func (er *envelopeReader) next() error {
+	var length [4]byte
+	if _, err := io.ReadFull(er.in, length[:]); err != nil {
+		if err == io.EOF {
+			// The stream can't end without a last chunk.
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	var (
+		n    = binary.LittleEndian.Uint32(length[:])
+		last = n&envelopeLastChunk != 0
+	)
+	n &^= envelopeLastChunk
+	if n < uint32(er.aead.Overhead()) || n > er.preamble.chunkSize+uint32(er.aead.Overhead()) {
+		return ErrEnvelopeAuth
+	}
+
+	er.buf = er.buf[:n]
+	if _, err := io.ReadFull(er.in, er.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := er.aead.Open(er.buf[:0], er.preamble.nonce(er.chunk, last), er.buf, er.aad)
+	if err != nil {
+		return ErrEnvelopeAuth
+	}
+	er.chunk++
+	er.plain, er.last = plain, last
+	return nil
+}
+
+func (er *envelopeReader) Read(p []byte) (int, error) {
+	for len(er.plain) == 0 {
+		if er.last {
+			return 0, io.EOF
+		}
+		if err := er.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.plain)
+	er.plain = er.plain[n:]
+	return n, nil
+}
+
+func openEnvelope(in io.Reader, opts EnvelopeOptions) (*envelopeReader, envelopePreamble, error) {
+	p, aad, err := readEnvelopePreamble(in)
+	if err != nil {
+		return nil, p, err
+	}
+	key, err := p.key(opts)
+	if err != nil {
+		return nil, p, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, p, err
+	}
+	return &envelopeReader{
+		in:       in,
+		preamble: p,
+		aad:      aad,
+		aead:     aead,
+		buf:      make([]byte, int(p.chunkSize)+aead.Overhead()),
+	}, p, nil
+}
+
+// OpenReader will read an envelope from the io.Reader, and return an
+// sdr.Reader of the samples inside it, along with the Header. Each chunk is
+// authenticated before any samples from it are returned, and ErrEnvelopeAuth
+// is returned if one has been tampered with. If the envelope is cut short,
+// io.ErrUnexpectedEOF is returned rather than io.EOF.
+//
+// If the Header isn't encrypted, this will fail with ErrEnvelopeAuth before
+// returning if the Header has been changed, as long as the envelope has at
+// least one chunk. Nothing is read past the last chunk, so an envelope can be
+// followed by something else on the same stream, such as a net.Conn; it's up
+// to the caller to decide what to do with anything that follows it.
+func OpenReader(in io.Reader, opts EnvelopeOptions) (sdr.Reader, Header, error) {
+	er, p, err := openEnvelope(in, opts)
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	if p.flags&envelopeFlagHeaderEncrypted != 0 {
+		return Reader(er)
+	}
+
+	// Authenticate the first chunk now, so that a changed Header is caught
+	// before anyone goes by it.
+	if err := er.next(); err != nil {
+		return nil, Header{}, err
+	}
+	hdr := Header{}
+	if err := hdr.Unmarshal(p.header); err != nil {
+		return nil, Header{}, err
+	}
+	r, err := bodyReader(er, hdr)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	return r, hdr, nil
+}
+
+// ReadEnvelopeHeader will return the Header of an envelope without the key,
+// or ErrHeaderEncrypted if it was sealed with EncryptHeader. The Header has
+// not been authenticated, so it should only be used for display.
+func ReadEnvelopeHeader(in io.Reader) (Header, error) {
+	p, _, err := readEnvelopePreamble(in)
+	if err != nil {
+		return Header{}, err
+	}
+	if p.flags&envelopeFlagHeaderEncrypted != 0 {
+		return Header{}, ErrHeaderEncrypted
+	}
+	hdr := Header{}
+	if err := hdr.Unmarshal(p.header); err != nil {
+		return Header{}, err
+	}
+	return hdr, nil
+}
+
+// Seal will read an rfcap stream from the io.Reader, and write it to the
+// io.Writer in an envelope.
+func Seal(in io.Reader, out io.Writer, opts EnvelopeOptions) error {
+	hdr, err := ReadHeader(in)
+	if err != nil {
+		return err
+	}
+	marshaled, err := hdr.Marshal()
+	if err != nil {
+		return err
+	}
+	ew, err := newEnvelopeWriter(out, marshaled, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, in); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// Open will read an envelope from the io.Reader, and write the rfcap stream
+// inside it to the io.Writer. Since each chunk is written out once it has
+// been authenticated, if this fails partway through, what was written so
+// far can be trusted, but is incomplete.
+//
+// If the io.Reader is a regular file, anything following the last chunk is
+// an error, since it hasn't been authenticated. Other streams aren't read
+// past the last chunk, since they may never end.
+func Open(in io.Reader, out io.Writer, opts EnvelopeOptions) error {
+	er, p, err := openEnvelope(in, opts)
+	if err != nil {
+		return err
+	}
+	if p.flags&envelopeFlagHeaderEncrypted == 0 {
+		// Make sure the Header is authentic before writing it out.
+		if err := er.next(); err != nil {
+			return err
+		}
+		if _, err := out.Write(p.header); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(out, er); err != nil {
+		return err
+	}
+
+	f, ok := in.(*os.File)
+	if !ok {
+		return nil
+	}
+	if stat, err := f.Stat(); err != nil || !stat.Mode().IsRegular() {
+		return nil
+	}
+	var extra [1]byte
+	switch _, err := io.ReadFull(f, extra[:]); err {
+	case io.EOF:
+		return nil
+	case nil:
+		return fmt.Errorf("rfcap: data follows the end of the envelope")
+	default:
+		return err
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,332 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+var (
+	envelopeKey = bytes.Repeat([]byte{0x42}, 32)
+
+	envelopeHeader = rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Unix(1600000000, 0),
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.LittleEndian,
+	}
+)
+
+// envelopeSamples is 1000 I16 samples, where the real part of each sample
+// is its index.
+func envelopeSamples() sdr.SamplesI16 {
+	samples := make(sdr.SamplesI16, 1000)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i), 0}
+	}
+	return samples
+}
+
+// sealEnvelope will seal envelopeSamples, 25 samples to a chunk.
+func sealEnvelope(t *testing.T, opts rfcap.EnvelopeOptions) []byte {
+	opts.ChunkSize = 100
+	buf := bytes.Buffer{}
+	w, err := rfcap.SealWriter(&buf, envelopeHeader, opts)
+	assert.NoError(t, err)
+	_, err = w.Write(envelopeSamples())
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func openEnvelope(in io.Reader, opts rfcap.EnvelopeOptions) (rfcap.Header, sdr.SamplesI16, error) {
+	r, hdr, err := rfcap.OpenReader(in, opts)
+	if err != nil {
+		return hdr, nil, err
+	}
+	samples := sdr.SamplesI16{}
+	buf := make(sdr.SamplesI16, 64)
+	for {
+		n, err := r.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return hdr, samples, nil
+		}
+		if err != nil {
+			return hdr, samples, err
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	opts := rfcap.EnvelopeOptions{Key: envelopeKey}
+	sealed := sealEnvelope(t, opts)
+
+	// The Header can be read without the key, but the samples can't.
+	hdr, err := rfcap.ReadEnvelopeHeader(bytes.NewReader(sealed))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, hdr.CenterFrequency)
+	assert.False(t, bytes.Contains(sealed, []byte{0x10, 0x00, 0x00, 0x00, 0x11, 0x00}))
+
+	hdr, samples, err := openEnvelope(bytes.NewReader(sealed), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, envelopeHeader.CaptureTime, hdr.CaptureTime)
+	assert.Equal(t, envelopeSamples(), samples)
+}
+
+func TestEnvelopePassphrase(t *testing.T) {
+	opts := rfcap.EnvelopeOptions{
+		Passphrase:    "correct horse battery staple",
+		Iterations:    1000,
+		EncryptHeader: true,
+	}
+	sealed := sealEnvelope(t, opts)
+
+	_, err := rfcap.ReadEnvelopeHeader(bytes.NewReader(sealed))
+	assert.Equal(t, rfcap.ErrHeaderEncrypted, err)
+
+	// The iterations are stored in the envelope.
+	hdr, samples, err := openEnvelope(bytes.NewReader(sealed), rfcap.EnvelopeOptions{
+		Passphrase: "correct horse battery staple",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, hdr.CenterFrequency)
+	assert.Equal(t, envelopeSamples(), samples)
+
+	_, _, err = openEnvelope(bytes.NewReader(sealed), rfcap.EnvelopeOptions{
+		Passphrase: "incorrect horse battery staple",
+	})
+	assert.Equal(t, rfcap.ErrEnvelopeAuth, err)
+}
+
+func TestEnvelopeTampered(t *testing.T) {
+	opts := rfcap.EnvelopeOptions{Key: envelopeKey}
+	sealed := sealEnvelope(t, opts)
+
+	// The preamble and Header are 96 bytes, and each chunk is a 4 byte
+	// length, 100 bytes of samples and a 16 byte tag.
+	const (
+		start = 96
+		chunk = 4 + 100 + 16
+	)
+	assert.Equal(t, start+40*chunk, len(sealed))
+
+	for name, mutate := range map[string]func([]byte) []byte{
+		"sample": func(b []byte) []byte {
+			b[start+chunk*10+20] ^= 0x01
+			return b
+		},
+		"header": func(b []byte) []byte {
+			// The low byte of the center frequency.
+			b[48+14] ^= 0x01
+			return b
+		},
+		"dropped": func(b []byte) []byte {
+			return append(b[:start+chunk*5], b[start+chunk*6:]...)
+		},
+		"reordered": func(b []byte) []byte {
+			out := append([]byte{}, b[:start]...)
+			out = append(out, b[start+chunk:start+2*chunk]...)
+			out = append(out, b[start:start+chunk]...)
+			return append(out, b[start+2*chunk:]...)
+		},
+		"not last": func(b []byte) []byte {
+			b[start+chunk*39+3] &^= 0x80
+			return b
+		},
+	} {
+		tampered := mutate(append([]byte{}, sealed...))
+		_, samples, err := openEnvelope(bytes.NewReader(tampered), opts)
+		assert.Equal(t, rfcap.ErrEnvelopeAuth, err, name)
+		assert.True(t, len(samples) < 1000, name)
+	}
+
+	_, _, err := openEnvelope(bytes.NewReader(sealed), rfcap.EnvelopeOptions{
+		Key: bytes.Repeat([]byte{0x43}, 32),
+	})
+	assert.Equal(t, rfcap.ErrEnvelopeAuth, err)
+
+	// Cutting off the last chunk can't be passed off as the end.
+	_, samples, err := openEnvelope(bytes.NewReader(sealed[:len(sealed)-chunk]), opts)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 975, len(samples))
+
+	// Nothing is read past the last chunk, so anything after it is left
+	// for the caller.
+	trailing := bytes.NewReader(append(append([]byte{}, sealed...), 0))
+	_, samples, err = openEnvelope(trailing, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, len(samples))
+	assert.Equal(t, 1, trailing.Len())
+
+	// Open can tell that something was tacked on to a file, though.
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trailing.rfcap.sealed")
+	assert.NoError(t, ioutil.WriteFile(path, append(sealed, 0), 0644))
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, rfcap.Open(f, ioutil.Discard, opts))
+}
+
+func TestEnvelopeSalt(t *testing.T) {
+	// Every envelope gets its own key, even when sealed with the same raw
+	// key, so the salt has to be different every time.
+	opts := rfcap.EnvelopeOptions{Key: envelopeKey}
+	a, b := sealEnvelope(t, opts), sealEnvelope(t, opts)
+	assert.NotEqual(t, make([]byte, 16), a[20:36])
+	assert.NotEqual(t, a[20:36], b[20:36])
+}
+
+func TestEnvelopeIterations(t *testing.T) {
+	opts := rfcap.EnvelopeOptions{Passphrase: "hunter2", Iterations: 1000}
+	sealed := sealEnvelope(t, opts)
+
+	// A corrupt iteration count is rejected before spending any time on it.
+	for _, iterations := range []uint32{0, 10*rfcap.EnvelopeDefaultIterations + 1, 0xFFFFFFFF} {
+		tampered := append([]byte{}, sealed...)
+		binary.LittleEndian.PutUint32(tampered[16:20], iterations)
+		_, _, err := openEnvelope(bytes.NewReader(tampered), opts)
+		assert.Error(t, err)
+	}
+
+	_, err := rfcap.SealWriter(&bytes.Buffer{}, envelopeHeader, rfcap.EnvelopeOptions{
+		Passphrase: "hunter2",
+		Iterations: 10*rfcap.EnvelopeDefaultIterations + 1,
+	})
+	assert.Error(t, err)
+}
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	compressed := envelopeHeader
+	compressed.Compressed = true
+	capture := captureBuffer(t, compressed, envelopeSamples()).Bytes()
+
+	for _, opts := range []rfcap.EnvelopeOptions{
+		{Key: envelopeKey[:16]},
+		{Key: envelopeKey[:24], EncryptHeader: true},
+	} {
+		sealed := bytes.Buffer{}
+		assert.NoError(t, rfcap.Seal(bytes.NewReader(capture), &sealed, opts))
+
+		opened := bytes.Buffer{}
+		assert.NoError(t, rfcap.Open(&sealed, &opened, opts))
+		assert.Equal(t, capture, opened.Bytes())
+	}
+}
+
+func TestEnvelopeConn(t *testing.T) {
+	opts := rfcap.EnvelopeOptions{Key: envelopeKey}
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		w, err := rfcap.SealWriter(server, envelopeHeader, opts)
+		assert.NoError(t, err)
+		samples := envelopeSamples()
+		for i := 0; i < len(samples); i += 100 {
+			_, err := w.Write(samples[i : i+100])
+			assert.NoError(t, err)
+			assert.NoError(t, w.Flush())
+		}
+		assert.NoError(t, w.Close())
+	}()
+
+	hdr, samples, err := openEnvelope(client, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, hdr.CenterFrequency)
+	assert.Equal(t, envelopeSamples(), samples)
+}
+
+func TestEnvelopeConnOpen(t *testing.T) {
+	// The sender keeps the connection open after the envelope, so the
+	// reader has to stop at the last chunk rather than wait for the end of
+	// the stream.
+	opts := rfcap.EnvelopeOptions{Key: envelopeKey}
+	client, server := net.Pipe()
+	defer client.Close()
+
+	hangup := make(chan struct{})
+	go func() {
+		defer server.Close()
+		w, err := rfcap.SealWriter(server, envelopeHeader, opts)
+		assert.NoError(t, err)
+		_, err = w.Write(envelopeSamples())
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		<-hangup
+	}()
+	defer close(hangup)
+
+	type result struct {
+		samples sdr.SamplesI16
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, samples, err := openEnvelope(client, opts)
+		done <- result{samples, err}
+	}()
+
+	select {
+	case r := <-done:
+		assert.NoError(t, r.err)
+		assert.Equal(t, envelopeSamples(), r.samples)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the envelope waited for the connection to close")
+	}
+}
+
+func TestEnvelopeOptions(t *testing.T) {
+	buf := bytes.Buffer{}
+	_, err := rfcap.SealWriter(&buf, envelopeHeader, rfcap.EnvelopeOptions{})
+	assert.Error(t, err)
+	_, err = rfcap.SealWriter(&buf, envelopeHeader, rfcap.EnvelopeOptions{
+		Key:        envelopeKey,
+		Passphrase: "both",
+	})
+	assert.Error(t, err)
+	_, err = rfcap.SealWriter(&buf, envelopeHeader, rfcap.EnvelopeOptions{
+		Key: envelopeKey[:10],
+	})
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,213 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"hz.tools/rf"
+)
+
+// EventKind describes what sort of thing happened to a stream at a
+// specific point.
+type EventKind uint8
+
+const (
+	// EventGap signifies that samples are missing from the stream at this
+	// point, such as when they were dropped, or there's a break between
+	// two captures.
+	EventGap EventKind = iota + 1
+
+	// EventCenterFrequency signifies that the device was retuned.
+	EventCenterFrequency
+
+	// EventSampleRate signifies that the sample rate of the device was
+	// changed.
+	EventSampleRate
+
+	// EventGain signifies that the gain of a specific gain stage was
+	// changed.
+	EventGain
+
+	// EventAutomaticGain signifies that automatic gain control was turned on
+	// or off.
+	EventAutomaticGain
+
+	// EventUnderrun signifies that samples were not provided to a device
+	// quickly enough to keep up with its sample rate.
+	EventUnderrun
+
+	// EventDisconnect signifies that a client was hung up on, and won't
+	// get any more of the stream.
+	EventDisconnect
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case EventGap:
+		return "gap"
+	case EventCenterFrequency:
+		return "center frequency"
+	case EventSampleRate:
+		return "sample rate"
+	case EventGain:
+		return "gain"
+	case EventAutomaticGain:
+		return "automatic gain"
+	case EventUnderrun:
+		return "underrun"
+	case EventDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// eventKinds is every EventKind, used to parse them back out of JSON.
+var eventKinds = []EventKind{
+	EventGap,
+	EventCenterFrequency,
+	EventSampleRate,
+	EventGain,
+	EventAutomaticGain,
+	EventUnderrun,
+	EventDisconnect,
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (kind EventKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (kind *EventKind) UnmarshalText(b []byte) error {
+	for _, k := range eventKinds {
+		if k.String() == string(b) {
+			*kind = k
+			return nil
+		}
+	}
+	return fmt.Errorf("rfcap: unknown event kind %q", b)
+}
+
+// Event is something that happened at a specific point in a stream of
+// samples that can't be represented by the samples themselves. Only the
+// fields that are relevant to the Kind of Event will be set.
+type Event struct {
+	// Kind is the sort of thing that happened.
+	Kind EventKind
+
+	// Sample is the index of the sample in the stream at which this Event
+	// happened.
+	Sample uint64
+
+	// Time is the wall clock time at which this Event happened.
+	Time time.Time
+
+	// Length is the number of samples missing from the stream, if this
+	// is an EventGap, or how far behind the stream was, if this is an
+	// EventUnderrun.
+	Length uint64
+
+	// CenterFrequency is the new center frequency, if this is an
+	// EventCenterFrequency.
+	CenterFrequency rf.Hz
+
+	// SampleRate is the new sample rate, if this is an EventSampleRate.
+	SampleRate uint
+
+	// GainStage is the name of the gain stage that was changed, and Gain is
+	// the new value, if this is an EventGain.
+	GainStage string
+	Gain      float32
+
+	// AutomaticGain is the new AGC state, if this is an EventAutomaticGain.
+	AutomaticGain bool
+}
+
+// jsonEvent is the JSON encoding of an Event.
+type jsonEvent struct {
+	Kind            EventKind `json:"kind"`
+	Sample          uint64    `json:"sample"`
+	Time            time.Time `json:"time"`
+	Length          uint64    `json:"length,omitempty"`
+	CenterFrequency rf.Hz     `json:"center_frequency,omitempty"`
+	SampleRate      uint      `json:"sample_rate,omitempty"`
+	GainStage       string    `json:"gain_stage,omitempty"`
+	Gain            float32   `json:"gain,omitempty"`
+	AutomaticGain   bool      `json:"automatic_gain,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEvent(e))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	je := jsonEvent{}
+	if err := json.Unmarshal(b, &je); err != nil {
+		return err
+	}
+	*e = Event(je)
+	return nil
+}
+
+// WriteEvents will write the Events to the io.Writer as JSON, one per line.
+// This is used to keep the Events that happened during a capture in a file
+// alongside it, since they can't be stored in the capture itself.
+func WriteEvents(w io.Writer, events ...Event) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadEvents will read all of the Events written by WriteEvents.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var (
+		events  = []Event{}
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("rfcap: malformed event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// vim: foldmethod=marker
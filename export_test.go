@@ -0,0 +1,57 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"time"
+)
+
+// FakeClock is a clock that only moves when told to, by Advance or by Sleep.
+type FakeClock struct {
+	now    time.Time
+	Sleeps []time.Duration
+}
+
+// NewFakeClock will return a FakeClock starting at the provided time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now will return the current time of the FakeClock.
+func (fc *FakeClock) Now() time.Time { return fc.now }
+
+// Advance will move the FakeClock forward.
+func (fc *FakeClock) Advance(d time.Duration) { fc.now = fc.now.Add(d) }
+
+// Sleep will record the Duration, and move the FakeClock forward by it.
+func (fc *FakeClock) Sleep(d time.Duration) {
+	fc.Sleeps = append(fc.Sleeps, d)
+	fc.Advance(d)
+}
+
+// SetTransmitClock will have Transmit use the FakeClock, returning a func
+// that puts the wall clock back.
+func SetTransmitClock(fc *FakeClock) func() {
+	transmitClock = fc
+	return func() { transmitClock = wallClock{} }
+}
+
+// vim: foldmethod=marker
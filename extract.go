@@ -0,0 +1,255 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+)
+
+// extractOversample is how much wider than the requested bandwidth the
+// sample rate of an extracted channel must be, to leave room for the
+// transition band of the filter.
+const extractOversample = 1.25
+
+// Channel is a narrow band of frequencies to Extract from a capture.
+type Channel struct {
+	// CenterFrequency is the center of the channel, which will become the
+	// center frequency of the extracted capture.
+	CenterFrequency rf.Hz
+
+	// Bandwidth is the width of the channel. The extracted capture will
+	// have a sample rate of at least 1.25 times the Bandwidth.
+	Bandwidth rf.Hz
+}
+
+func (ch Channel) String() string {
+	return fmt.Sprintf("%s (%s wide)", ch.CenterFrequency, ch.Bandwidth)
+}
+
+// channelizer will shift, filter and decimate a stream of complex64
+// samples down to a single Channel.
+type channelizer struct {
+	header Header
+	mixer  *dsp.Mixer
+	fir    *dsp.Cascade
+}
+
+// newChannelizer will work out how to extract the Channel from a capture
+// with the provided Header, returning an error if the Channel doesn't fit.
+func newChannelizer(in Header, ch Channel) (*channelizer, error) {
+	var (
+		rate    = float64(in.SampleRate)
+		offset  = float64(ch.CenterFrequency - in.CenterFrequency)
+		nyquist = rate / 2
+	)
+	if ch.Bandwidth <= 0 {
+		return nil, fmt.Errorf("rfcap: channel %s has no bandwidth", ch)
+	}
+	if math.Abs(offset)+float64(ch.Bandwidth)/2 > nyquist {
+		return nil, fmt.Errorf(
+			"rfcap: channel %s is outside the capture (%s ±%s)",
+			ch, in.CenterFrequency, rf.Hz(nyquist),
+		)
+	}
+
+	// Pick the largest decimation that evenly divides the sample rate, and
+	// still leaves enough room for the channel.
+	decimation := uint(rate / (float64(ch.Bandwidth) * extractOversample))
+	for decimation > 1 && in.SampleRate%decimation != 0 {
+		decimation--
+	}
+	if decimation < 1 {
+		decimation = 1
+	}
+
+	// Decimating in a single step takes a filter with a transition band
+	// that's narrow compared to the input sample rate, which means a huge
+	// number of taps. Instead, decimate by each prime factor in turn. Every
+	// stage but the last only has to remove what would alias into the
+	// channel, so it can have a wide transition band, and the last, which
+	// needs a narrow one, runs at the lowest sample rate.
+	var (
+		factors   = primeFactors(decimation)
+		stages    = make([]*dsp.FIR, len(factors))
+		stageRate = rate
+		cutoff    = float64(ch.Bandwidth) / 2
+	)
+	for i, factor := range factors {
+		var (
+			outRate    = stageRate / float64(factor)
+			transition = outRate - 2*cutoff
+		)
+		if i == len(factors)-1 {
+			if transition = outRate/2 - cutoff; transition <= 0 {
+				transition = cutoff / 10
+			}
+		}
+		// A Hamming window needs about 3.3 taps per transition width.
+		taps := int(math.Ceil(3.3*stageRate/transition)) | 1
+		stages[i] = dsp.NewFIR(dsp.LowPass((cutoff+transition/2)/stageRate, taps), int(factor))
+		stageRate = outRate
+	}
+
+	out := in
+	out.CenterFrequency = ch.CenterFrequency
+	out.SampleRate = in.SampleRate / decimation
+
+	return &channelizer{
+		header: out,
+		mixer:  dsp.NewMixer(-offset, rate),
+		fir:    dsp.NewCascade(stages...),
+	}, nil
+}
+
+// primeFactors will return the prime factors of n, largest first, or just n
+// if it's 1.
+func primeFactors(n uint) []uint {
+	factors := []uint{}
+	for f := uint(2); f*f <= n; f++ {
+		for n%f == 0 {
+			factors = append(factors, f)
+			n /= f
+		}
+	}
+	if n > 1 || len(factors) == 0 {
+		factors = append(factors, n)
+	}
+	for i, j := 0, len(factors)-1; i < j; i, j = i+1, j-1 {
+		factors[i], factors[j] = factors[j], factors[i]
+	}
+	return factors
+}
+
+// Extract will return a Transform that extracts the Channel from a capture,
+// by shifting it to 0 Hz, low pass filtering it and then decimating. The new
+// Header will have the Channel's CenterFrequency, and a reduced SampleRate.
+func Extract(ch Channel) Transform {
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			c, err := newChannelizer(in, ch)
+			if err != nil {
+				return Header{}, err
+			}
+			return c.header, nil
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			c, err := newChannelizer(in, ch)
+			if err != nil {
+				return nil, err
+			}
+			decimation := int(in.SampleRate / c.header.SampleRate)
+			return newFIRReader(&c64Reader{r: r, proc: c.mixer.Mix}, c.fir, decimation), nil
+		},
+	}
+}
+
+// ExtractChannels will extract each Channel from the capture read from the
+// io.Reader in a single pass, writing each to the io.Writer at the same
+// index in outs, in the same sample format as the input. The Header of each
+// extracted capture is returned.
+func ExtractChannels(in io.Reader, channels []Channel, outs []io.Writer) ([]Header, error) {
+	if len(channels) != len(outs) {
+		return nil, fmt.Errorf("rfcap: %d channels, but %d outputs", len(channels), len(outs))
+	}
+
+	r, hdr, err := Reader(in)
+	if err != nil {
+		return nil, err
+	}
+	format := hdr.SampleFormat
+	if r, err = newConvertReader(r, sdr.SampleFormatC64); err != nil {
+		return nil, err
+	}
+
+	var (
+		channelizers = make([]*channelizer, len(channels))
+		writers      = make([]sdr.Writer, len(channels))
+		headers      = make([]Header, len(channels))
+	)
+	for i, ch := range channels {
+		c, err := newChannelizer(hdr, ch)
+		if err != nil {
+			return nil, err
+		}
+		channelizers[i] = c
+		headers[i] = c.header
+	}
+	for i, c := range channelizers {
+		if writers[i], err = Writer(outs[i], c.header); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		buf     = make(sdr.SamplesC64, 32*1024)
+		mixed   = make([]complex64, len(buf))
+		out     = make([]complex64, len(buf))
+		convert sdr.Samples
+	)
+	if convert, err = sdr.MakeSamples(format, len(buf)); err != nil {
+		return nil, err
+	}
+
+	for {
+		n, rerr := readSamples(r, buf)
+		for i, c := range channelizers {
+			copy(mixed, buf[:n])
+			c.mixer.Mix(mixed[:n])
+			m := c.fir.Filter(out, mixed[:n])
+			if m == 0 {
+				continue
+			}
+
+			var samples sdr.Samples = sdr.SamplesC64(out[:m])
+			if format != sdr.SampleFormatC64 {
+				dsp.Clamp(out[:m])
+				if _, err := sdr.ConvertBuffer(convert, samples); err != nil {
+					return nil, err
+				}
+				samples = convert.Slice(0, m)
+			}
+			if _, err := writers[i].Write(samples); err != nil {
+				return nil, err
+			}
+		}
+
+		switch rerr {
+		case nil:
+		case io.EOF:
+			for _, w := range writers {
+				if err := Flush(w); err != nil {
+					return nil, err
+				}
+			}
+			return headers, nil
+		default:
+			return nil, rerr
+		}
+	}
+}
+
+// vim: foldmethod=marker
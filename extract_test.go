@@ -0,0 +1,156 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// twoToneCapture is 8000 samples per second, with a tone 1 kHz above the
+// center frequency, and another 2 kHz below.
+func twoToneCapture(t *testing.T) *bytes.Buffer {
+	samples := make(sdr.SamplesC64, 16000)
+	for i := range samples {
+		ts := float64(i) / 8000
+		samples[i] = complex64(
+			0.25*cmplx.Rect(1, 2*math.Pi*1000*ts) +
+				0.25*cmplx.Rect(1, 2*math.Pi*-2000*ts),
+		)
+	}
+	return testCapture(t, samples, func(h *rfcap.Header) {
+		h.SampleRate = 8000
+	})
+}
+
+func TestExtract(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(twoToneCapture(t), out, rfcap.Extract(rfcap.Channel{
+		CenterFrequency: 100*rf.MHz + 1000,
+		Bandwidth:       500,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz+1000, hdr.CenterFrequency)
+	assert.Equal(t, uint(800), hdr.SampleRate)
+
+	samples, _ := readAllC64(t, out)
+	assert.Equal(t, 1600, len(samples))
+
+	// Just the one tone, now at 0 Hz.
+	for _, v := range samples[200:] {
+		assert.InDelta(t, 0.25, cmplx.Abs(complex128(v)), 0.01)
+	}
+}
+
+func TestExtractChannels(t *testing.T) {
+	channels := []rfcap.Channel{
+		{CenterFrequency: 100*rf.MHz + 1000, Bandwidth: 500},
+		{CenterFrequency: 100*rf.MHz - 2000, Bandwidth: 1000},
+		{CenterFrequency: 100*rf.MHz + 3000, Bandwidth: 500},
+	}
+	outs := []*bytes.Buffer{{}, {}, {}}
+
+	headers, err := rfcap.ExtractChannels(twoToneCapture(t), channels, []io.Writer{
+		outs[0], outs[1], outs[2],
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(headers))
+
+	extracted := []sdr.SamplesC64{}
+	for i, ch := range channels {
+		assert.Equal(t, ch.CenterFrequency, headers[i].CenterFrequency)
+
+		// Extracting many channels at once is the same as extracting each
+		// on its own.
+		single := &bytes.Buffer{}
+		_, err := rfcap.Apply(twoToneCapture(t), single, rfcap.Extract(ch))
+		assert.NoError(t, err)
+
+		want, wantHdr := readAllC64(t, single)
+		got, gotHdr := readAllC64(t, outs[i])
+		assert.Equal(t, wantHdr, gotHdr)
+		assert.Equal(t, want, got)
+		extracted = append(extracted, got)
+	}
+
+	// There's nothing at +3 kHz.
+	assert.True(t, meanPower(extracted[2][100:]) < 1e-4)
+}
+
+func TestExtractNarrow(t *testing.T) {
+	// Extracting a narrow channel from a wide capture decimates by 192,
+	// which is done in stages.
+	samples := make(sdr.SamplesC64, 240000)
+	for i := range samples {
+		ts := float64(i) / 240000
+		samples[i] = complex64(
+			0.25*cmplx.Rect(1, 2*math.Pi*20100*ts) +
+				0.25*cmplx.Rect(1, 2*math.Pi*25000*ts) +
+				0.25*cmplx.Rect(1, 2*math.Pi*-60000*ts),
+		)
+	}
+	capture := captureBuffer(t, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      240000,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	}, samples)
+
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(capture, out, rfcap.Extract(rfcap.Channel{
+		CenterFrequency: 100*rf.MHz + 20000,
+		Bandwidth:       1000,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1250), hdr.SampleRate)
+
+	extracted, _ := readAllC64(t, out)
+	assert.Equal(t, 1250, len(extracted))
+
+	// Only the tone 100 Hz into the channel is left.
+	assert.InDelta(t, 0.0625, meanPower(extracted[250:]), 0.002)
+}
+
+func TestExtractOutOfRange(t *testing.T) {
+	_, err := rfcap.Apply(twoToneCapture(t), &bytes.Buffer{}, rfcap.Extract(rfcap.Channel{
+		CenterFrequency: 100*rf.MHz + 3900,
+		Bandwidth:       500,
+	}))
+	assert.Error(t, err)
+
+	_, err = rfcap.ExtractChannels(twoToneCapture(t), []rfcap.Channel{
+		{CenterFrequency: 100 * rf.MHz, Bandwidth: 0},
+	}, []io.Writer{&bytes.Buffer{}})
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,394 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// SlowClientPolicy is what a Fanout does with a client that can't keep up
+// with the stream.
+type SlowClientPolicy uint8
+
+const (
+	// SlowClientDrop will drop samples that a client isn't able to keep up
+	// with, and send silence in their place once it catches up, so sample
+	// indexes still line up with time. Each gap is reported as an
+	// EventGap.
+	SlowClientDrop SlowClientPolicy = iota
+
+	// SlowClientDisconnect will hang up on a client as soon as it falls
+	// behind.
+	SlowClientDisconnect
+)
+
+func (p SlowClientPolicy) String() string {
+	switch p {
+	case SlowClientDrop:
+		return "drop"
+	case SlowClientDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// FanoutOptions control how a Fanout treats its clients.
+type FanoutOptions struct {
+	// QueueLength is the number of buffers that can be waiting to be sent
+	// to a client before the SlowClients policy kicks in. If zero, 64
+	// buffers are queued.
+	QueueLength int
+
+	// SlowClients is what happens to a client that falls behind.
+	SlowClients SlowClientPolicy
+
+	// OnEvent, if not nil, is called with the address of the client and
+	// the Event whenever a client has a gap in its stream (an EventGap), or
+	// is disconnected by the SlowClientDisconnect policy (an
+	// EventDisconnect). The Sample of the Event is in terms of the client's
+	// stream, not the Fanout's.
+	OnEvent func(net.Addr, Event)
+}
+
+// Fanout will read from a single sdr.Reader, such as a live radio, and
+// stream the samples as rfcap to any number of clients. Clients can join at
+// any point, and each is sent a Header whose CaptureTime is the time of the
+// first sample that client receives.
+//
+// Reading from the sdr.Reader is never held up by a client; clients that
+// fall behind are handled by the SlowClientPolicy.
+type Fanout struct {
+	header Header
+	r      sdr.Reader
+	opts   FanoutOptions
+
+	lock    sync.Mutex
+	sample  uint64
+	clients map[*fanoutClient]struct{}
+	closed  bool
+
+	free chan *fanoutBuffer
+}
+
+// fanoutBuffer is a buffer of samples shared by every client it was queued
+// for. Once the last of them is done with it, it can be read into again.
+type fanoutBuffer struct {
+	samples sdr.Samples
+	refs    int32
+}
+
+// fanoutChunk is a buffer of samples to be sent to a client, along with
+// the number of samples that were dropped just before it.
+type fanoutChunk struct {
+	sample  uint64
+	gap     uint64
+	samples sdr.Samples
+	buf     *fanoutBuffer
+}
+
+// fanoutClient is a single connected client.
+type fanoutClient struct {
+	conn  net.Conn
+	queue chan fanoutChunk
+	done  chan struct{}
+
+	// These are only touched by the Fanout with its lock held.
+	dropped   uint64
+	gapSample uint64
+	gapTime   time.Time
+	started   bool
+	start     uint64
+}
+
+// NewFanout will create a Fanout of the samples read from the sdr.Reader,
+// which are described by the Header. The CaptureTime of the Header is the
+// time of the first sample read. Nothing is read until Run is called.
+func NewFanout(r sdr.Reader, header Header, opts FanoutOptions) *Fanout {
+	if opts.QueueLength <= 0 {
+		opts.QueueLength = recordQueueLength
+	}
+	return &Fanout{
+		header:  header,
+		r:       r,
+		opts:    opts,
+		clients: map[*fanoutClient]struct{}{},
+		free:    make(chan *fanoutBuffer, opts.QueueLength+1),
+	}
+}
+
+// Clients will return the number of connected clients.
+func (f *Fanout) Clients() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.clients)
+}
+
+// Serve will accept clients from the net.Listener and Add them, until the
+// net.Listener returns an error.
+func (f *Fanout) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		f.Add(conn)
+	}
+}
+
+// Add will start streaming to the net.Conn, starting with the next buffer
+// read from the sdr.Reader. The net.Conn is Closed once the stream ends, or
+// the client hangs up or is dropped.
+func (f *Fanout) Add(conn net.Conn) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		conn.Close()
+		return
+	}
+
+	client := &fanoutClient{
+		conn:  conn,
+		queue: make(chan fanoutChunk, f.opts.QueueLength),
+		done:  make(chan struct{}),
+	}
+	f.clients[client] = struct{}{}
+	go f.send(client)
+}
+
+// remove will stop queueing samples for the client, and let it finish
+// sending whatever is left. This must be called with the lock held.
+func (f *Fanout) remove(client *fanoutClient) {
+	if _, ok := f.clients[client]; !ok {
+		return
+	}
+	delete(f.clients, client)
+	close(client.queue)
+}
+
+// fanoutEvent is an Event that happened to a specific client.
+type fanoutEvent struct {
+	addr  net.Addr
+	event Event
+}
+
+// send will write out everything queued for the client until the queue is
+// closed or the client goes away.
+func (f *Fanout) send(client *fanoutClient) {
+	defer close(client.done)
+	defer client.conn.Close()
+
+	var (
+		w     sdr.Writer
+		zeros sdr.Samples
+		err   error
+	)
+
+	for chunk := range client.queue {
+		if w == nil {
+			hdr := f.header
+			hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(int64(chunk.sample)))
+
+			if w, err = Writer(client.conn, hdr); err != nil {
+				break
+			}
+			if zeros, err = makeSilence(hdr.SampleFormat, 32*1024); err != nil {
+				break
+			}
+		}
+
+		if err = writeSilence(w, zeros, int64(chunk.gap)); err != nil {
+			break
+		}
+		if chunk.samples == nil {
+			continue
+		}
+		_, err = w.Write(chunk.samples)
+		f.release(chunk.buf)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && w != nil {
+		Flush(w)
+	}
+
+	// If we stopped early, the client has gone away, so stop queueing
+	// samples for it. The queue is only ever closed by the Fanout.
+	f.lock.Lock()
+	delete(f.clients, client)
+	f.lock.Unlock()
+}
+
+// release will drop a reference to the buffer, and hand it back to be read
+// into again once nobody is using it.
+func (f *Fanout) release(buf *fanoutBuffer) {
+	if buf == nil || atomic.AddInt32(&buf.refs, -1) != 0 {
+		return
+	}
+	select {
+	case f.free <- buf:
+	default:
+	}
+}
+
+// distribute will queue the first n samples of the buffer for every client,
+// without blocking.
+func (f *Fanout) distribute(buf *fanoutBuffer, n int) {
+	// OnEvent is called once the lock is released, so that it's free to
+	// call back into the Fanout.
+	events := f.queue(buf, n)
+	if f.opts.OnEvent == nil {
+		return
+	}
+	for _, e := range events {
+		f.opts.OnEvent(e.addr, e.event)
+	}
+}
+
+// queue will queue the first n samples of the buffer for every client,
+// returning any Events that happened along the way.
+func (f *Fanout) queue(buf *fanoutBuffer, n int) []fanoutEvent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var (
+		s      = buf.samples.Slice(0, n)
+		events []fanoutEvent
+	)
+	for client := range f.clients {
+		if !client.started {
+			client.started = true
+			client.start = f.sample
+		}
+
+		// The client may be done with the buffer as soon as it's queued,
+		// so take the reference first.
+		atomic.AddInt32(&buf.refs, 1)
+		select {
+		case client.queue <- fanoutChunk{sample: f.sample, gap: client.dropped, samples: s, buf: buf}:
+			if client.dropped > 0 {
+				events = append(events, fanoutEvent{client.conn.RemoteAddr(), Event{
+					Kind:   EventGap,
+					Sample: client.gapSample - client.start,
+					Time:   client.gapTime,
+					Length: client.dropped,
+				}})
+				client.dropped = 0
+			}
+		default:
+			// We still hold our own reference, so this can't be the last.
+			atomic.AddInt32(&buf.refs, -1)
+			if f.opts.SlowClients == SlowClientDisconnect {
+				// Closing the connection will unstick a Write that's
+				// waiting on the client.
+				client.conn.Close()
+				events = append(events, fanoutEvent{client.conn.RemoteAddr(), Event{
+					Kind:   EventDisconnect,
+					Sample: f.sample - client.start,
+					Time:   time.Now(),
+				}})
+				f.remove(client)
+				continue
+			}
+			if client.dropped == 0 {
+				client.gapSample = f.sample
+				client.gapTime = time.Now()
+			}
+			client.dropped += uint64(s.Length())
+		}
+	}
+	f.sample += uint64(s.Length())
+	return events
+}
+
+// Run will read from the sdr.Reader, streaming the samples to all clients,
+// until the sdr.Reader returns an error. Once it does, all clients are sent
+// whatever is left in their queues and disconnected, and no more clients
+// will be accepted. Reaching the end of the stream is not an error.
+func (f *Fanout) Run() error {
+	err := f.run()
+
+	f.lock.Lock()
+	f.closed = true
+	clients := make([]*fanoutClient, 0, len(f.clients))
+	for client := range f.clients {
+		clients = append(clients, client)
+	}
+	f.lock.Unlock()
+
+	// Nothing else will be queued now that we're closed, so we're free to
+	// block here while the last of any gap is sent. Clients that have gone
+	// away will have drained their queue.
+	for _, client := range clients {
+		if client.dropped > 0 {
+			select {
+			case client.queue <- fanoutChunk{gap: client.dropped}:
+			case <-client.done:
+			}
+		}
+		f.lock.Lock()
+		f.remove(client)
+		f.lock.Unlock()
+		<-client.done
+	}
+	return err
+}
+
+func (f *Fanout) run() error {
+	for {
+		// Each buffer is shared by every client, so it can only be reused
+		// once they've all sent it.
+		var buf *fanoutBuffer
+		select {
+		case buf = <-f.free:
+		default:
+			samples, err := sdr.MakeSamples(f.r.SampleFormat(), 32*1024)
+			if err != nil {
+				return err
+			}
+			buf = &fanoutBuffer{samples: samples}
+		}
+		atomic.StoreInt32(&buf.refs, 1)
+
+		n, err := f.r.Read(buf.samples)
+		if n > 0 {
+			f.distribute(buf, n)
+		}
+		f.release(buf)
+		switch err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,262 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// gatedConn is a net.Conn that blocks all Writes until the gate is
+// opened, and keeps everything written to it.
+type gatedConn struct {
+	net.Conn
+
+	gate      chan struct{}
+	entered   chan struct{}
+	closed    chan struct{}
+	enterOnce sync.Once
+	closeOnce sync.Once
+
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func newGatedConn(open bool) *gatedConn {
+	gc := &gatedConn{
+		gate:    make(chan struct{}),
+		entered: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	if open {
+		close(gc.gate)
+	}
+	return gc
+}
+
+func (gc *gatedConn) Write(b []byte) (int, error) {
+	gc.enterOnce.Do(func() { close(gc.entered) })
+	select {
+	case <-gc.gate:
+	case <-gc.closed:
+		return 0, io.ErrClosedPipe
+	}
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	return gc.buf.Write(b)
+}
+
+func (gc *gatedConn) Close() error {
+	gc.closeOnce.Do(func() { close(gc.closed) })
+	return nil
+}
+
+func (gc *gatedConn) len() int {
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	return gc.buf.Len()
+}
+
+func (gc *gatedConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+var fanoutEpoch = time.Unix(1600000000, 0)
+
+// stepReader is an sdr.Reader of complex64 samples that are handed to it
+// one buffer at a time. Each Read will signal ready before waiting for the
+// next buffer, so the test knows the last buffer has been dealt with.
+type stepReader struct {
+	ready  chan struct{}
+	chunks chan sdr.SamplesC64
+}
+
+func (sr stepReader) SampleRate() uint               { return 1000 }
+func (sr stepReader) SampleFormat() sdr.SampleFormat { return sdr.SampleFormatC64 }
+
+func (sr stepReader) Read(s sdr.Samples) (int, error) {
+	sr.ready <- struct{}{}
+	chunk, ok := <-sr.chunks
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(s.(sdr.SamplesC64), chunk), nil
+}
+
+// fanoutStream will return a running Fanout of complex64 samples where the
+// real part of each sample is its index. write will return once the
+// samples have been queued for every client.
+func fanoutStream(opts rfcap.FanoutOptions) (*rfcap.Fanout, func(n int), func() error) {
+	sr := stepReader{
+		ready:  make(chan struct{}),
+		chunks: make(chan sdr.SamplesC64),
+	}
+	fanout := rfcap.NewFanout(sr, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     fanoutEpoch,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	}, opts)
+
+	done := make(chan error, 1)
+	go func() { done <- fanout.Run() }()
+	<-sr.ready
+
+	var sample int
+	write := func(n int) {
+		buf := make(sdr.SamplesC64, n)
+		for i := range buf {
+			buf[i] = complex(float32(sample), 0)
+			sample++
+		}
+		sr.chunks <- buf
+		<-sr.ready
+	}
+	wait := func() error {
+		close(sr.chunks)
+		return <-done
+	}
+	return fanout, write, wait
+}
+
+func readFanout(t *testing.T, gc *gatedConn) (rfcap.Header, sdr.SamplesC64) {
+	<-gc.closed
+	samples, hdr := readAllC64(t, &gc.buf)
+	return hdr, samples
+}
+
+func TestFanoutLateJoin(t *testing.T) {
+	fanout, write, wait := fanoutStream(rfcap.FanoutOptions{})
+
+	first := newGatedConn(true)
+	fanout.Add(first)
+	write(100)
+	write(100)
+
+	second := newGatedConn(true)
+	fanout.Add(second)
+	write(100)
+	write(100)
+	assert.Equal(t, 2, fanout.Clients())
+	assert.NoError(t, wait())
+
+	hdr, samples := readFanout(t, first)
+	assert.Equal(t, fanoutEpoch, hdr.CaptureTime)
+	assert.Equal(t, 400, len(samples))
+
+	// Whichever sample the second client started on, its Header should
+	// line up with it.
+	hdr, samples = readFanout(t, second)
+	start := int(real(samples[0]))
+	assert.True(t, start == 100 || start == 200, "started at %d", start)
+	assert.Equal(t, 400-start, len(samples))
+	assert.Equal(t, fanoutEpoch.Add(time.Duration(start)*time.Millisecond), hdr.CaptureTime)
+
+	// Nobody can join once the stream is over.
+	late := newGatedConn(true)
+	fanout.Add(late)
+	<-late.closed
+}
+
+func TestFanoutDrop(t *testing.T) {
+	events := []rfcap.Event{}
+	fanout, write, wait := fanoutStream(rfcap.FanoutOptions{
+		QueueLength: 1,
+		OnEvent: func(_ net.Addr, e rfcap.Event) {
+			events = append(events, e)
+		},
+	})
+
+	slow := newGatedConn(false)
+	fanout.Add(slow)
+	write(100)
+	<-slow.entered
+
+	// One buffer queued, and two dropped, since the second drop can't
+	// be read until the first is done with.
+	write(100)
+	write(100)
+	write(100)
+	close(slow.gate)
+
+	// Wait for the header and first two buffers to be sent before the
+	// client can catch up.
+	assert.Eventually(t, func() bool {
+		return slow.len() == 48+200*8
+	}, time.Second, time.Millisecond)
+	write(100)
+	assert.NoError(t, wait())
+
+	_, samples := readFanout(t, slow)
+	assert.Equal(t, 500, len(samples))
+	assert.Equal(t, float32(199), real(samples[199]))
+	for _, s := range samples[200:400] {
+		assert.Equal(t, complex64(0), s)
+	}
+	assert.Equal(t, float32(400), real(samples[400]))
+
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.Equal(t, uint64(200), events[0].Sample)
+	assert.Equal(t, uint64(200), events[0].Length)
+}
+
+func TestFanoutDisconnect(t *testing.T) {
+	events := []rfcap.Event{}
+	fanout, write, wait := fanoutStream(rfcap.FanoutOptions{
+		QueueLength: 1,
+		SlowClients: rfcap.SlowClientDisconnect,
+		OnEvent: func(_ net.Addr, e rfcap.Event) {
+			events = append(events, e)
+		},
+	})
+
+	slow := newGatedConn(false)
+	fanout.Add(slow)
+	write(100)
+	<-slow.entered
+	write(100)
+	write(100)
+	write(100)
+	assert.Equal(t, 0, fanout.Clients())
+
+	<-slow.closed
+	assert.NoError(t, wait())
+
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventDisconnect, events[0].Kind)
+	assert.Equal(t, uint64(200), events[0].Sample)
+}
+
+// vim: foldmethod=marker
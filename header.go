@@ -23,7 +23,9 @@ package rfcap
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"hz.tools/rf"
@@ -41,23 +43,38 @@ type Magic [6]byte
 var (
 	// MagicVersion1 signifies the first version of rfcap.
 	MagicVersion1 = Magic{'R', 'F', 'C', 'A', 'P', '1'}
+
+	// MagicVersion2 is the same as version 1, except that the header may be
+	// followed by a header extension. Captures are only written as version
+	// 2 if there's an extension to write, so readers that only know about
+	// version 1 never mistake an extension for samples.
+	MagicVersion2 = Magic{'R', 'F', 'C', 'A', 'P', '2'}
 )
 
 func (magic Magic) String() string {
 	switch magic {
 	case MagicVersion1:
 		return "rfcap v1"
+	case MagicVersion2:
+		return "rfcap v2"
 	default:
 		return "unknown"
 	}
 }
 
-// Size is the rfcap header size in Bytes.
+// Size is the rfcap header size in Bytes. This does not include the size of
+// any header extension that follows a version 2 header.
 var Size = 48
 
+// maxExtensionLength is the largest header extension we're willing to read,
+// to avoid allocating wild amounts of memory for a corrupt file.
+const maxExtensionLength = 1024 * 1024
+
 // Header contains metadata around what the capture represents.
 type Header struct {
-	// Magic is 'RFCAP1'
+	// Magic is 'RFCAP1', or 'RFCAP2' if the header has an extension. When
+	// writing, a Header with a non-empty Extension is always written as
+	// version 2, whatever Magic is set to.
 	Magic Magic
 
 	// CaptureTime signifies the time at which this capture was started.
@@ -86,6 +103,109 @@ type Header struct {
 	// Endianness defines the ByteOrder used for the data in the rfcap
 	// file.
 	Endianness binary.ByteOrder
+
+	// Extension is the metadata stored in a version 2 header extension, or
+	// nil if there is none. Readers that only know about version 1 can't
+	// read captures with an extension, so this is only set by
+	// HeaderFromSDR if asked for.
+	Extension *HeaderExtension
+
+	// dataOffset is the offset of the first sample in the stream this
+	// Header was read from, or 0 if it wasn't read from a stream.
+	dataOffset int64
+}
+
+// Equal will return true if both Headers describe the same capture. Unlike
+// ==, this compares the contents of the Extension rather than the pointer.
+func (h Header) Equal(other Header) bool {
+	if h.Magic != other.Magic ||
+		!h.CaptureTime.Equal(other.CaptureTime) ||
+		h.CenterFrequency != other.CenterFrequency ||
+		h.SampleRate != other.SampleRate ||
+		h.SampleFormat != other.SampleFormat ||
+		h.Compressed != other.Compressed ||
+		h.Endianness != other.Endianness {
+		return false
+	}
+	return h.Extension.equal(other.Extension)
+}
+
+// HeaderExtension is metadata about the device that made a capture, which
+// doesn't fit in the fixed size rfcap header.
+type HeaderExtension struct {
+	// HardwareInfo describes the device that made this capture, if known.
+	HardwareInfo sdr.HardwareInfo
+
+	// GainStages are the gain stages of the device that made this capture,
+	// along with the gain each stage was set to when the capture started.
+	GainStages []GainSetting
+
+	// AutomaticGain is true if the device that made this capture had
+	// automatic gain control enabled when the capture started.
+	AutomaticGain bool
+}
+
+// empty will return true if there's nothing in the HeaderExtension worth
+// writing out. A nil HeaderExtension is empty.
+func (ext *HeaderExtension) empty() bool {
+	return ext == nil || (ext.HardwareInfo == (sdr.HardwareInfo{}) &&
+		len(ext.GainStages) == 0 && !ext.AutomaticGain)
+}
+
+// equal will return true if both HeaderExtensions hold the same metadata,
+// treating nil the same as an empty HeaderExtension.
+func (ext *HeaderExtension) equal(other *HeaderExtension) bool {
+	if ext.empty() || other.empty() {
+		return ext.empty() == other.empty()
+	}
+	if ext.HardwareInfo != other.HardwareInfo ||
+		ext.AutomaticGain != other.AutomaticGain ||
+		len(ext.GainStages) != len(other.GainStages) {
+		return false
+	}
+	for i := range ext.GainStages {
+		if ext.GainStages[i] != other.GainStages[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GainSetting is a snapshot of a single gain stage of the device that made a
+// capture, and the gain it was set to. GainSetting implements the
+// sdr.GainStage interface, so it can be handed back to code expecting the
+// real device.
+type GainSetting struct {
+	// Name is the human readable name of the gain stage, as returned by
+	// sdr.GainStage.String.
+	Name string `json:"name"`
+
+	// StageType is the type of the gain stage, as returned by
+	// sdr.GainStage.Type.
+	StageType sdr.GainStageType `json:"type"`
+
+	// Min and Max are the bounds of the gain stage, as returned by
+	// sdr.GainStage.Range.
+	Min float32 `json:"min"`
+	Max float32 `json:"max"`
+
+	// Gain is the gain this stage was set to.
+	Gain float32 `json:"gain"`
+}
+
+// Range implements the sdr.GainStage interface.
+func (gs GainSetting) Range() [2]float32 {
+	return [2]float32{gs.Min, gs.Max}
+}
+
+// Type implements the sdr.GainStage interface.
+func (gs GainSetting) Type() sdr.GainStageType {
+	return gs.StageType
+}
+
+// String implements the sdr.GainStage interface.
+func (gs GainSetting) String() string {
+	return gs.Name
 }
 
 func (h Header) validate() error {
@@ -103,7 +223,64 @@ func (h Header) validate() error {
 	return nil
 }
 
-// HeaderFromSDR will create a Header from the provided SDR
+// DataOffset will return the number of bytes from the start of an rfcap file
+// to the first sample, including any header extension. For a Header that was
+// read from a stream, this is where the samples start in that stream, using
+// the extension length stored there.
+func (h Header) DataOffset() (int64, error) {
+	if h.dataOffset != 0 {
+		return h.dataOffset, nil
+	}
+	ext, err := h.extension()
+	if err != nil {
+		return 0, err
+	}
+	return int64(Size + len(ext)), nil
+}
+
+// SampleCount will return the number of whole samples contained in the
+// provided number of bytes of sample data.
+func (h Header) SampleCount(n int64) int64 {
+	if h.Compressed {
+		// Every block of 4 i16 samples (8 int16 values) is packed into 6
+		// int16 values, or 12 bytes. Partial blocks can't be unpacked.
+		return (n / 12) * 4
+	}
+	size := int64(h.SampleFormat.Size())
+	if size == 0 {
+		return 0
+	}
+	return n / size
+}
+
+// Duration will return how long the provided number of samples last at this
+// Header's SampleRate.
+func (h Header) Duration(samples int64) time.Duration {
+	if h.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(h.SampleRate) * float64(time.Second))
+}
+
+// covers will return true if the frequency is within the bandwidth of the
+// capture.
+func (h Header) covers(freq rf.Hz) bool {
+	halfBandwidth := rf.Hz(h.SampleRate) / 2
+	return freq >= h.CenterFrequency-halfBandwidth &&
+		freq <= h.CenterFrequency+halfBandwidth
+}
+
+// automaticGainGetter is implemented by devices that are able to report
+// if automatic gain control is enabled, such as the rfcap ReaderSdr.
+type automaticGainGetter interface {
+	GetAutomaticGain() (bool, error)
+}
+
+// HeaderFromSDR will create a Header from the provided SDR.
+//
+// The Header doesn't include an Extension, so it can be read by anything that
+// understands version 1 captures. Use HeaderExtensionFromSDR to also record
+// the HardwareInfo and gain settings of the device.
 func HeaderFromSDR(dev sdr.Sdr) (Header, error) {
 	cf, err := dev.GetCenterFrequency()
 	if err != nil {
@@ -125,30 +302,163 @@ func HeaderFromSDR(dev sdr.Sdr) (Header, error) {
 	}, nil
 }
 
-// Unmarshal will encode a header as Bytes.
+// HeaderExtensionFromSDR will create a HeaderExtension from the provided SDR.
+// This will include the HardwareInfo, and the gain of any gain stage that the
+// device is able to report. Setting it as the Extension of a Header will
+// cause the capture to be written as version 2.
+func HeaderExtensionFromSDR(dev sdr.Sdr) (*HeaderExtension, error) {
+	gainStages, err := dev.GetGainStages()
+	if err != nil && err != sdr.ErrNotSupported {
+		return nil, err
+	}
+
+	gainSettings := []GainSetting{}
+	for _, gainStage := range gainStages {
+		gain, err := dev.GetGain(gainStage)
+		if err != nil {
+			// Not every device can report the gain of every stage,
+			// or the gain may not have been set yet.
+			continue
+		}
+		rng := gainStage.Range()
+		gainSettings = append(gainSettings, GainSetting{
+			Name:      gainStage.String(),
+			StageType: gainStage.Type(),
+			Min:       rng[0],
+			Max:       rng[1],
+			Gain:      gain,
+		})
+	}
+	if len(gainSettings) == 0 {
+		gainSettings = nil
+	}
+
+	var automaticGain bool
+	if agcDev, ok := dev.(automaticGainGetter); ok {
+		if automaticGain, err = agcDev.GetAutomaticGain(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &HeaderExtension{
+		HardwareInfo:  dev.HardwareInfo(),
+		GainStages:    gainSettings,
+		AutomaticGain: automaticGain,
+	}, nil
+}
+
+// Unmarshal will decode a header, and any header extension, from Bytes. This
+// will return an error if the Magic isn't a known rfcap version.
 func (h *Header) Unmarshal(b []byte) error {
-	buf := bytes.NewBuffer(b)
-	rh := rawHeader{}
-	if err := binary.Read(buf, binary.LittleEndian, &rh); err != nil {
+	hdr, err := ReadHeader(bytes.NewBuffer(b))
+	if err != nil {
 		return err
 	}
-	*h = rh.asExportHeader()
+	*h = hdr
 	return nil
 }
 
-// Marshal will encode a header as Bytes.
+// Marshal will encode a header, and any header extension, as Bytes. This will
+// return an error if the Magic isn't a known rfcap version.
 func (h *Header) Marshal() ([]byte, error) {
 	b := &bytes.Buffer{}
-	if err := binary.Write(b, binary.LittleEndian, h.asBinaryHeader()); err != nil {
+	if err := writeHeader(b, *h); err != nil {
 		return nil, err
 	}
 	return b.Bytes(), nil
 }
 
+// writeHeader will write the rfcap Header, and any extension, to the
+// io.Writer.
+func writeHeader(out io.Writer, h Header) error {
+	ext, err := h.extension()
+	if err != nil {
+		return err
+	}
+
+	bh := h.asBinaryHeader()
+	if len(ext) > 0 {
+		bh.Magic = MagicVersion2
+		bh.ExtensionLength = uint32(len(ext))
+	}
+	if err := bh.Validate(); err != nil {
+		return err
+	}
+
+	// The header is written in a single call, so that a header is never
+	// split if the io.Writer is a pipe or a socket.
+	b := &bytes.Buffer{}
+	if err := binary.Write(b, binary.LittleEndian, bh); err != nil {
+		return err
+	}
+	b.Write(ext)
+	_, err = out.Write(b.Bytes())
+	return err
+}
+
+// headerExtension is the metadata that is stored after the fixed size rfcap
+// header, if there's anything to store. This is encoded as JSON, and padded
+// with NUL bytes to keep sample data aligned to 128 bits.
+type headerExtension struct {
+	HardwareInfo  *sdr.HardwareInfo `json:"hardware_info,omitempty"`
+	GainStages    []GainSetting     `json:"gain_stages,omitempty"`
+	AutomaticGain bool              `json:"automatic_gain,omitempty"`
+}
+
+// extension will return the encoded header extension, or nil if there is
+// no metadata to store.
+func (h Header) extension() ([]byte, error) {
+	if h.Extension.empty() {
+		return nil, nil
+	}
+
+	ext := headerExtension{
+		GainStages:    h.Extension.GainStages,
+		AutomaticGain: h.Extension.AutomaticGain,
+	}
+	if h.Extension.HardwareInfo != (sdr.HardwareInfo{}) {
+		ext.HardwareInfo = &h.Extension.HardwareInfo
+	}
+
+	b, err := json.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+	if pad := len(b) % 16; pad != 0 {
+		b = append(b, make([]byte, 16-pad)...)
+	}
+	return b, nil
+}
+
+// setExtension will decode the header extension into the Header.
+func (h *Header) setExtension(b []byte) error {
+	b = bytes.TrimRight(b, "\x00")
+	if len(b) == 0 {
+		return nil
+	}
+
+	ext := headerExtension{}
+	if err := json.Unmarshal(b, &ext); err != nil {
+		return fmt.Errorf("rfcap: malformed header extension: %w", err)
+	}
+	h.Extension = &HeaderExtension{
+		GainStages:    ext.GainStages,
+		AutomaticGain: ext.AutomaticGain,
+	}
+	if ext.HardwareInfo != nil {
+		h.Extension.HardwareInfo = *ext.HardwareInfo
+	}
+	return nil
+}
+
 // rawHeader is the format that we actually i/o with. This lets us control
 // the types we write out and be a bit more explicit about alignment. We always
 // want to align to 128 bits in order to complex64 sample streams to maintain
 // alignment if the consumer is not rfcap aware.
+//
+// ExtensionLength is the number of bytes of header extension that follow
+// a version 2 rawHeader, before the sample data. This is always a multiple
+// of 16. In a version 1 header, these bytes are reserved, and ignored.
 type rawHeader struct {
 	Magic           [6]byte
 	CaptureTime     int64
@@ -156,16 +466,35 @@ type rawHeader struct {
 	SampleRate      uint32
 	SampleFormat    uint8
 	Endianness      uint8
-	Reserved        [20]uint8
+	ExtensionLength uint32
+	Reserved        [16]uint8
+}
+
+// extensionLength will return the length of the header extension, which is
+// always 0 for a version 1 header.
+func (h rawHeader) extensionLength() uint32 {
+	if Magic(h.Magic) == MagicVersion1 {
+		return 0
+	}
+	return h.ExtensionLength
 }
 
 func (h rawHeader) Validate() error {
 	switch Magic(h.Magic) {
 	case MagicVersion1:
 		return nil
+	case MagicVersion2:
 	default:
 		return fmt.Errorf("Unknown rfcap version")
 	}
+
+	if h.ExtensionLength%16 != 0 {
+		return fmt.Errorf("rfcap: header extension is misaligned")
+	}
+	if h.ExtensionLength > maxExtensionLength {
+		return fmt.Errorf("rfcap: header extension is too large")
+	}
+	return nil
 }
 
 // This will turn the regular Header into an rfcap "binary header" which is
@@ -211,7 +540,7 @@ func byteOrderFromEndianByte(bo uint8) binary.ByteOrder {
 	case byteOrderBigEndian:
 		return binary.BigEndian
 	default:
-		// TODO(paultag): Add a warning
+		// This is reported as an "unknown-endianness" error by Verify.
 		return binary.LittleEndian
 	}
 }
@@ -0,0 +1,379 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// CaptureListing describes a single capture in the JSON directory listing
+// served by FileServer.
+type CaptureListing struct {
+	// Name is the file name of the capture, relative to the directory.
+	Name string `json:"name"`
+
+	// Size is the size of the capture, in bytes.
+	Size int64 `json:"size"`
+
+	// Header is the decoded Header of the capture.
+	Header Header `json:"header"`
+
+	// Samples is the number of samples in the capture, and Duration is
+	// how long they last.
+	Samples  int64         `json:"samples"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+type fileServer struct {
+	root http.FileSystem
+}
+
+// FileServer will return an http.Handler that serves the captures in the
+// http.FileSystem, such as an http.Dir.
+//
+// A GET of a directory returns a JSON list of CaptureListing, one for each
+// capture in the directory. Files that aren't captures are skipped.
+//
+// A GET of a capture returns the capture as-is, with a Content-Type of
+// MimeType. HTTP byte Range requests are supported, as is a "samples" range
+// unit (such as "Range: samples=1000-1999"), which returns a new capture of
+// just those samples, with the CaptureTime adjusted to match.
+//
+// Captures can also be processed on the server by passing query parameters:
+//
+//	start, end     slice the capture, as a sample index, duration or time
+//	decimate       decimate the capture by an integer factor
+//	format         convert the samples to u8, i8, i16 or c64
+//	endianness     write the samples as little or big endian
+//
+// Without an endianness, the byte order of the capture is kept, or little
+// endian is used when converting from u8. Other query parameters are ignored.
+func FileServer(root http.FileSystem) http.Handler {
+	return fileServer{root: root}
+}
+
+func (fs fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	f, err := fs.root.Open(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if stat.IsDir() {
+		fs.serveListing(w, r, name, f)
+		return
+	}
+	serveCapture(w, r, f, stat)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readListing will read the Header of the capture, and work out how long it
+// is from the size of the file.
+func readListing(f io.Reader, stat os.FileInfo) (CaptureListing, error) {
+	hdr, err := ReadHeader(f)
+	if err != nil {
+		return CaptureListing{}, err
+	}
+	offset, err := hdr.DataOffset()
+	if err != nil {
+		return CaptureListing{}, err
+	}
+	samples := hdr.SampleCount(stat.Size() - offset)
+	return CaptureListing{
+		Name:     stat.Name(),
+		Size:     stat.Size(),
+		Header:   hdr,
+		Samples:  samples,
+		Duration: hdr.Duration(samples),
+	}, nil
+}
+
+func (fs fileServer) serveListing(w http.ResponseWriter, r *http.Request, dir string, f http.File) {
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	listing := []CaptureListing{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		capture, err := fs.root.Open(path.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		item, err := readListing(capture, entry)
+		capture.Close()
+		if err != nil {
+			// Not a capture, or not one we can read.
+			continue
+		}
+		listing = append(listing, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(listing)
+}
+
+// captureQueryParams are the query parameters that ask for a capture to be
+// processed. Anything else in the query string is ignored.
+var captureQueryParams = []string{"start", "end", "decimate", "format", "endianness"}
+
+// captureQuery is the processing requested by the query parameters of a
+// request for a capture.
+type captureQuery struct {
+	start      SlicePoint
+	end        SlicePoint
+	decimate   uint
+	format     sdr.SampleFormat
+	endianness string
+}
+
+func parseCaptureQuery(r *http.Request, hdr Header) (captureQuery, bool, error) {
+	var (
+		values = r.URL.Query()
+		q      = captureQuery{format: hdr.SampleFormat}
+		err    error
+	)
+	processed := false
+	for _, param := range captureQueryParams {
+		if values.Get(param) != "" {
+			processed = true
+		}
+	}
+	if !processed {
+		return q, false, nil
+	}
+
+	if q.start, err = ParseSlicePoint(values.Get("start")); err != nil {
+		return q, true, err
+	}
+	if q.end, err = ParseSlicePoint(values.Get("end")); err != nil {
+		return q, true, err
+	}
+	if v := values.Get("decimate"); v != "" {
+		factor, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || factor == 0 {
+			return q, true, fmt.Errorf("rfcap: decimate %q is not a positive integer", v)
+		}
+		q.decimate = uint(factor)
+	}
+	if v := values.Get("format"); v != "" {
+		if q.format, err = ParseSampleFormat(v); err != nil {
+			return q, true, err
+		}
+	}
+	q.endianness = values.Get("endianness")
+	return q, true, nil
+}
+
+// parseSampleRange will parse a "samples" Range header, returning the first
+// sample and the sample after the last, for a capture of total samples.
+func parseSampleRange(value string, total int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(value, "samples=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("rfcap: multiple sample ranges are not supported")
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("rfcap: malformed sample range %q", value)
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	var start, end int64
+	switch {
+	case first == "" && last == "":
+		return 0, 0, fmt.Errorf("rfcap: malformed sample range %q", value)
+	case first == "":
+		// The last n samples.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("rfcap: malformed sample range %q", value)
+		}
+		if n > total {
+			n = total
+		}
+		start, end = total-n, total
+	default:
+		var err error
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("rfcap: malformed sample range %q", value)
+		}
+		end = total
+		if last != "" {
+			l, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || l < start {
+				return 0, 0, fmt.Errorf("rfcap: malformed sample range %q", value)
+			}
+			if l+1 < end {
+				end = l + 1
+			}
+		}
+	}
+	if start >= total {
+		return 0, 0, fmt.Errorf("rfcap: sample range %q is past the end of the capture", value)
+	}
+	return start, end, nil
+}
+
+func serveCapture(w http.ResponseWriter, r *http.Request, f http.File, stat os.FileInfo) {
+	listing, err := readListing(f, stat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	hdr := listing.Header
+
+	q, processed, err := parseCaptureQuery(r, hdr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rng := r.Header.Get("Range")
+	if !strings.HasPrefix(rng, "samples=") {
+		rng = ""
+	}
+
+	w.Header().Set("Content-Type", MimeType)
+	w.Header().Set("Accept-Ranges", "bytes, samples")
+
+	if !processed && rng == "" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			httpError(w, err)
+			return
+		}
+		http.ServeContent(w, r, stat.Name(), stat.ModTime(), f)
+		return
+	}
+
+	status := http.StatusOK
+	if rng != "" {
+		start, end, err := parseSampleRange(rng, listing.Samples)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("samples */%d", listing.Samples))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if !q.start.IsZero() || !q.end.IsZero() {
+			http.Error(w, "a samples range can't be used with start or end", http.StatusBadRequest)
+			return
+		}
+		q.start, q.end = AtSample(start), AtSample(end)
+
+		w.Header().Set("Content-Range", fmt.Sprintf(
+			"samples %d-%d/%d", start, end-1, listing.Samples,
+		))
+		status = http.StatusPartialContent
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		httpError(w, err)
+		return
+	}
+	outHdr, sr, err := sliceReader(f, q.start, q.end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transforms := []Transform{}
+	if q.decimate > 1 {
+		transforms = append(transforms, Decimate(q.decimate))
+	}
+	sr, outHdr, err = Transformer(outHdr, sr, transforms...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	outHdr.SampleFormat = q.format
+	outHdr.Compressed = hdr.Compressed && q.format == sdr.SampleFormatI16
+	if hdr.SampleFormat == sdr.SampleFormatU8 {
+		// u8 samples have no byte order of their own, so there's nothing
+		// to carry over to a wider sample format.
+		outHdr.Endianness = binary.LittleEndian
+	}
+	if q.endianness != "" {
+		if outHdr.Endianness, err = ParseByteOrder(q.endianness); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := outHdr.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	// Once the body has started there's no way to report an error, other
+	// than cutting the response short.
+	writeC64(w, outHdr, sr)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,230 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+var httpEpoch = time.Unix(1600000000, 0)
+
+func httpServer(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+
+	samples := make(sdr.SamplesI16, 100)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i * 256), int16(-i * 256)}
+	}
+	writeCapture(t, filepath.Join(dir, "b.rfcap"), rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     httpEpoch,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      10,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.LittleEndian,
+	}, samples)
+	writeCapture(t, filepath.Join(dir, "a.rfcap"), rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     httpEpoch,
+		CenterFrequency: 200 * rf.MHz,
+		SampleRate:      10,
+		SampleFormat:    sdr.SampleFormatU8,
+	}, make(sdr.SamplesU8, 10))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0644))
+
+	server := httptest.NewServer(rfcap.FileServer(http.Dir(dir)))
+	return server, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func httpGet(t *testing.T, url string, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestFileServerListing(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	resp := httpGet(t, server.URL+"/", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	listing := []rfcap.CaptureListing{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&listing))
+	assert.Equal(t, 2, len(listing))
+	assert.Equal(t, "a.rfcap", listing[0].Name)
+	assert.Equal(t, 200*rf.MHz, listing[0].Header.CenterFrequency)
+	assert.Equal(t, "b.rfcap", listing[1].Name)
+	assert.Equal(t, int64(100), listing[1].Samples)
+	assert.Equal(t, 10*time.Second, listing[1].Duration)
+}
+
+func TestFileServerCapture(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	resp := httpGet(t, server.URL+"/b.rfcap", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, rfcap.MimeType, resp.Header.Get("Content-Type"))
+
+	r, hdr, err := rfcap.Reader(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, hdr.CenterFrequency)
+	samples := make(sdr.SamplesI16, 100)
+	_, err = sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]int16{99 * 256, -99 * 256}, samples[99])
+}
+
+func TestFileServerByteRange(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	resp := httpGet(t, server.URL+"/b.rfcap", http.Header{"Range": {"bytes=0-5"}})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "RFCAP1", string(body))
+}
+
+func TestFileServerSampleRange(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	resp := httpGet(t, server.URL+"/b.rfcap", http.Header{"Range": {"samples=20-29"}})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "samples 20-29/100", resp.Header.Get("Content-Range"))
+
+	r, hdr, err := rfcap.Reader(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, httpEpoch.Add(2*time.Second).Unix(), hdr.CaptureTime.Unix())
+
+	samples := make(sdr.SamplesI16, 11)
+	n, _ := sdr.ReadFull(r, samples)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, [2]int16{20 * 256, -20 * 256}, samples[0])
+	assert.Equal(t, [2]int16{29 * 256, -29 * 256}, samples[9])
+
+	resp = httpGet(t, server.URL+"/b.rfcap", http.Header{"Range": {"samples=-5"}})
+	defer resp.Body.Close()
+	assert.Equal(t, "samples 95-99/100", resp.Header.Get("Content-Range"))
+
+	resp = httpGet(t, server.URL+"/b.rfcap", http.Header{"Range": {"samples=200-"}})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+}
+
+func TestFileServerQuery(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	resp := httpGet(t, server.URL+"/b.rfcap?start=1s&end=5s&decimate=2&format=c64&endianness=big", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r, hdr, err := rfcap.Reader(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, sdr.SampleFormatC64, hdr.SampleFormat)
+	assert.Equal(t, binary.BigEndian, hdr.Endianness)
+	assert.Equal(t, uint(5), hdr.SampleRate)
+	assert.Equal(t, httpEpoch.Add(time.Second).Unix(), hdr.CaptureTime.Unix())
+
+	samples := make(sdr.SamplesC64, 21)
+	n, _ := sdr.ReadFull(r, samples)
+	assert.Equal(t, 20, n)
+}
+
+func TestFileServerQueryDefaults(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	// Unrelated query parameters serve the capture as-is.
+	resp := httpGet(t, server.URL+"/b.rfcap?cache=1", http.Header{"Range": {"bytes=0-3"}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	// Converting from u8 without an endianness defaults to little endian.
+	resp = httpGet(t, server.URL+"/a.rfcap?format=i16", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r, hdr, err := rfcap.Reader(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, sdr.SampleFormatI16, hdr.SampleFormat)
+	assert.Equal(t, binary.LittleEndian, hdr.Endianness)
+
+	samples := make(sdr.SamplesI16, 11)
+	n, _ := sdr.ReadFull(r, samples)
+	assert.Equal(t, 10, n)
+}
+
+func TestFileServerErrors(t *testing.T) {
+	server, cleanup := httpServer(t)
+	defer cleanup()
+
+	for path, status := range map[string]int{
+		"/missing.rfcap":          http.StatusNotFound,
+		"/notes.txt":              http.StatusUnsupportedMediaType,
+		"/b.rfcap?decimate=zero":  http.StatusBadRequest,
+		"/b.rfcap?decimate=3":     http.StatusBadRequest,
+		"/b.rfcap?format=c128":    http.StatusBadRequest,
+		"/b.rfcap?start=tomorrow": http.StatusBadRequest,
+	} {
+		resp := httpGet(t, server.URL+path, nil)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, path)
+	}
+
+	resp, err := http.Post(server.URL+"/b.rfcap", rfcap.MimeType, nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,121 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"hz.tools/sdr"
+	"hz.tools/sdr/fft"
+)
+
+// plan is a radix-2 FFT over a fixed pair of buffers.
+type plan struct {
+	iq        sdr.SamplesC64
+	frequency []complex64
+	direction fft.Direction
+	twiddle   []complex64
+	reverse   []int
+}
+
+// Plan implements the fft.Planner interface with a plain Go radix-2 FFT.
+// The buffers must be the same length, and that length must be a power of
+// two.
+//
+// Like FFTW, neither direction is normalized, so a Forward transform
+// followed by a Backward transform will scale the samples by the length.
+// The frequency data is in fft.ZeroFirst order.
+func Plan(iq sdr.SamplesC64, frequency []complex64, direction fft.Direction) (fft.Plan, error) {
+	n := len(iq)
+	if n != len(frequency) {
+		return nil, fmt.Errorf("dsp: iq and frequency buffers must be the same length")
+	}
+	if n == 0 || n&(n-1) != 0 {
+		return nil, fmt.Errorf("dsp: fft length %d is not a power of two", n)
+	}
+
+	sign := -1.0
+	if direction == fft.Backward {
+		sign = 1.0
+	}
+
+	p := &plan{
+		iq:        iq,
+		frequency: frequency,
+		direction: direction,
+		twiddle:   make([]complex64, n/2),
+		reverse:   make([]int, n),
+	}
+	for i := range p.twiddle {
+		sin, cos := math.Sincos(sign * 2 * math.Pi * float64(i) / float64(n))
+		p.twiddle[i] = complex(float32(cos), float32(sin))
+	}
+	shift := uint(bits.UintSize - bits.TrailingZeros(uint(n)))
+	for i := range p.reverse {
+		if n > 1 {
+			p.reverse[i] = int(bits.Reverse(uint(i)) >> shift)
+		}
+	}
+	return p, nil
+}
+
+// Transform implements the fft.Plan interface.
+func (p *plan) Transform() error {
+	src, dst := []complex64(p.iq), p.frequency
+	if p.direction == fft.Backward {
+		src, dst = dst, src
+	}
+
+	for i, j := range p.reverse {
+		dst[j] = src[i]
+	}
+
+	n := len(dst)
+	for size := 2; size <= n; size <<= 1 {
+		var (
+			half = size / 2
+			step = n / size
+		)
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				var (
+					a = dst[start+k]
+					b = dst[start+k+half] * p.twiddle[k*step]
+				)
+				dst[start+k] = a + b
+				dst[start+k+half] = a - b
+			}
+		}
+	}
+	return nil
+}
+
+// Close implements the fft.Plan interface.
+func (p *plan) Close() error {
+	return nil
+}
+
+// compile time check that Plan is an fft.Planner
+var _ fft.Planner = Plan
+
+// vim: foldmethod=marker
@@ -0,0 +1,95 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+	"hz.tools/sdr/fft"
+)
+
+func TestFFTTone(t *testing.T) {
+	var (
+		iq   = make(sdr.SamplesC64, 64)
+		freq = make([]complex64, 64)
+	)
+	for i := range iq {
+		iq[i] = complex64(cmplx.Rect(1, 2*math.Pi*5*float64(i)/64))
+	}
+
+	assert.NoError(t, fft.TransformOnce(dsp.Plan, iq, freq, fft.Forward))
+	for i, v := range freq {
+		if i == 5 {
+			assert.InDelta(t, 64, cmplx.Abs(complex128(v)), 1e-3)
+			continue
+		}
+		assert.InDelta(t, 0, cmplx.Abs(complex128(v)), 1e-3)
+	}
+}
+
+func TestFFTRoundTrip(t *testing.T) {
+	var (
+		iq   = make(sdr.SamplesC64, 128)
+		out  = make(sdr.SamplesC64, 128)
+		freq = make([]complex64, 128)
+	)
+	for i := range iq {
+		iq[i] = complex(float32(i%7)-3, float32(i%5)-2)
+	}
+
+	assert.NoError(t, fft.TransformOnce(dsp.Plan, iq, freq, fft.Forward))
+	assert.NoError(t, fft.TransformOnce(dsp.Plan, out, freq, fft.Backward))
+	for i := range iq {
+		assert.InDelta(t, real(iq[i]), real(out[i])/128, 1e-4)
+		assert.InDelta(t, imag(iq[i]), imag(out[i])/128, 1e-4)
+	}
+}
+
+func TestFFTBadLength(t *testing.T) {
+	_, err := dsp.Plan(make(sdr.SamplesC64, 12), make([]complex64, 12), fft.Forward)
+	assert.Error(t, err)
+
+	_, err = dsp.Plan(make(sdr.SamplesC64, 16), make([]complex64, 8), fft.Forward)
+	assert.Error(t, err)
+}
+
+func TestWindows(t *testing.T) {
+	hann := dsp.Hann(9)
+	assert.InDelta(t, 0, hann[0], 1e-6)
+	assert.InDelta(t, 1, hann[4], 1e-6)
+	assert.InDelta(t, 0, hann[8], 1e-6)
+
+	hamming := dsp.Hamming(9)
+	assert.InDelta(t, 0.08, hamming[0], 1e-6)
+	assert.InDelta(t, 1, hamming[4], 1e-6)
+
+	blackman := dsp.Blackman(9)
+	assert.InDelta(t, 0, blackman[0], 1e-6)
+	assert.InDelta(t, 1, blackman[4], 1e-6)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,182 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// LowPass will design a windowed-sinc low pass filter with the provided
+// number of taps, passing frequencies up to cutoff, which is given in cycles
+// per sample (so, between 0 and 0.5). The filter has a gain of 1 at 0 Hz.
+func LowPass(cutoff float64, taps int) []complex64 {
+	var (
+		window = Hamming(taps)
+		h      = make([]float64, taps)
+		mid    = float64(taps-1) / 2
+		sum    float64
+	)
+	for i := range h {
+		x := float64(i) - mid
+		if x == 0 {
+			h[i] = 2 * cutoff
+		} else {
+			h[i] = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		h[i] *= float64(window[i])
+		sum += h[i]
+	}
+
+	out := make([]complex64, taps)
+	for i := range h {
+		out[i] = complex(float32(h[i]/sum), 0)
+	}
+	return out
+}
+
+// BandPass will design a complex band pass filter with the provided number
+// of taps, passing frequencies between low and high, which are given in
+// cycles per sample (so, between -0.5 and 0.5). Since this is designed for
+// IQ data, the pass band need not be symmetric around 0 Hz.
+func BandPass(low, high float64, taps int) []complex64 {
+	var (
+		h      = LowPass((high-low)/2, taps)
+		center = (high + low) / 2
+		mid    = float64(taps-1) / 2
+	)
+	for i := range h {
+		h[i] *= complex64(cmplx.Rect(1, 2*math.Pi*center*(float64(i)-mid)))
+	}
+	return h
+}
+
+// FIR is a streaming finite impulse response filter, which can optionally
+// decimate its output. The filter state is kept between calls to Filter, so
+// a stream can be filtered a buffer at a time.
+type FIR struct {
+	taps       []complex64
+	decimation int
+	phase      int
+	work       []complex64
+}
+
+// NewFIR will create a new FIR filter with the provided taps, which will
+// output one sample for every decimation samples of input.
+func NewFIR(taps []complex64, decimation int) *FIR {
+	if decimation < 1 {
+		decimation = 1
+	}
+	// Reverse the taps, so that the convolution below can walk both the
+	// taps and the samples forwards.
+	reversed := make([]complex64, len(taps))
+	for i, tap := range taps {
+		reversed[len(taps)-1-i] = tap
+	}
+	return &FIR{
+		taps:       reversed,
+		decimation: decimation,
+		work:       make([]complex64, len(taps)-1),
+	}
+}
+
+// OutputLength will return the largest number of samples that Filter can
+// write when given n samples of input.
+func (f *FIR) OutputLength(n int) int {
+	return (n + f.decimation - 1) / f.decimation
+}
+
+// Filter will filter the samples in src, writing the output to dst, and
+// return the number of samples written. dst must be at least
+// OutputLength(len(src)) samples long, and may not overlap src.
+func (f *FIR) Filter(dst, src []complex64) int {
+	var (
+		history = len(f.taps) - 1
+		n       int
+	)
+	f.work = append(f.work[:history], src...)
+
+	for i := range src {
+		if f.phase == 0 {
+			var (
+				acc    complex64
+				window = f.work[i : i+len(f.taps)]
+			)
+			for k, tap := range f.taps {
+				acc += tap * window[k]
+			}
+			dst[n] = acc
+			n++
+		}
+		f.phase++
+		if f.phase == f.decimation {
+			f.phase = 0
+		}
+	}
+
+	copy(f.work, f.work[len(src):])
+	f.work = f.work[:history]
+	return n
+}
+
+// Cascade is a chain of FIR filters, each fed the output of the one before.
+// Decimating by a lot in a few smaller steps takes far fewer taps than doing
+// it all at once, since only the last filter needs a narrow transition band,
+// and it runs at the lowest sample rate.
+type Cascade struct {
+	firs []*FIR
+	bufs [][]complex64
+}
+
+// NewCascade will create a Cascade of the provided FIR filters, in order.
+func NewCascade(firs ...*FIR) *Cascade {
+	return &Cascade{
+		firs: firs,
+		bufs: make([][]complex64, len(firs)),
+	}
+}
+
+// OutputLength will return the largest number of samples that Filter can
+// write when given n samples of input.
+func (c *Cascade) OutputLength(n int) int {
+	for _, f := range c.firs {
+		n = f.OutputLength(n)
+	}
+	return n
+}
+
+// Filter will run the samples in src through each filter in turn, writing
+// the output of the last to dst, and return the number of samples written.
+// dst must be at least OutputLength(len(src)) samples long, and may not
+// overlap src.
+func (c *Cascade) Filter(dst, src []complex64) int {
+	last := len(c.firs) - 1
+	for i, f := range c.firs[:last] {
+		if need := f.OutputLength(len(src)); cap(c.bufs[i]) < need {
+			c.bufs[i] = make([]complex64, need)
+		}
+		n := f.Filter(c.bufs[i][:cap(c.bufs[i])], src)
+		src = c.bufs[i][:n]
+	}
+	return c.firs[last].Filter(dst, src)
+}
+
+// vim: foldmethod=marker
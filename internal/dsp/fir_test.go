@@ -0,0 +1,145 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap/internal/dsp"
+)
+
+func tone(freq float64, n int) []complex64 {
+	iq := make([]complex64, n)
+	for i := range iq {
+		iq[i] = complex64(cmplx.Rect(1, 2*math.Pi*freq*float64(i)))
+	}
+	return iq
+}
+
+func power(iq []complex64) float64 {
+	var sum float64
+	for _, v := range iq {
+		sum += float64(real(v)*real(v) + imag(v)*imag(v))
+	}
+	return sum / float64(len(iq))
+}
+
+func TestMixer(t *testing.T) {
+	iq := tone(0.1, 4096)
+	m := dsp.NewMixer(-100, 1000)
+	m.Mix(iq[:1000])
+	m.Mix(iq[1000:])
+
+	// 0.1 cycles per sample shifted down by 0.1 cycles per sample is DC.
+	for _, v := range iq {
+		assert.InDelta(t, 1, real(v), 1e-3)
+		assert.InDelta(t, 0, imag(v), 1e-3)
+	}
+}
+
+func TestLowPass(t *testing.T) {
+	taps := dsp.LowPass(0.1, 129)
+
+	pass := make([]complex64, 4096)
+	dsp.NewFIR(taps, 1).Filter(pass, tone(0.02, 4096))
+	assert.InDelta(t, 1, power(pass[200:]), 0.01)
+
+	stop := make([]complex64, 4096)
+	dsp.NewFIR(taps, 1).Filter(stop, tone(0.3, 4096))
+	assert.True(t, power(stop[200:]) < 1e-4)
+}
+
+func TestBandPass(t *testing.T) {
+	taps := dsp.BandPass(0.1, 0.2, 129)
+
+	pass := make([]complex64, 4096)
+	dsp.NewFIR(taps, 1).Filter(pass, tone(0.15, 4096))
+	assert.InDelta(t, 1, power(pass[200:]), 0.01)
+
+	for _, freq := range []float64{-0.15, 0, 0.35} {
+		stop := make([]complex64, 4096)
+		dsp.NewFIR(taps, 1).Filter(stop, tone(freq, 4096))
+		assert.True(t, power(stop[200:]) < 1e-4)
+	}
+}
+
+func TestFIRStreaming(t *testing.T) {
+	var (
+		taps = dsp.LowPass(0.05, 33)
+		in   = tone(0.01, 1000)
+	)
+
+	whole := make([]complex64, 250)
+	f := dsp.NewFIR(taps, 4)
+	assert.Equal(t, 250, f.Filter(whole, in))
+
+	chunked := []complex64{}
+	f = dsp.NewFIR(taps, 4)
+	for _, size := range []int{1, 7, 100, 3, 889} {
+		out := make([]complex64, f.OutputLength(size))
+		n := f.Filter(out, in[:size])
+		chunked = append(chunked, out[:n]...)
+		in = in[size:]
+	}
+	assert.Equal(t, whole, chunked)
+}
+
+func TestCascade(t *testing.T) {
+	var (
+		in      = tone(0.01, 1000)
+		cascade = func() *dsp.Cascade {
+			return dsp.NewCascade(
+				dsp.NewFIR(dsp.LowPass(0.2, 15), 2),
+				dsp.NewFIR(dsp.LowPass(0.1, 33), 5),
+			)
+		}
+	)
+
+	c := cascade()
+	assert.Equal(t, 100, c.OutputLength(1000))
+	whole := make([]complex64, c.OutputLength(len(in)))
+	assert.Equal(t, 100, c.Filter(whole, in))
+
+	// The tone is well inside the pass band of both filters.
+	assert.InDelta(t, 1, power(whole[20:]), 0.01)
+
+	chunked := []complex64{}
+	c = cascade()
+	for _, size := range []int{1, 7, 100, 3, 889} {
+		out := make([]complex64, c.OutputLength(size))
+		n := c.Filter(out, in[:size])
+		chunked = append(chunked, out[:n]...)
+		in = in[size:]
+	}
+	assert.Equal(t, whole, chunked)
+}
+
+func TestClamp(t *testing.T) {
+	iq := []complex64{complex(2, -3), complex(0.5, -0.5)}
+	dsp.Clamp(iq)
+	assert.Equal(t, []complex64{complex(1, -1), complex(0.5, -0.5)}, iq)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,88 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// mixerRenormalize is the number of samples between renormalizing the
+// phasor of a Mixer, to keep rounding errors from changing its amplitude.
+const mixerRenormalize = 1024
+
+// Mixer is a numerically controlled oscillator that will shift samples in
+// frequency, keeping its phase between calls to Mix.
+type Mixer struct {
+	phasor complex128
+	step   complex128
+	count  int
+}
+
+// NewMixer will create a Mixer that shifts samples up by freq, at the
+// provided sample rate. A negative freq shifts samples down.
+func NewMixer(freq, sampleRate float64) *Mixer {
+	return &Mixer{
+		phasor: 1,
+		step:   cmplx.Rect(1, 2*math.Pi*freq/sampleRate),
+	}
+}
+
+// Mix will shift the samples, in place.
+func (m *Mixer) Mix(iq []complex64) {
+	for i := range iq {
+		iq[i] *= complex64(m.phasor)
+		m.phasor *= m.step
+
+		m.count++
+		if m.count == mixerRenormalize {
+			m.phasor /= complex(cmplx.Abs(m.phasor), 0)
+			m.count = 0
+		}
+	}
+}
+
+// Scale will multiply the samples by the provided gain, in place.
+func Scale(iq []complex64, gain float32) {
+	for i := range iq {
+		iq[i] = complex(real(iq[i])*gain, imag(iq[i])*gain)
+	}
+}
+
+// Clamp will limit both I and Q to the range -1 to 1, in place, so that
+// converting to an integer sample format clips rather than wraps around.
+func Clamp(iq []complex64) {
+	clamp := func(v float32) float32 {
+		switch {
+		case v > 1:
+			return 1
+		case v < -1:
+			return -1
+		default:
+			return v
+		}
+	}
+	for i, v := range iq {
+		iq[i] = complex(clamp(real(v)), clamp(imag(v)))
+	}
+}
+
+// vim: foldmethod=marker
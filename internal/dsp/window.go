@@ -0,0 +1,83 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package dsp
+
+import (
+	"math"
+)
+
+// Rectangular will return a window of the provided length that leaves the
+// samples unchanged.
+func Rectangular(n int) []float32 {
+	window := make([]float32, n)
+	for i := range window {
+		window[i] = 1
+	}
+	return window
+}
+
+// cosineWindow will return a generalized cosine window with the provided
+// coefficients.
+func cosineWindow(n int, a ...float64) []float32 {
+	window := make([]float32, n)
+	if n == 1 {
+		window[0] = 1
+		return window
+	}
+	for i := range window {
+		var (
+			x   = 2 * math.Pi * float64(i) / float64(n-1)
+			v   float64
+			neg = 1.0
+		)
+		for k, ak := range a {
+			v += neg * ak * math.Cos(float64(k)*x)
+			neg = -neg
+		}
+		window[i] = float32(v)
+	}
+	return window
+}
+
+// Hann will return a Hann window of the provided length.
+func Hann(n int) []float32 {
+	return cosineWindow(n, 0.5, 0.5)
+}
+
+// Hamming will return a Hamming window of the provided length.
+func Hamming(n int) []float32 {
+	return cosineWindow(n, 0.54, 0.46)
+}
+
+// Blackman will return a Blackman window of the provided length.
+func Blackman(n int) []float32 {
+	return cosineWindow(n, 0.42, 0.5, 0.08)
+}
+
+// ApplyWindow will multiply the samples by the window, in place. The window
+// must be at least as long as the samples.
+func ApplyWindow(iq []complex64, window []float32) {
+	for i := range iq {
+		iq[i] *= complex(window[i], 0)
+	}
+}
+
+// vim: foldmethod=marker
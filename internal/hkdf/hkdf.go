@@ -0,0 +1,56 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package hkdf implements HKDF (RFC 5869) with HMAC-SHA256, which rfcap uses
+// to derive a key for each envelope, without pulling in golang.org/x/crypto.
+package hkdf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Key will derive a key of keyLen bytes from the secret, salt and info. The
+// keyLen must be no more than 255 times the size of a SHA256 hash.
+func Key(secret, salt, info []byte, keyLen int) []byte {
+	// PRK = HMAC-Hash(salt, IKM)
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var (
+		expand = hmac.New(sha256.New, prk)
+		key    = make([]byte, 0, keyLen+expand.Size())
+		t      []byte
+	)
+
+	// T(n) = HMAC-Hash(PRK, T(n-1) || info || n)
+	for counter := byte(1); len(key) < keyLen; counter++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		t = expand.Sum(t[:0])
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// vim: foldmethod=marker
@@ -45,55 +45,200 @@ func CompressReader(in sdr.Reader) (sdr.Reader, error) {
 	})
 }
 
+// blockLength is the number of iq samples that are packed together. Four i16
+// iq samples (8 int16 values) are packed into three iq samples (6 int16
+// values).
+const blockLength = 4
+
+// packedBlockLength is the number of packed iq samples that a block of
+// blockLength iq samples is packed into.
+const packedBlockLength = 3
+
+// bufferLength is the number of unpacked iq samples processed at a time.
+const bufferLength = 32 * 1024
+
+type compressWriter struct {
+	out     sdr.Writer
+	pending sdr.SamplesI16
+	buf     sdr.SamplesI16
+}
+
 // CompressWriter will write out int16 (really int12) values packed into
 // int16 values.
+//
+// Samples are packed in blocks of 4, so up to 3 samples may be held by the
+// Writer until the next call to Write. The returned sdr.Writer has a
+// Flush method, which must be called once the last samples have been
+// written, to pad out and write the last partial block.
 func CompressWriter(out sdr.Writer) (sdr.Writer, error) {
 	if out.SampleFormat() != sdr.SampleFormatI16 {
 		return nil, fmt.Errorf("compress: only i16 supported")
 	}
 
-	pipeReader, pipeWriter := sdr.Pipe(out.SampleRate(), out.SampleFormat())
-	cr, err := CompressReader(pipeReader)
+	return &compressWriter{
+		out:     out,
+		pending: make(sdr.SamplesI16, 0, blockLength),
+		buf:     make(sdr.SamplesI16, (bufferLength/blockLength)*packedBlockLength),
+	}, nil
+}
+
+func (cw *compressWriter) SampleRate() uint {
+	return cw.out.SampleRate()
+}
+
+func (cw *compressWriter) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatI16
+}
+
+// write will compress and write out the iq samples, which must be a multiple
+// of blockLength.
+func (cw *compressWriter) write(iq sdr.SamplesI16) error {
+	n, err := CompressI16(iq, cw.buf)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	i, err := cw.out.Write(cw.buf[:n])
+	if err != nil {
+		return err
+	}
+	if i != n {
+		return sdr.ErrShortWrite
 	}
+	return nil
+}
+
+func (cw *compressWriter) Write(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesI16)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	n := len(iq)
 
-	inb := make(sdr.SamplesI16, 32*1024)
-
-	go func() {
-		for {
-			n, err := sdr.ReadFull(cr, inb)
-			if err != nil {
-				pipeReader.CloseWithError(err)
-				return
-			}
-			_, err = out.Write(inb[:n])
-			if err != nil {
-				pipeReader.CloseWithError(err)
-				return
-			}
+	if len(cw.pending) > 0 {
+		i := blockLength - len(cw.pending)
+		if i > len(iq) {
+			i = len(iq)
 		}
-	}()
+		cw.pending = append(cw.pending, iq[:i]...)
+		iq = iq[i:]
 
-	return pipeWriter, nil
+		if len(cw.pending) < blockLength {
+			return n, nil
+		}
+		if err := cw.write(cw.pending); err != nil {
+			return 0, err
+		}
+		cw.pending = cw.pending[:0]
+	}
 
+	for len(iq) >= blockLength {
+		end := len(iq) - len(iq)%blockLength
+		if end > bufferLength {
+			end = bufferLength
+		}
+		if err := cw.write(iq[:end]); err != nil {
+			return n - len(iq), err
+		}
+		iq = iq[end:]
+	}
+
+	cw.pending = append(cw.pending, iq...)
+	return n, nil
+}
+
+// Flush will pad out any partial block with silence, and write it. Since
+// samples are only written in whole blocks, a reader will see up to 3
+// extra samples of silence at the end of the stream.
+func (cw *compressWriter) Flush() error {
+	if len(cw.pending) == 0 {
+		return nil
+	}
+	for len(cw.pending) < blockLength {
+		cw.pending = append(cw.pending, [2]int16{0, 0})
+	}
+	if err := cw.write(cw.pending); err != nil {
+		return err
+	}
+	cw.pending = cw.pending[:0]
+	return nil
+}
+
+type decompressReader struct {
+	in      sdr.Reader
+	packed  sdr.SamplesI16
+	carry   int
+	pending sdr.SamplesI16
+	buf     sdr.SamplesI16
+	err     error
 }
 
 // DecompressReader will unpack 12bit values into i16 values.
+//
+// If the stream ends partway through a packed block, the partial block is
+// dropped.
 func DecompressReader(in sdr.Reader) (sdr.Reader, error) {
 	if in.SampleFormat() != sdr.SampleFormatI16 {
 		return nil, fmt.Errorf("compress: only i16 supported")
 	}
 
-	return stream.ReadTransformer(in, stream.ReadTransformerConfig{
-		InputBufferLength:  ((32 * 1024) / 4) * 3,
-		OutputBufferLength: 32 * 1024,
-		OutputSampleRate:   in.SampleRate(),
-		OutputSampleFormat: in.SampleFormat(),
-		Proc: func(in, out sdr.Samples) (int, error) {
-			return DecompressI16(in.(sdr.SamplesI16), out.(sdr.SamplesI16))
-		},
-	})
+	return &decompressReader{
+		in:     in,
+		packed: make(sdr.SamplesI16, (bufferLength/blockLength)*packedBlockLength),
+		buf:    make(sdr.SamplesI16, bufferLength),
+	}, nil
+}
+
+func (dr *decompressReader) SampleRate() uint {
+	return dr.in.SampleRate()
+}
+
+func (dr *decompressReader) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatI16
+}
+
+// fill will read at least one whole packed block from the underlying
+// Reader, and unpack all whole blocks read into the pending buffer.
+func (dr *decompressReader) fill() error {
+	for {
+		if dr.err != nil {
+			return dr.err
+		}
+
+		n, err := dr.in.Read(dr.packed[dr.carry:])
+		dr.err = err
+
+		total := dr.carry + n
+		whole := total - total%packedBlockLength
+		if whole == 0 {
+			dr.carry = total
+			continue
+		}
+
+		i, err := DecompressI16(dr.packed[:whole], dr.buf)
+		if err != nil {
+			return err
+		}
+		dr.carry = copy(dr.packed, dr.packed[whole:total])
+		dr.pending = dr.buf[:i]
+		return nil
+	}
+}
+
+func (dr *decompressReader) Read(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesI16)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+
+	if len(dr.pending) == 0 {
+		if err := dr.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(iq, dr.pending)
+	dr.pending = dr.pending[n:]
+	return n, nil
 }
 
 // vim: foldmethod=marker
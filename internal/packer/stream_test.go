@@ -21,6 +21,7 @@
 package packer_test
 
 import (
+	"io"
 	"math"
 	"sync"
 	"testing"
@@ -123,4 +124,41 @@ func TestCarrierWaveWriter(t *testing.T) {
 	}
 }
 
+func TestWriterReaderShortBlocks(t *testing.T) {
+	in := make(sdr.SamplesI16, 10)
+	makeSine(in, 100, 7)
+
+	pipeReader, pipeWriter := sdr.Pipe(100, sdr.SampleFormatI16)
+
+	packedWriter, err := packer.CompressWriter(pipeWriter)
+	assert.NoError(t, err)
+	plainReader, err := packer.DecompressReader(pipeReader)
+	assert.NoError(t, err)
+
+	go func() {
+		// Write one sample at a time to make sure that partial blocks
+		// are held until they're filled.
+		for i := range in {
+			packedWriter.Write(in[i : i+1])
+		}
+		packedWriter.(interface{ Flush() error }).Flush()
+		pipeWriter.CloseWithError(io.EOF)
+	}()
+
+	out := make(sdr.SamplesI16, 12)
+	n, err := sdr.ReadFull(plainReader, out[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = sdr.ReadFull(plainReader, out[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, 11, n)
+	assert.Equal(t, in, out[:10])
+
+	// The last block is padded out with silence.
+	assert.Equal(t, sdr.SamplesI16{{0, 0}, {0, 0}}, out[10:])
+
+	_, err = plainReader.Read(out)
+	assert.Equal(t, io.EOF, err)
+}
+
 // vim: foldmethod=marker
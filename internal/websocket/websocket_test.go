@@ -0,0 +1,135 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package websocket_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap/internal/websocket"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	assert.Equal(t,
+		"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="),
+	)
+}
+
+// echoServer will start a server that sends back every message it gets.
+func echoServer(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(op, data); err != nil {
+				return
+			}
+		}
+	}))
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	server, url := echoServer(t)
+	defer server.Close()
+
+	conn, err := websocket.Dial(url)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.OpText, []byte("hello")))
+	op, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.OpText, op)
+	assert.Equal(t, "hello", string(data))
+
+	// Cover each of the payload length encodings.
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000, 200000} {
+		payload := bytes.Repeat([]byte{0xA5, 0x5A, 0x01}, size/3+1)[:size]
+		assert.NoError(t, conn.WriteMessage(websocket.OpBinary, payload))
+		op, data, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.OpBinary, op)
+		assert.Equal(t, payload, data)
+	}
+}
+
+func TestClose(t *testing.T) {
+	server, url := echoServer(t)
+	defer server.Close()
+
+	conn, err := websocket.Dial(url)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, websocket.ErrClosed, conn.WriteMessage(websocket.OpText, []byte("hi")))
+}
+
+func TestCloseFromServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.OpText, []byte("bye"))
+		conn.Close()
+	}))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws" + strings.TrimPrefix(server.URL, "http"))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "bye", string(data))
+	_, _, err = conn.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNotWebSocket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := websocket.Upgrade(w, r)
+		assert.Equal(t, websocket.ErrNotWebSocket, err)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// vim: foldmethod=marker
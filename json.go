@@ -0,0 +1,172 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"hz.tools/rf"
+	"hz.tools/sdr"
+)
+
+// SampleFormatName will return the short name of the sample format, as
+// used in the JSON encoding of a Header, and accepted by ParseSampleFormat.
+func SampleFormatName(sf sdr.SampleFormat) string {
+	switch sf {
+	case sdr.SampleFormatU8:
+		return "u8"
+	case sdr.SampleFormatI8:
+		return "i8"
+	case sdr.SampleFormatI16:
+		return "i16"
+	case sdr.SampleFormatC64:
+		return "c64"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSampleFormat will parse the short name of a sample format (such as
+// "c64" or "i16") as returned by SampleFormatName.
+func ParseSampleFormat(name string) (sdr.SampleFormat, error) {
+	for _, sf := range []sdr.SampleFormat{
+		sdr.SampleFormatU8,
+		sdr.SampleFormatI8,
+		sdr.SampleFormatI16,
+		sdr.SampleFormatC64,
+	} {
+		if strings.EqualFold(name, SampleFormatName(sf)) {
+			return sf, nil
+		}
+	}
+	return 0, fmt.Errorf("rfcap: unknown sample format %q", name)
+}
+
+// ByteOrderName will return "little" or "big" for the provided
+// binary.ByteOrder, as used in the JSON encoding of a Header.
+func ByteOrderName(bo binary.ByteOrder) string {
+	switch bo {
+	case binary.LittleEndian:
+		return "little"
+	case binary.BigEndian:
+		return "big"
+	case nil:
+		return ""
+	default:
+		return "unknown"
+	}
+}
+
+// ParseByteOrder will parse "little" or "big" into a binary.ByteOrder, as
+// returned by ByteOrderName.
+func ParseByteOrder(name string) (binary.ByteOrder, error) {
+	switch strings.ToLower(name) {
+	case "little", "le":
+		return binary.LittleEndian, nil
+	case "big", "be":
+		return binary.BigEndian, nil
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("rfcap: unknown byte order %q", name)
+	}
+}
+
+// jsonHeader is the JSON encoding of a Header.
+type jsonHeader struct {
+	Magic           string            `json:"magic"`
+	Version         string            `json:"version"`
+	CaptureTime     time.Time         `json:"capture_time"`
+	CenterFrequency rf.Hz             `json:"center_frequency"`
+	SampleRate      uint              `json:"sample_rate"`
+	SampleFormat    string            `json:"sample_format"`
+	Compressed      bool              `json:"compressed"`
+	Endianness      string            `json:"endianness,omitempty"`
+	HardwareInfo    *sdr.HardwareInfo `json:"hardware_info,omitempty"`
+	GainStages      []GainSetting     `json:"gain_stages,omitempty"`
+	AutomaticGain   bool              `json:"automatic_gain,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (h Header) MarshalJSON() ([]byte, error) {
+	jh := jsonHeader{
+		Magic:           string(h.Magic[:]),
+		Version:         h.Magic.String(),
+		CaptureTime:     h.CaptureTime,
+		CenterFrequency: h.CenterFrequency,
+		SampleRate:      h.SampleRate,
+		SampleFormat:    SampleFormatName(h.SampleFormat),
+		Compressed:      h.Compressed,
+		Endianness:      ByteOrderName(h.Endianness),
+	}
+	if ext := h.Extension; !ext.empty() {
+		jh.GainStages = ext.GainStages
+		jh.AutomaticGain = ext.AutomaticGain
+		if ext.HardwareInfo != (sdr.HardwareInfo{}) {
+			jh.HardwareInfo = &ext.HardwareInfo
+		}
+	}
+	return json.Marshal(jh)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (h *Header) UnmarshalJSON(b []byte) error {
+	jh := jsonHeader{}
+	if err := json.Unmarshal(b, &jh); err != nil {
+		return err
+	}
+
+	sampleFormat, err := ParseSampleFormat(jh.SampleFormat)
+	if err != nil {
+		return err
+	}
+	endianness, err := ParseByteOrder(jh.Endianness)
+	if err != nil {
+		return err
+	}
+
+	hdr := Header{
+		CaptureTime:     jh.CaptureTime,
+		CenterFrequency: jh.CenterFrequency,
+		SampleRate:      jh.SampleRate,
+		SampleFormat:    sampleFormat,
+		Compressed:      jh.Compressed,
+		Endianness:      endianness,
+	}
+	copy(hdr.Magic[:], jh.Magic)
+	if jh.HardwareInfo != nil || len(jh.GainStages) > 0 || jh.AutomaticGain {
+		hdr.Extension = &HeaderExtension{
+			GainStages:    jh.GainStages,
+			AutomaticGain: jh.AutomaticGain,
+		}
+		if jh.HardwareInfo != nil {
+			hdr.Extension.HardwareInfo = *jh.HardwareInfo
+		}
+	}
+	*h = hdr
+	return nil
+}
+
+// vim: foldmethod=marker
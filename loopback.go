@@ -0,0 +1,268 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"hz.tools/sdr"
+)
+
+// LoopbackConfig controls how the Transceiver returned by Loopback is wired
+// together.
+type LoopbackConfig struct {
+	// Header describes the device being emulated. The CenterFrequency,
+	// SampleRate and SampleFormat will be reported back through the sdr.Sdr
+	// interface, and used for any recordings.
+	//
+	// If the Magic is not set, and RxSeed is not nil, the Header will be
+	// taken from the RxSeed capture.
+	Header Header
+
+	// Channel, if not nil, is applied to the samples written to the
+	// Transmitter before they come back out of the Receiver. This is where
+	// noise, gain, frequency offsets or any other impairments can be added.
+	// The returned sdr.Reader must be in the Header's SampleFormat.
+	Channel func(sdr.Reader) (sdr.Reader, error)
+
+	// RxSeed, if not nil, is an rfcap stream that will be read out of the
+	// Receiver before any looped back samples.
+	//
+	// Both seeds must have the same CenterFrequency and SampleRate as the
+	// Header, and the same SampleFormat, unless the Header's SampleFormat
+	// is complex64, which any seed can be converted to without loss.
+	RxSeed io.Reader
+
+	// TxSeed, if not nil, is an rfcap stream that will be sent through the
+	// Channel as if it were transmitted before anything written to the
+	// Transmitter.
+	TxSeed io.Reader
+
+	// RxRecord, if not nil, will have every sample read out of the Receiver
+	// written to it as an rfcap stream.
+	RxRecord io.Writer
+
+	// TxRecord, if not nil, will have every sample written to the
+	// Transmitter written to it as an rfcap stream.
+	TxRecord io.Writer
+}
+
+// Loopback will return a fake "SDR" that complies with the sdr.Transceiver
+// interface, where samples written to the Transmitter are passed through the
+// configured Channel and read back out of the Receiver. This allows for full
+// duplex protocols to be tested end to end without any hardware.
+//
+// Both StartRx and StartTx may only be called once.
+func Loopback(config LoopbackConfig) (sdr.Transceiver, error) {
+	header := config.Header
+	if header.Magic == (Magic{}) && config.RxSeed != nil {
+		var err error
+		header, err = ReadHeader(config.RxSeed)
+		if err != nil {
+			return nil, err
+		}
+		config.RxSeed = &seededStream{header: header, r: config.RxSeed}
+	}
+
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+
+	rx, tx := sdr.Pipe(header.SampleRate, header.SampleFormat)
+
+	return &loopback{
+		fakeSdr: fakeSdr{header: header},
+		config:  config,
+		rx:      rx,
+		tx:      tx,
+	}, nil
+}
+
+// seededStream is an io.Reader whose Header has already been consumed
+// by the time it was handed to us.
+type seededStream struct {
+	header Header
+	r      io.Reader
+}
+
+func (s *seededStream) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+type loopback struct {
+	fakeSdr
+
+	lock      sync.Mutex
+	config    LoopbackConfig
+	rx        sdr.PipeReader
+	tx        sdr.PipeWriter
+	rxStarted bool
+	txStarted bool
+}
+
+// seedReader will open an rfcap seed stream, and make sure it's compatible
+// with the device we're emulating.
+func (l *loopback) seedReader(in io.Reader) (sdr.Reader, error) {
+	var (
+		r   sdr.Reader
+		h   Header
+		err error
+	)
+
+	if seed, ok := in.(*seededStream); ok {
+		h = seed.header
+		r, err = bodyReader(seed.r, h)
+	} else {
+		r, h, err = Reader(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if h.CenterFrequency != l.header.CenterFrequency {
+		return nil, fmt.Errorf(
+			"rfcap: loopback seed center frequency %s does not match %s",
+			h.CenterFrequency, l.header.CenterFrequency,
+		)
+	}
+	if h.SampleRate != l.header.SampleRate {
+		return nil, fmt.Errorf(
+			"rfcap: loopback seed sample rate %d does not match %d",
+			h.SampleRate, l.header.SampleRate,
+		)
+	}
+	// Only complex64 can hold every other sample format, so anything else
+	// has to match exactly.
+	if h.SampleFormat != l.header.SampleFormat && l.header.SampleFormat != sdr.SampleFormatC64 {
+		return nil, fmt.Errorf(
+			"rfcap: loopback seed sample format %s does not match %s",
+			h.SampleFormat, l.header.SampleFormat,
+		)
+	}
+	return newConvertReader(r, l.header.SampleFormat)
+}
+
+func (l *loopback) Close() error {
+	l.tx.CloseWithError(io.EOF)
+	return l.rx.Close()
+}
+
+func (l *loopback) StartRx() (sdr.ReadCloser, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rxStarted {
+		return nil, fmt.Errorf("rfcap: loopback rx already started")
+	}
+	l.rxStarted = true
+
+	var (
+		r   sdr.Reader = l.rx
+		err error
+	)
+
+	if l.config.TxSeed != nil {
+		seed, err := l.seedReader(l.config.TxSeed)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = newConcatReader(seed, r); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.config.Channel != nil {
+		if r, err = l.config.Channel(r); err != nil {
+			return nil, err
+		}
+		if r.SampleFormat() != l.header.SampleFormat {
+			return nil, sdr.ErrSampleFormatMismatch
+		}
+	}
+
+	if l.config.RxSeed != nil {
+		seed, err := l.seedReader(l.config.RxSeed)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = newConcatReader(seed, r); err != nil {
+			return nil, err
+		}
+	}
+
+	var rec sdr.Writer
+	if l.config.RxRecord != nil {
+		if rec, err = Writer(l.config.RxRecord, l.header); err != nil {
+			return nil, err
+		}
+		if r, err = sdr.TeeReader(r, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	return sdr.ReaderWithCloser(r, func() error {
+		if rec != nil {
+			if err := Flush(rec); err != nil {
+				l.rx.Close()
+				return err
+			}
+		}
+		return l.rx.Close()
+	}), nil
+}
+
+func (l *loopback) StartTx() (sdr.WriteCloser, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.txStarted {
+		return nil, fmt.Errorf("rfcap: loopback tx already started")
+	}
+	l.txStarted = true
+
+	var (
+		w   sdr.Writer = l.tx
+		rec sdr.Writer
+		err error
+	)
+	if l.config.TxRecord != nil {
+		if rec, err = Writer(l.config.TxRecord, l.header); err != nil {
+			return nil, err
+		}
+		if w, err = sdr.MultiWriter(rec, w); err != nil {
+			return nil, err
+		}
+	}
+
+	return sdr.WriterWithCloser(w, func() error {
+		if rec != nil {
+			if err := Flush(rec); err != nil {
+				l.tx.CloseWithError(io.EOF)
+				return err
+			}
+		}
+		return l.tx.CloseWithError(io.EOF)
+	}), nil
+}
+
+// vim: foldmethod=marker
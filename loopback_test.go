@@ -0,0 +1,161 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+	"hz.tools/sdr/stream"
+)
+
+func loopbackHeader() rfcap.Header {
+	return rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CenterFrequency: rf.MustParseHz("915MHz"),
+		SampleRate:      1.8e+6,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	}
+}
+
+func TestLoopbackChannel(t *testing.T) {
+	txRecord := &bytes.Buffer{}
+
+	dev, err := rfcap.Loopback(rfcap.LoopbackConfig{
+		Header: loopbackHeader(),
+		Channel: func(r sdr.Reader) (sdr.Reader, error) {
+			return stream.Gain(r, 0.5), nil
+		},
+		TxRecord: txRecord,
+	})
+	assert.NoError(t, err)
+
+	cf, err := dev.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, rf.MustParseHz("915MHz"), cf)
+
+	rx, err := dev.StartRx()
+	assert.NoError(t, err)
+	tx, err := dev.StartTx()
+	assert.NoError(t, err)
+
+	_, err = dev.StartRx()
+	assert.Error(t, err)
+
+	refSamples := sdr.SamplesC64{1, 1i, -1, -1i}
+	go func() {
+		tx.Write(refSamples)
+		tx.Close()
+	}()
+
+	out := make(sdr.SamplesC64, len(refSamples))
+	n, err := sdr.ReadFull(rx, out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(refSamples), n)
+	assert.Equal(t, sdr.SamplesC64{0.5, 0.5i, -0.5, -0.5i}, out)
+
+	_, err = rx.Read(out)
+	assert.Equal(t, io.EOF, err)
+
+	recorded, header, err := rfcap.Reader(txRecord)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1.8e+6), header.SampleRate)
+	n, err = sdr.ReadFull(recorded, out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(refSamples), n)
+	assert.Equal(t, refSamples, out)
+}
+
+func TestLoopbackSeed(t *testing.T) {
+	seed := &bytes.Buffer{}
+	header := loopbackHeader()
+	header.SampleFormat = sdr.SampleFormatI16
+	w, err := rfcap.Writer(seed, header)
+	assert.NoError(t, err)
+	ramp := make(sdr.SamplesI16, 4)
+	for i := range ramp {
+		ramp[i] = [2]int16{int16(i * 8192), int16(-i * 8192)}
+	}
+	_, err = w.Write(ramp)
+	assert.NoError(t, err)
+
+	dev, err := rfcap.Loopback(rfcap.LoopbackConfig{
+		Header: loopbackHeader(),
+		RxSeed: seed,
+	})
+	assert.NoError(t, err)
+
+	rx, err := dev.StartRx()
+	assert.NoError(t, err)
+	tx, err := dev.StartTx()
+	assert.NoError(t, err)
+
+	go func() {
+		tx.Write(sdr.SamplesC64{1, 1})
+		tx.Close()
+	}()
+
+	out := make(sdr.SamplesC64, 6)
+	n, err := sdr.ReadFull(rx, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	for i, s := range out[:4] {
+		assert.InDelta(t, float64(i)*0.25, real(s), 1e-3, "sample %d", i)
+		assert.InDelta(t, -float64(i)*0.25, imag(s), 1e-3, "sample %d", i)
+	}
+	assert.Equal(t, sdr.SamplesC64{1, 1}, out[4:])
+}
+
+func TestLoopbackSeedMismatch(t *testing.T) {
+	for name, change := range map[string]func(*rfcap.Header){
+		"center frequency": func(h *rfcap.Header) { h.CenterFrequency += rf.MHz },
+		"sample rate":      func(h *rfcap.Header) { h.SampleRate *= 2 },
+		"sample format":    func(h *rfcap.Header) { h.SampleFormat = sdr.SampleFormatU8 },
+	} {
+		seed := &bytes.Buffer{}
+		header := loopbackHeader()
+		header.SampleFormat = sdr.SampleFormatI16
+		change(&header)
+		_, err := rfcap.Writer(seed, header)
+		assert.NoError(t, err, name)
+
+		device := loopbackHeader()
+		device.SampleFormat = sdr.SampleFormatI16
+		dev, err := rfcap.Loopback(rfcap.LoopbackConfig{
+			Header: device,
+			RxSeed: seed,
+		})
+		assert.NoError(t, err, name)
+
+		_, err = dev.StartRx()
+		assert.Error(t, err, name)
+	}
+}
+
+// vim: foldmethod=marker
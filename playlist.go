@@ -0,0 +1,318 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"hz.tools/rf"
+	"hz.tools/sdr"
+)
+
+// playlistEntry is a single capture that is part of a Playlist.
+type playlistEntry struct {
+	path    string
+	header  Header
+	samples int64
+}
+
+// end will return the time at which the last sample in this capture was
+// taken.
+func (pe playlistEntry) end() time.Time {
+	return pe.header.CaptureTime.Add(pe.header.Duration(pe.samples))
+}
+
+// Playlist is an sdr.Receiver that plays back a number of rfcap captures as if
+// they were a single device. By default, all captures are played back to back
+// in the order in which they were captured. Once SetCenterFrequency is
+// called, only captures that contain that frequency in their bandwidth will
+// be played. Samples are played back as they were captured, without being
+// shifted, so GetCenterFrequency reports the center frequency of the capture
+// being played rather than the frequency that was asked for.
+//
+// Since no samples exist between two captures, the Playlist will record an
+// EventGap when moving to a capture that started after the previous one
+// ended, and an EventCenterFrequency when the center frequency of the next
+// capture is different. This mirrors the Events recorded by a Recorder.
+//
+// All captures must share the same sample rate. Captures in a different
+// sample format will be converted to the format of the earliest capture.
+type Playlist struct {
+	fakeSdr
+
+	lock            sync.Mutex
+	entries         []playlistEntry
+	centerFrequency *rf.Hz
+	events          []Event
+
+	// playing is the capture being played back by the last stream started
+	// by StartRx, or nil if it hasn't opened one yet.
+	playing *playlistEntry
+}
+
+// PlaylistSdr will return a Playlist made up of the rfcap files at the
+// provided paths. Only the headers are read here; each file is opened in turn
+// as it's played back.
+func PlaylistSdr(paths ...string) (*Playlist, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("rfcap: playlist has no captures")
+	}
+
+	entries := make([]playlistEntry, len(paths))
+	for i, path := range paths {
+		entry, err := openPlaylistEntry(path)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].header.CaptureTime.Before(entries[j].header.CaptureTime)
+	})
+
+	for _, entry := range entries[1:] {
+		if entry.header.SampleRate != entries[0].header.SampleRate {
+			return nil, fmt.Errorf(
+				"rfcap: %s has a sample rate of %d, but %s has %d",
+				entry.path, entry.header.SampleRate,
+				entries[0].path, entries[0].header.SampleRate,
+			)
+		}
+	}
+
+	return &Playlist{
+		fakeSdr: fakeSdr{header: entries[0].header},
+		entries: entries,
+	}, nil
+}
+
+func openPlaylistEntry(path string) (playlistEntry, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return playlistEntry{}, err
+	}
+	defer fd.Close()
+
+	header, err := ReadHeader(fd)
+	if err != nil {
+		return playlistEntry{}, fmt.Errorf("rfcap: %s: %w", path, err)
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return playlistEntry{}, err
+	}
+	offset, err := header.DataOffset()
+	if err != nil {
+		return playlistEntry{}, err
+	}
+
+	return playlistEntry{
+		path:    path,
+		header:  header,
+		samples: header.SampleCount(stat.Size() - offset),
+	}, nil
+}
+
+// selected will return the entries that are going to be played back.
+func (p *Playlist) selected() []playlistEntry {
+	if p.centerFrequency == nil {
+		return p.entries
+	}
+	ret := []playlistEntry{}
+	for _, entry := range p.entries {
+		if entry.header.covers(*p.centerFrequency) {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+// Events will return all the Events that have happened during the playback
+// started by the last call to StartRx.
+func (p *Playlist) Events() []Event {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]Event{}, p.events...)
+}
+
+// GetCenterFrequency implements the sdr.Sdr interface. This is the center
+// frequency of the capture being played back, or, before playback starts,
+// of the first capture that will be played.
+func (p *Playlist) GetCenterFrequency() (rf.Hz, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.playing != nil {
+		return p.playing.header.CenterFrequency, nil
+	}
+	// SetCenterFrequency only accepts frequencies covered by at least one
+	// capture, so there's always something selected.
+	return p.selected()[0].header.CenterFrequency, nil
+}
+
+// SetCenterFrequency implements the sdr.Sdr interface. This will limit
+// playback to captures whose bandwidth contains this frequency, and will
+// return an error if there are no such captures. This takes effect on the
+// next call to StartRx.
+func (p *Playlist) SetCenterFrequency(freq rf.Hz) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, entry := range p.entries {
+		if entry.header.covers(freq) {
+			p.centerFrequency = &freq
+			return nil
+		}
+	}
+	return fmt.Errorf("rfcap: no capture in the playlist covers %s", freq)
+}
+
+// StartRx implements the sdr.Receiver interface.
+func (p *Playlist) StartRx() (sdr.ReadCloser, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.events = nil
+	p.playing = nil
+	return &playlistReader{
+		playlist: p,
+		entries:  p.selected(),
+	}, nil
+}
+
+type playlistReader struct {
+	playlist *Playlist
+
+	entries []playlistEntry
+	prev    *playlistEntry
+	fd      *os.File
+	r       sdr.Reader
+	sample  uint64
+}
+
+func (pr *playlistReader) SampleRate() uint {
+	return pr.playlist.header.SampleRate
+}
+
+func (pr *playlistReader) SampleFormat() sdr.SampleFormat {
+	return pr.playlist.header.SampleFormat
+}
+
+// next will open the next capture, and record any Events between the last
+// capture and this one.
+func (pr *playlistReader) next() error {
+	if len(pr.entries) == 0 {
+		return io.EOF
+	}
+	entry := pr.entries[0]
+	pr.entries = pr.entries[1:]
+
+	fd, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	r, _, err := Reader(fd)
+	if err == nil {
+		r, err = newConvertReader(r, pr.SampleFormat())
+	}
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	events := []Event{}
+	if pr.prev != nil {
+		if gap := entry.header.CaptureTime.Sub(pr.prev.end()); gap > 0 {
+			events = append(events, Event{
+				Kind:   EventGap,
+				Time:   pr.prev.end(),
+				Length: uint64(gap.Seconds() * float64(pr.SampleRate())),
+			})
+		}
+	}
+	if pr.prev == nil || pr.prev.header.CenterFrequency != entry.header.CenterFrequency {
+		events = append(events, Event{
+			Kind:            EventCenterFrequency,
+			Time:            entry.header.CaptureTime,
+			CenterFrequency: entry.header.CenterFrequency,
+		})
+	}
+
+	pr.playlist.lock.Lock()
+	for _, event := range events {
+		event.Sample = pr.sample
+		pr.playlist.events = append(pr.playlist.events, event)
+	}
+	pr.playlist.playing = &entry
+	pr.playlist.lock.Unlock()
+
+	pr.prev = &entry
+	pr.fd = fd
+	pr.r = r
+	return nil
+}
+
+func (pr *playlistReader) Read(s sdr.Samples) (int, error) {
+	for {
+		if pr.r == nil {
+			if err := pr.next(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := pr.r.Read(s)
+		pr.sample += uint64(n)
+		if err == io.EOF {
+			if err := pr.closeCapture(); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+// closeCapture will close the capture currently being played back.
+func (pr *playlistReader) closeCapture() error {
+	if pr.fd == nil {
+		return nil
+	}
+	err := pr.fd.Close()
+	pr.fd = nil
+	pr.r = nil
+	return err
+}
+
+func (pr *playlistReader) Close() error {
+	pr.entries = nil
+	return pr.closeCapture()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,183 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func writeCapture(t *testing.T, path string, header rfcap.Header, samples sdr.Samples) {
+	fd, err := os.Create(path)
+	assert.NoError(t, err)
+	defer fd.Close()
+
+	w, err := rfcap.Writer(fd, header)
+	assert.NoError(t, err)
+	_, err = w.Write(samples)
+	assert.NoError(t, err)
+	assert.NoError(t, rfcap.Flush(w))
+}
+
+func TestPlaylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	when := time.Unix(1600000000, 0)
+	captures := []struct {
+		name    string
+		offset  time.Duration
+		freq    rf.Hz
+		samples sdr.SamplesU8
+	}{
+		{"c.rfcap", 3 * time.Second, 200 * rf.MHz, sdr.SamplesU8{{5, 5}, {5, 5}, {5, 5}, {5, 5}}},
+		{"a.rfcap", 0, 100 * rf.MHz, sdr.SamplesU8{{1, 1}, {1, 1}, {1, 1}, {1, 1}}},
+		{"b.rfcap", 2 * time.Second, 100 * rf.MHz, sdr.SamplesU8{{3, 3}, {3, 3}, {3, 3}, {3, 3}}},
+	}
+
+	paths := []string{}
+	for _, capture := range captures {
+		path := filepath.Join(dir, capture.name)
+		writeCapture(t, path, rfcap.Header{
+			Magic:           rfcap.MagicVersion1,
+			CaptureTime:     when.Add(capture.offset),
+			CenterFrequency: capture.freq,
+			SampleRate:      4,
+			SampleFormat:    sdr.SampleFormatU8,
+		}, capture.samples)
+		paths = append(paths, path)
+	}
+
+	playlist, err := rfcap.PlaylistSdr(paths...)
+	assert.NoError(t, err)
+	freq, err := playlist.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, freq)
+
+	rx, err := playlist.StartRx()
+	assert.NoError(t, err)
+	out := make(sdr.SamplesU8, 12)
+	n, err := sdr.ReadFull(rx, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, n)
+	assert.Equal(t, [2]uint8{1, 1}, out[0])
+	assert.Equal(t, [2]uint8{3, 3}, out[4])
+	assert.Equal(t, [2]uint8{5, 5}, out[8])
+	freq, err = playlist.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, 200*rf.MHz, freq)
+	_, err = rx.Read(out)
+	assert.Equal(t, io.EOF, err)
+	assert.NoError(t, rx.Close())
+
+	events := playlist.Events()
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, rfcap.EventCenterFrequency, events[0].Kind)
+	assert.Equal(t, rfcap.EventGap, events[1].Kind)
+	assert.Equal(t, uint64(4), events[1].Sample)
+	assert.Equal(t, uint64(4), events[1].Length)
+	assert.Equal(t, rfcap.EventCenterFrequency, events[2].Kind)
+	assert.Equal(t, uint64(8), events[2].Sample)
+	assert.Equal(t, 200*rf.MHz, events[2].CenterFrequency)
+
+	assert.Error(t, playlist.SetCenterFrequency(500*rf.MHz))
+	// Tuning anywhere within a capture plays it back as it was captured.
+	assert.NoError(t, playlist.SetCenterFrequency(200*rf.MHz+1))
+	freq, err = playlist.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, 200*rf.MHz, freq)
+
+	rx, err = playlist.StartRx()
+	assert.NoError(t, err)
+	n, err = sdr.ReadFull(rx, out[:4])
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, [2]uint8{5, 5}, out[0])
+	_, err = rx.Read(out)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPlaylistCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		when    = time.Unix(1600000000, 0)
+		samples = make(sdr.SamplesI16, 8)
+	)
+	for i, offset := range []time.Duration{0, 3 * time.Second} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.rfcap", i))
+		writeCapture(t, path, rfcap.Header{
+			Magic:        rfcap.MagicVersion1,
+			CaptureTime:  when.Add(offset),
+			SampleRate:   4,
+			SampleFormat: sdr.SampleFormatI16,
+			Endianness:   binary.LittleEndian,
+			Compressed:   true,
+		}, samples)
+	}
+
+	// Half of a packed block at the end can't be unpacked, so it mustn't
+	// count towards the length of the capture.
+	fd, err := os.OpenFile(filepath.Join(dir, "0.rfcap"), os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write(make([]byte, 6))
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	playlist, err := rfcap.PlaylistSdr(
+		filepath.Join(dir, "0.rfcap"),
+		filepath.Join(dir, "1.rfcap"),
+	)
+	assert.NoError(t, err)
+
+	rx, err := playlist.StartRx()
+	assert.NoError(t, err)
+	out := make(sdr.SamplesI16, 16)
+	n, err := sdr.ReadFull(rx, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, n)
+	_, err = rx.Read(out)
+	assert.Equal(t, io.EOF, err)
+	assert.NoError(t, rx.Close())
+
+	events := playlist.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, rfcap.EventGap, events[1].Kind)
+	assert.Equal(t, uint64(8), events[1].Sample)
+	assert.Equal(t, uint64(4), events[1].Length)
+}
+
+// vim: foldmethod=marker
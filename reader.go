@@ -44,7 +44,21 @@ func ReadHeader(in io.Reader) (Header, error) {
 	if err := header.Validate(); err != nil {
 		return Header{}, err
 	}
-	return header.asExportHeader(), nil
+
+	h := header.asExportHeader()
+	h.dataOffset = int64(Size) + int64(header.extensionLength())
+	if header.extensionLength() == 0 {
+		return h, nil
+	}
+
+	ext := make([]byte, header.extensionLength())
+	if _, err := io.ReadFull(in, ext); err != nil {
+		return Header{}, err
+	}
+	if err := h.setExtension(ext); err != nil {
+		return Header{}, err
+	}
+	return h, nil
 }
 
 // Reader will create a new sdr.Reader from the provided io stream.
@@ -54,19 +68,32 @@ func Reader(in io.Reader) (sdr.Reader, Header, error) {
 		return nil, h, err
 	}
 
-	sReader := sdr.ByteReader(in, h.Endianness, h.SampleRate, h.SampleFormat)
+	r, err := bodyReader(in, h)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	return r, h, nil
+}
+
+// bodyReader will create a new sdr.Reader from an io stream that has already
+// had its Header read off the front.
+func bodyReader(in io.Reader, h Header) (sdr.Reader, error) {
+	var (
+		sReader sdr.Reader = newByteReader(in, h.Endianness, h.SampleRate, h.SampleFormat)
+		err     error
+	)
 
 	if h.Compressed {
 		sReader, err = packer.DecompressReader(sReader)
 		if err != nil {
-			return nil, Header{}, err
+			return nil, err
 		}
 	}
 
 	return reader{
 		header: h,
 		r:      sReader,
-	}, h, nil
+	}, nil
 }
 
 func (r reader) SampleRate() uint {
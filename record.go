@@ -0,0 +1,418 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"hz.tools/rf"
+	"hz.tools/sdr"
+)
+
+// recordQueueLength is the number of buffers that can be waiting to be
+// written to disk before the Recorder starts to drop samples.
+const recordQueueLength = 64
+
+// Recorder is an sdr.Receiver that wraps a live sdr.Receiver, and will copy
+// every sample read out of a StartRx stream into an rfcap capture.
+//
+// Writes to the capture happen in the background. If they fall behind the
+// radio, samples will not be written, and the Recorder will instead write
+// silence in their place and record an EventGap, so the radio is never stalled
+// and sample indexes still line up with time.
+//
+// The Header is written when the first StartRx stream is started, so any
+// changes made before then end up in the Header. After that, retunes, sample
+// rate and gain changes that happen through the Recorder are recorded as
+// Events, in terms of the sample index into the capture, and written to
+// RecordOptions.Events if set.
+type Recorder struct {
+	sdr.Receiver
+
+	lock    sync.Mutex
+	out     io.Writer
+	opts    RecordOptions
+	header  Header
+	writer  sdr.Writer
+	queue   chan recordChunk
+	free    chan sdr.Samples
+	done    chan struct{}
+	closed  bool
+	err     error
+	sample  uint64
+	dropped uint64
+	events  []Event
+
+	// gap is the index into events of the EventGap for the samples that
+	// are being dropped right now, or -1 if none are.
+	gap int
+}
+
+// RecordOptions control what a Recorder writes alongside the capture.
+type RecordOptions struct {
+	// Events, if not nil, will have each Event written to it with
+	// WriteEvents, so they can be kept in a file alongside the capture.
+	// Events are written as they happen, except for an EventGap, which is
+	// written once the gap is over and its Length is known.
+	Events io.Writer
+
+	// Extension, if true, will write the device's HardwareInfo and gain
+	// settings into a header extension. This makes the capture version 2,
+	// which readers that only know about version 1 will refuse to read.
+	Extension bool
+}
+
+// recordChunk is a buffer of samples that are waiting to be written, along
+// with the number of samples that were dropped just before it. buf is the
+// whole buffer that samples was sliced out of, so it can be reused.
+type recordChunk struct {
+	gap     uint64
+	samples sdr.Samples
+	buf     sdr.Samples
+}
+
+// Record will wrap the provided sdr.Receiver, writing an rfcap Header built
+// from the device's configuration to the io.Writer once the first StartRx
+// stream is started, followed by all samples read from any StartRx stream.
+//
+// Closing the Recorder will wait for all pending writes, and Close the
+// underlying device.
+func Record(dev sdr.Receiver, out io.Writer) (*Recorder, error) {
+	return RecordWithOptions(dev, out, RecordOptions{})
+}
+
+// RecordWithOptions is like Record, but with control over what's written
+// alongside the capture.
+func RecordWithOptions(dev sdr.Receiver, out io.Writer, opts RecordOptions) (*Recorder, error) {
+	header, err := HeaderFromSDR(dev)
+	if err != nil {
+		return nil, err
+	}
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+
+	rec := &Recorder{
+		Receiver: dev,
+		out:      out,
+		opts:     opts,
+		header:   header,
+		queue:    make(chan recordChunk, recordQueueLength),
+		free:     make(chan sdr.Samples, recordQueueLength),
+		done:     make(chan struct{}),
+		gap:      -1,
+	}
+	go rec.run(header.SampleFormat)
+	return rec, nil
+}
+
+// Header will return the Header of the capture. Until the first StartRx
+// stream is started, this is the device's configuration when Record was
+// called.
+func (rec *Recorder) Header() Header {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.header
+}
+
+// start will write the Header, built from the device's current
+// configuration, if it hasn't been written yet. This must be called with the
+// lock held.
+func (rec *Recorder) start() error {
+	if rec.writer != nil {
+		return nil
+	}
+	header, err := HeaderFromSDR(rec.Receiver)
+	if err != nil {
+		return err
+	}
+	if rec.opts.Extension {
+		if header.Extension, err = HeaderExtensionFromSDR(rec.Receiver); err != nil {
+			return err
+		}
+	}
+	writer, err := Writer(rec.out, header)
+	if err != nil {
+		return err
+	}
+	rec.header, rec.writer = header, writer
+	return nil
+}
+
+// Events will return all Events that have happened so far.
+func (rec *Recorder) Events() []Event {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return append([]Event{}, rec.events...)
+}
+
+// run will write out everything sent to the queue until it's closed.
+func (rec *Recorder) run(format sdr.SampleFormat) {
+	defer close(rec.done)
+
+	zeros, err := makeSilence(format, 32*1024)
+	if err != nil {
+		rec.setErr(err)
+		return
+	}
+
+	for chunk := range rec.queue {
+		if rec.getErr() != nil {
+			continue
+		}
+
+		for gap := chunk.gap; gap > 0; {
+			n := uint64(zeros.Length())
+			if gap < n {
+				n = gap
+			}
+			if _, err := rec.writer.Write(zeros.Slice(0, int(n))); err != nil {
+				rec.setErr(err)
+				break
+			}
+			gap -= n
+		}
+
+		if chunk.samples == nil || rec.getErr() != nil {
+			continue
+		}
+		if _, err := rec.writer.Write(chunk.samples); err != nil {
+			rec.setErr(err)
+		}
+
+		select {
+		case rec.free <- chunk.buf:
+		default:
+		}
+	}
+
+	if rec.getErr() == nil && rec.writer != nil {
+		if err := Flush(rec.writer); err != nil {
+			rec.setErr(err)
+		}
+	}
+}
+
+func (rec *Recorder) setErr(err error) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err == nil {
+		rec.err = err
+	}
+}
+
+func (rec *Recorder) getErr() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.err
+}
+
+// event will record an Event at the current sample index, returning its
+// index into events. This must be called with the lock held.
+func (rec *Recorder) event(e Event) int {
+	e.Sample = rec.sample
+	e.Time = time.Now()
+	rec.events = append(rec.events, e)
+	if e.Kind != EventGap {
+		rec.logEvent(e)
+	}
+	return len(rec.events) - 1
+}
+
+// endGap will write out the EventGap for the samples that were being
+// dropped, now that they no longer are. This must be called with the lock
+// held.
+func (rec *Recorder) endGap() {
+	if rec.gap < 0 {
+		return
+	}
+	rec.logEvent(rec.events[rec.gap])
+	rec.gap = -1
+}
+
+// logEvent will write the Event to RecordOptions.Events, if set. This must be
+// called with the lock held.
+func (rec *Recorder) logEvent(e Event) {
+	if rec.opts.Events == nil {
+		return
+	}
+	if err := WriteEvents(rec.opts.Events, e); err != nil && rec.err == nil {
+		rec.err = err
+	}
+}
+
+// capture will queue a copy of the samples to be written, without blocking.
+func (rec *Recorder) capture(s sdr.Samples) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	if rec.closed {
+		return
+	}
+
+	// Reuse a buffer that's already been written out if there's one big
+	// enough, rather than allocating for every read.
+	var (
+		buf sdr.Samples
+		err error
+	)
+	select {
+	case buf = <-rec.free:
+	default:
+	}
+	if buf == nil || buf.Length() < s.Length() {
+		buf, err = sdr.MakeSamples(s.Format(), s.Length())
+	}
+	if err == nil {
+		_, err = sdr.CopySamples(buf, s)
+	}
+	if err != nil {
+		if rec.err == nil {
+			rec.err = err
+		}
+		return
+	}
+
+	select {
+	case rec.queue <- recordChunk{gap: rec.dropped, samples: buf.Slice(0, s.Length()), buf: buf}:
+		rec.dropped = 0
+		rec.endGap()
+	default:
+		if rec.gap < 0 {
+			rec.gap = rec.event(Event{Kind: EventGap})
+		}
+		rec.dropped += uint64(s.Length())
+		rec.events[rec.gap].Length = rec.dropped
+	}
+	rec.sample += uint64(s.Length())
+}
+
+// StartRx implements the sdr.Receiver interface.
+func (rec *Recorder) StartRx() (sdr.ReadCloser, error) {
+	rec.lock.Lock()
+	err := rec.start()
+	rec.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	rx, err := rec.Receiver.StartRx()
+	if err != nil {
+		return nil, err
+	}
+	if rx.SampleFormat() != rec.header.SampleFormat {
+		rx.Close()
+		return nil, sdr.ErrSampleFormatMismatch
+	}
+	return recordReader{ReadCloser: rx, rec: rec}, nil
+}
+
+type recordReader struct {
+	sdr.ReadCloser
+	rec *Recorder
+}
+
+func (rr recordReader) Read(s sdr.Samples) (int, error) {
+	n, err := rr.ReadCloser.Read(s)
+	if n > 0 {
+		rr.rec.capture(s.Slice(0, n))
+	}
+	return n, err
+}
+
+// set will make a change to the device, recording the Event if it worked.
+// Until recording starts, the change ends up in the Header instead.
+func (rec *Recorder) set(e Event, change func() error) error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if err := change(); err != nil {
+		return err
+	}
+	if rec.writer != nil {
+		rec.event(e)
+	}
+	return nil
+}
+
+// SetCenterFrequency implements the sdr.Sdr interface.
+func (rec *Recorder) SetCenterFrequency(freq rf.Hz) error {
+	return rec.set(Event{Kind: EventCenterFrequency, CenterFrequency: freq}, func() error {
+		return rec.Receiver.SetCenterFrequency(freq)
+	})
+}
+
+// SetSampleRate implements the sdr.Sdr interface.
+func (rec *Recorder) SetSampleRate(rate uint) error {
+	return rec.set(Event{Kind: EventSampleRate, SampleRate: rate}, func() error {
+		return rec.Receiver.SetSampleRate(rate)
+	})
+}
+
+// SetGain implements the sdr.Sdr interface.
+func (rec *Recorder) SetGain(stage sdr.GainStage, gain float32) error {
+	return rec.set(Event{Kind: EventGain, GainStage: stage.String(), Gain: gain}, func() error {
+		return rec.Receiver.SetGain(stage, gain)
+	})
+}
+
+// SetAutomaticGain implements the sdr.Sdr interface.
+func (rec *Recorder) SetAutomaticGain(automatic bool) error {
+	return rec.set(Event{Kind: EventAutomaticGain, AutomaticGain: automatic}, func() error {
+		return rec.Receiver.SetAutomaticGain(automatic)
+	})
+}
+
+// Close will wait for any pending writes to finish, and Close the
+// underlying device. Any error encountered while writing the capture will
+// be returned.
+func (rec *Recorder) Close() error {
+	rec.lock.Lock()
+	if rec.closed {
+		rec.lock.Unlock()
+		return rec.getErr()
+	}
+	rec.closed = true
+	dropped := rec.dropped
+	rec.endGap()
+	// Even if nothing was ever recorded, the capture needs a Header.
+	if err := rec.start(); err != nil && rec.err == nil {
+		rec.err = err
+	}
+	rec.lock.Unlock()
+
+	// capture will not queue anything once we're closed, so we're free to
+	// block here while the last of the gap is written out.
+	if dropped > 0 {
+		rec.queue <- recordChunk{gap: dropped}
+	}
+	close(rec.queue)
+
+	<-rec.done
+
+	if err := rec.Receiver.Close(); err != nil {
+		return err
+	}
+	return rec.getErr()
+}
+
+// vim: foldmethod=marker
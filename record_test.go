@@ -0,0 +1,276 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+	"hz.tools/sdr/mock"
+	"hz.tools/sdr/stream"
+)
+
+// gatedWriter will let the first Write through (the header), and block all
+// others until the gate is opened.
+type gatedWriter struct {
+	lock   sync.Mutex
+	buf    bytes.Buffer
+	gate   chan struct{}
+	writes int
+}
+
+func (gw *gatedWriter) Write(b []byte) (int, error) {
+	gw.lock.Lock()
+	gw.writes++
+	first := gw.writes == 1
+	gw.lock.Unlock()
+
+	if !first {
+		<-gw.gate
+	}
+
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return gw.buf.Write(b)
+}
+
+// noiseLNA is the only gain stage of noiseSdr.
+var noiseLNA = rfcap.GainSetting{
+	Name:      "LNA",
+	StageType: sdr.GainStageTypeRecieve,
+	Max:       40,
+}
+
+func noiseSdr() sdr.Receiver {
+	return mock.New(mock.Config{
+		CenterFrequency: rf.MustParseHz("100MHz"),
+		SampleRate:      1e6,
+		SampleFormat:    sdr.SampleFormatC64,
+		GainStages:      sdr.GainStages{noiseLNA},
+		Rx: func(sdr.Transceiver) (sdr.ReadCloser, error) {
+			noise := stream.Noise(stream.NoiseConfig{SampleRate: 1e6})
+			return sdr.ReaderWithCloser(noise, func() error { return nil }), nil
+		},
+	})
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	eventLog, err := os.Create(filepath.Join(dir, "capture.events"))
+	assert.NoError(t, err)
+	defer eventLog.Close()
+
+	out := &bytes.Buffer{}
+	rec, err := rfcap.RecordWithOptions(noiseSdr(), out, rfcap.RecordOptions{
+		Events: eventLog,
+	})
+	assert.NoError(t, err)
+
+	// Retuning before recording starts ends up in the Header.
+	assert.NoError(t, rec.SetCenterFrequency(rf.MustParseHz("101MHz")))
+
+	rx, err := rec.StartRx()
+	assert.NoError(t, err)
+
+	buf := make(sdr.SamplesC64, 1024)
+	_, err = sdr.ReadFull(rx, buf)
+	assert.NoError(t, err)
+
+	// Retuning once recording has started is recorded as an Event.
+	assert.NoError(t, rec.SetCenterFrequency(rf.MustParseHz("102MHz")))
+
+	// Read some more, so recycled buffers are used.
+	more := make(sdr.SamplesC64, 1024)
+	for i := 0; i < 4; i++ {
+		_, err = sdr.ReadFull(rx, more)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, rec.Close())
+
+	events := rec.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventCenterFrequency, events[0].Kind)
+	assert.Equal(t, uint64(1024), events[0].Sample)
+	assert.Equal(t, rf.MustParseHz("102MHz"), events[0].CenterFrequency)
+
+	freq, err := rec.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, rf.MustParseHz("102MHz"), freq)
+
+	// The retune can be read back out of the file next to the capture.
+	saved, err := os.Open(eventLog.Name())
+	assert.NoError(t, err)
+	defer saved.Close()
+	logged, err := rfcap.ReadEvents(saved)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(logged))
+	assert.Equal(t, rfcap.EventCenterFrequency, logged[0].Kind)
+	assert.Equal(t, uint64(1024), logged[0].Sample)
+	assert.Equal(t, rf.MustParseHz("102MHz"), logged[0].CenterFrequency)
+	assert.True(t, events[0].Time.Equal(logged[0].Time))
+
+	recorded, header, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	assert.Equal(t, rf.MustParseHz("101MHz"), header.CenterFrequency)
+	assert.Equal(t, header.CenterFrequency, rec.Header().CenterFrequency)
+
+	outBuf := make(sdr.SamplesC64, 1024)
+	_, err = sdr.ReadFull(recorded, outBuf)
+	assert.NoError(t, err)
+	assert.Equal(t, buf, outBuf)
+
+	outBuf = make(sdr.SamplesC64, 5*1024)
+	n, _ := sdr.ReadFull(recorded, outBuf)
+	assert.Equal(t, 4*1024, n)
+	assert.Equal(t, more, outBuf[3*1024:4*1024])
+}
+
+func TestRecordEmpty(t *testing.T) {
+	out := &bytes.Buffer{}
+	rec, err := rfcap.Record(noiseSdr(), out)
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Close())
+
+	_, header, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	assert.Equal(t, rf.MustParseHz("100MHz"), header.CenterFrequency)
+	assert.Equal(t, rfcap.MagicVersion1, header.Magic)
+	assert.Nil(t, header.Extension)
+}
+
+func TestRecordExtension(t *testing.T) {
+	dev := noiseSdr()
+	assert.NoError(t, dev.SetGain(noiseLNA, 30))
+
+	out := &bytes.Buffer{}
+	rec, err := rfcap.RecordWithOptions(dev, out, rfcap.RecordOptions{
+		Extension: true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Close())
+
+	_, header, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	assert.Equal(t, rfcap.MagicVersion2, header.Magic)
+	assert.Equal(t, "mocksdr", header.Extension.HardwareInfo.Product)
+	assert.Equal(t, 1, len(header.Extension.GainStages))
+	assert.Equal(t, float32(30), header.Extension.GainStages[0].Gain)
+}
+
+func TestRecordGap(t *testing.T) {
+	out := &gatedWriter{gate: make(chan struct{})}
+	rec, err := rfcap.Record(noiseSdr(), out)
+	assert.NoError(t, err)
+
+	rx, err := rec.StartRx()
+	assert.NoError(t, err)
+
+	var (
+		buf   = make(sdr.SamplesC64, 1024)
+		total = 0
+	)
+	for i := 0; i < 256; i++ {
+		n, err := sdr.ReadFull(rx, buf)
+		assert.NoError(t, err)
+		total += n
+	}
+	close(out.gate)
+	assert.NoError(t, rec.Close())
+
+	events := rec.Events()
+	assert.NotEqual(t, 0, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.NotEqual(t, uint64(0), events[0].Length)
+
+	recorded, _, err := rfcap.Reader(&out.buf)
+	assert.NoError(t, err)
+
+	all := make(sdr.SamplesC64, total)
+	n, err := sdr.ReadFull(recorded, all)
+	assert.NoError(t, err)
+	assert.Equal(t, total, n)
+	assert.Equal(t, complex64(0), all[events[0].Sample])
+}
+
+func TestRecordGapGain(t *testing.T) {
+	var (
+		out      = &gatedWriter{gate: make(chan struct{})}
+		eventLog = &bytes.Buffer{}
+	)
+	rec, err := rfcap.RecordWithOptions(noiseSdr(), out, rfcap.RecordOptions{
+		Events: eventLog,
+	})
+	assert.NoError(t, err)
+
+	rx, err := rec.StartRx()
+	assert.NoError(t, err)
+
+	// Fill the queue, so every read after this is dropped.
+	buf := make(sdr.SamplesC64, 1024)
+	for i := 0; i < 128; i++ {
+		_, err := sdr.ReadFull(rx, buf)
+		assert.NoError(t, err)
+	}
+	events := rec.Events()
+	assert.Equal(t, 1, len(events))
+	gapStart := events[0].Sample
+
+	// Change the gain in the middle of the gap, and keep dropping samples.
+	assert.NoError(t, rec.SetGain(noiseLNA, 20))
+	for i := 0; i < 16; i++ {
+		_, err := sdr.ReadFull(rx, buf)
+		assert.NoError(t, err)
+	}
+	close(out.gate)
+	assert.NoError(t, rec.Close())
+
+	events = rec.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.Equal(t, 128*1024+16*1024-gapStart, events[0].Length)
+	assert.Equal(t, rfcap.EventGain, events[1].Kind)
+	assert.Equal(t, "LNA", events[1].GainStage)
+	assert.Equal(t, float32(20), events[1].Gain)
+	assert.Equal(t, uint64(0), events[1].Length)
+
+	// The gain change is written as it happens, and the gap once it's over.
+	logged, err := rfcap.ReadEvents(eventLog)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(logged))
+	assert.Equal(t, rfcap.EventGain, logged[0].Kind)
+	assert.Equal(t, rfcap.EventGap, logged[1].Kind)
+	assert.Equal(t, events[0].Length, logged[1].Length)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,294 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RemoteOptions control how a RemoteCapture fetches data from the server.
+type RemoteOptions struct {
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// ReadAhead is the number of bytes fetched by each Range request, no
+	// matter how small the read that caused it. If zero, 1 MiB is used.
+	ReadAhead int64
+
+	// CacheBlocks is the number of ReadAhead sized blocks to hold on to.
+	// The least recently used block is evicted when the cache is full. If
+	// zero, 16 blocks are cached.
+	CacheBlocks int
+}
+
+// RemoteCapture is a capture hosted on a web server, which is fetched
+// lazily using HTTP Range requests. It implements io.Reader, io.Seeker and
+// io.ReaderAt, so it can be passed to Reader, Slice or anything else that
+// takes an io.Reader, and those that know how to seek will only fetch the
+// parts of the capture they need.
+type RemoteCapture struct {
+	url    string
+	client *http.Client
+	header Header
+	size   int64
+
+	blockSize int64
+	maxBlocks int
+
+	lock   sync.Mutex
+	offset int64
+	blocks []remoteBlock
+}
+
+// remoteBlock is a cached chunk of the remote file, starting at
+// index*blockSize.
+type remoteBlock struct {
+	index int64
+	data  []byte
+}
+
+// OpenURL will fetch and parse the Header of the capture at the provided
+// URL, returning a RemoteCapture positioned at the start of the file. The
+// server must support byte Range requests.
+func OpenURL(url string, opts RemoteOptions) (*RemoteCapture, error) {
+	rc := &RemoteCapture{
+		url:       url,
+		client:    opts.Client,
+		blockSize: opts.ReadAhead,
+		maxBlocks: opts.CacheBlocks,
+	}
+	if rc.client == nil {
+		rc.client = http.DefaultClient
+	}
+	if rc.blockSize <= 0 {
+		rc.blockSize = 1024 * 1024
+	}
+	if rc.maxBlocks <= 0 {
+		rc.maxBlocks = 16
+	}
+
+	// The first block will tell us how large the file is, as well as
+	// (almost always) contain the whole Header.
+	data, size, err := rc.fetch(0)
+	if err != nil {
+		return nil, err
+	}
+	rc.size = size
+	rc.blocks = append(rc.blocks, remoteBlock{index: 0, data: data})
+
+	if rc.header, err = ReadHeader(io.NewSectionReader(rc, 0, rc.size)); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Header will return the Header of the remote capture.
+func (rc *RemoteCapture) Header() Header {
+	return rc.header
+}
+
+// Size will return the length of the remote file in bytes, including the
+// Header.
+func (rc *RemoteCapture) Size() int64 {
+	return rc.size
+}
+
+// Close implements the io.Closer interface, and drops the cache.
+func (rc *RemoteCapture) Close() error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.blocks = nil
+	return nil
+}
+
+// fetch will request the block with the provided index from the server,
+// returning its contents along with the total size of the file.
+func (rc *RemoteCapture) fetch(index int64) ([]byte, int64, error) {
+	start := index * rc.blockSize
+	req, err := http.NewRequest(http.MethodGet, rc.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+rc.blockSize-1))
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return nil, 0, fmt.Errorf("rfcap: %s does not support Range requests", rc.url)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// This happens when the file is empty, or has shrunk.
+		return nil, 0, io.ErrUnexpectedEOF
+	default:
+		return nil, 0, fmt.Errorf("rfcap: fetching %s: %s", rc.url, resp.Status)
+	}
+
+	first, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if first != start {
+		return nil, 0, fmt.Errorf("rfcap: %s returned bytes from %d, not %d", rc.url, first, start)
+	}
+
+	data := make([]byte, rc.blockSize)
+	n, err := io.ReadFull(resp.Body, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, 0, err
+	}
+	return data[:n], size, nil
+}
+
+// parseContentRange will parse a "bytes first-last/size" Content-Range
+// header, returning the first byte and the size of the whole file.
+func parseContentRange(value string) (int64, int64, error) {
+	var (
+		rng       = strings.TrimPrefix(value, "bytes ")
+		slash     = strings.IndexByte(rng, '/')
+		dash      = strings.IndexByte(rng, '-')
+		malformed = fmt.Errorf("rfcap: malformed Content-Range %q", value)
+	)
+	if rng == value || slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, malformed
+	}
+
+	first, err := strconv.ParseInt(rng[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, malformed
+	}
+	size, err := strconv.ParseInt(rng[slash+1:], 10, 64)
+	if err != nil {
+		// This includes a "*" size, which we can't do anything with.
+		return 0, 0, malformed
+	}
+	return first, size, nil
+}
+
+// block will return the cached block with the provided index, fetching it
+// if needed. This must be called with the lock held.
+func (rc *RemoteCapture) block(index int64) ([]byte, error) {
+	for i, b := range rc.blocks {
+		if b.index != index {
+			continue
+		}
+		// Move it to the back, so the front is the least recently used.
+		copy(rc.blocks[i:], rc.blocks[i+1:])
+		rc.blocks[len(rc.blocks)-1] = b
+		return b.data, nil
+	}
+
+	data, _, err := rc.fetch(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.blocks) >= rc.maxBlocks {
+		rc.blocks = append(rc.blocks[:0], rc.blocks[1:]...)
+	}
+	rc.blocks = append(rc.blocks, remoteBlock{index: index, data: data})
+	return data, nil
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (rc *RemoteCapture) ReadAt(p []byte, off int64) (int, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.readAt(p, off)
+}
+
+// readAt will copy data at the provided offset out of the cache. This must
+// be called with the lock held.
+func (rc *RemoteCapture) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("rfcap: negative offset")
+	}
+
+	var total int
+	for total < len(p) {
+		if off >= rc.size {
+			return total, io.EOF
+		}
+		data, err := rc.block(off / rc.blockSize)
+		if err != nil {
+			return total, err
+		}
+		i := off % rc.blockSize
+		if i >= int64(len(data)) {
+			// The server gave us less than it said it had.
+			return total, io.ErrUnexpectedEOF
+		}
+		n := copy(p[total:], data[i:])
+		total += n
+		off += int64(n)
+	}
+	return total, nil
+}
+
+// Read implements the io.Reader interface.
+func (rc *RemoteCapture) Read(p []byte) (int, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.offset >= rc.size {
+		return 0, io.EOF
+	}
+	if remaining := rc.size - rc.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := rc.readAt(p, rc.offset)
+	rc.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (rc *RemoteCapture) Seek(offset int64, whence int) (int64, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rc.offset
+	case io.SeekEnd:
+		offset += rc.size
+	default:
+		return 0, fmt.Errorf("rfcap: invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("rfcap: negative position")
+	}
+	rc.offset = offset
+	return offset, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,161 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// remoteServer will serve a 10,000 sample i16 capture, counting the number
+// of requests made.
+func remoteServer(t *testing.T, ranges bool) (*httptest.Server, *int32) {
+	samples := make(sdr.SamplesI16, 10000)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i), int16(-i)}
+	}
+
+	buf := bytes.Buffer{}
+	w, err := rfcap.Writer(&buf, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Unix(1600000000, 0),
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.LittleEndian,
+	})
+	assert.NoError(t, err)
+	_, err = w.Write(samples)
+	assert.NoError(t, err)
+	data := buf.Bytes()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "capture.rfcap", time.Time{}, bytes.NewReader(data))
+	}))
+	return server, &requests
+}
+
+func TestRemoteRead(t *testing.T) {
+	server, requests := remoteServer(t, true)
+	defer server.Close()
+
+	rc, err := rfcap.OpenURL(server.URL, rfcap.RemoteOptions{ReadAhead: 4096})
+	assert.NoError(t, err)
+	defer rc.Close()
+	assert.Equal(t, int64(48+40000), rc.Size())
+	assert.Equal(t, 100*rf.MHz, rc.Header().CenterFrequency)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+
+	r, _, err := rfcap.Reader(rc)
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesI16, 10001)
+	n, _ := sdr.ReadFull(r, samples)
+	assert.Equal(t, 10000, n)
+	assert.Equal(t, [2]int16{9999, -9999}, samples[9999])
+
+	// 40048 bytes in 4096 byte blocks.
+	assert.Equal(t, int32(10), atomic.LoadInt32(requests))
+}
+
+func TestRemoteSlice(t *testing.T) {
+	server, requests := remoteServer(t, true)
+	defer server.Close()
+
+	rc, err := rfcap.OpenURL(server.URL, rfcap.RemoteOptions{ReadAhead: 1024})
+	assert.NoError(t, err)
+
+	out := bytes.Buffer{}
+	hdr, err := rfcap.Slice(rc, &out, rfcap.AtSample(8000), rfcap.AtSample(8010))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000008, 0).Unix(), hdr.CaptureTime.Unix())
+
+	r, _, err := rfcap.Reader(&out)
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesI16, 10)
+	_, err = sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]int16{8000, -8000}, samples[0])
+
+	// The first block, and the block holding sample 8000, rather than the
+	// whole file.
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
+
+func TestRemoteCache(t *testing.T) {
+	server, requests := remoteServer(t, true)
+	defer server.Close()
+
+	rc, err := rfcap.OpenURL(server.URL, rfcap.RemoteOptions{
+		ReadAhead:   1024,
+		CacheBlocks: 2,
+	})
+	assert.NoError(t, err)
+
+	buf := make([]byte, 4)
+	for _, off := range []int64{2048, 100, 2048, 100} {
+		_, err := rc.ReadAt(buf, off)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	// Block 3 evicts block 2, which was used least recently.
+	for _, off := range []int64{3072, 100, 2048} {
+		_, err := rc.ReadAt(buf, off)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests))
+
+	end, err := rc.Seek(-2, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, rc.Size()-2, end)
+	n, err := rc.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = rc.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRemoteNoRanges(t *testing.T) {
+	server, _ := remoteServer(t, false)
+	defer server.Close()
+
+	_, err := rfcap.OpenURL(server.URL, rfcap.RemoteOptions{})
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,334 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+)
+
+// ReplayOptions control how a capture is played back by a Replay.
+type ReplayOptions struct {
+	// Loop will start the capture over from the beginning once the end is
+	// reached, rather than returning io.EOF. This requires the io.Reader to
+	// also be an io.Seeker.
+	Loop bool
+
+	// Realtime will pace reads to the sample rate, as if the samples were
+	// coming off a live radio, rather than returning them as fast as they
+	// can be read.
+	Realtime bool
+}
+
+// Replay is an sdr.Receiver that plays back a capture as if it were a live
+// radio that can be tuned. Samples are always returned as complex64.
+//
+// SetCenterFrequency will accept any frequency within the bandwidth of the
+// capture, and digitally shift the samples so that frequency is at 0 Hz.
+// SetSampleRate will accept any rate that evenly divides the sample rate of
+// the capture, and low-pass filter and decimate the samples down to it.
+// Changes take effect on the next Read of the stream returned by StartRx.
+type Replay struct {
+	fakeSdr
+
+	in         io.Reader
+	opts       ReplayOptions
+	dataOffset int64
+
+	lock       sync.Mutex
+	offset     rf.Hz
+	decimation uint
+	generation int
+}
+
+// ReplaySdr will read the Header off the io.Reader, and return a Replay
+// tuned to the center frequency and sample rate of the capture.
+func ReplaySdr(in io.Reader, opts ReplayOptions) (*Replay, error) {
+	hdr, err := ReadHeader(in)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.SampleRate == 0 {
+		return nil, fmt.Errorf("rfcap: capture has no sample rate")
+	}
+
+	dataOffset, err := hdr.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	if _, seekable := in.(io.Seeker); opts.Loop && !seekable {
+		return nil, fmt.Errorf("rfcap: looping a replay requires an io.Seeker")
+	}
+
+	return &Replay{
+		fakeSdr:    fakeSdr{header: hdr},
+		in:         in,
+		opts:       opts,
+		dataOffset: dataOffset,
+		decimation: 1,
+	}, nil
+}
+
+// Header will return the Header of the capture being played back.
+func (p *Replay) Header() Header {
+	return p.header
+}
+
+// SampleFormat implements the sdr.Sdr interface.
+func (p *Replay) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatC64
+}
+
+// GetCenterFrequency implements the sdr.Sdr interface.
+func (p *Replay) GetCenterFrequency() (rf.Hz, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.header.CenterFrequency + p.offset, nil
+}
+
+// SetCenterFrequency implements the sdr.Sdr interface. Frequencies outside
+// the bandwidth of the capture will return an error.
+func (p *Replay) SetCenterFrequency(freq rf.Hz) error {
+	if !p.header.covers(freq) {
+		halfBandwidth := rf.Hz(p.header.SampleRate) / 2
+		return fmt.Errorf(
+			"rfcap: %s is outside of the capture (%s to %s)", freq,
+			p.header.CenterFrequency-halfBandwidth,
+			p.header.CenterFrequency+halfBandwidth,
+		)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.offset = freq - p.header.CenterFrequency
+	p.generation++
+	return nil
+}
+
+// GetSampleRate implements the sdr.Sdr interface.
+func (p *Replay) GetSampleRate() (uint, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.header.SampleRate / p.decimation, nil
+}
+
+// SetSampleRate implements the sdr.Sdr interface. Rates that don't evenly
+// divide the sample rate of the capture will return an error.
+func (p *Replay) SetSampleRate(rate uint) error {
+	if rate == 0 || rate > p.header.SampleRate || p.header.SampleRate%rate != 0 {
+		return fmt.Errorf(
+			"rfcap: sample rate %d does not evenly divide the capture's %d",
+			rate, p.header.SampleRate,
+		)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.decimation = p.header.SampleRate / rate
+	p.generation++
+	return nil
+}
+
+// Close implements the sdr.Sdr interface, and will Close the io.Reader if
+// it is also an io.Closer.
+func (p *Replay) Close() error {
+	if closer, ok := p.in.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// StartRx implements the sdr.Receiver interface. Only one stream should be
+// read from at a time, since they share the underlying io.Reader.
+func (p *Replay) StartRx() (sdr.ReadCloser, error) {
+	rr := &replayReader{
+		replay:     p,
+		generation: -1,
+		buf:        make(sdr.SamplesC64, 32*1024),
+	}
+	if err := rr.open(); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// replayReader is the sdr.Reader returned by Replay.StartRx, which keeps
+// its own mixer and filter, rebuilding them when the Replay is retuned.
+type replayReader struct {
+	replay *Replay
+	body   sdr.Reader
+	buf    sdr.SamplesC64
+
+	generation int
+	sampleRate uint
+	mixer      *dsp.Mixer
+	fir        *dsp.FIR
+
+	started time.Time
+	read    int64
+
+	// err is an error returned alongside samples, which is held on to
+	// until the samples have been returned.
+	err error
+}
+
+// open will start reading the body of the capture, seeking back to the
+// start of it if this isn't the first time through.
+func (rr *replayReader) open() error {
+	p := rr.replay
+	if rr.body != nil {
+		seeker, ok := p.in.(io.Seeker)
+		if !ok {
+			return io.EOF
+		}
+		if _, err := seeker.Seek(p.dataOffset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	body, err := bodyReader(p.in, p.header)
+	if err != nil {
+		return err
+	}
+	rr.body, err = newConvertReader(body, sdr.SampleFormatC64)
+	return err
+}
+
+// update will rebuild the mixer and filter if the Replay has been retuned
+// since the last Read.
+func (rr *replayReader) update() {
+	p := rr.replay
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if rr.generation == p.generation {
+		return
+	}
+	rr.generation = p.generation
+
+	rr.mixer = nil
+	if p.offset != 0 {
+		rr.mixer = dsp.NewMixer(-float64(p.offset), float64(p.header.SampleRate))
+	}
+
+	rr.fir = nil
+	if p.decimation > 1 {
+		d := int(p.decimation)
+		rr.fir = dsp.NewFIR(dsp.LowPass(0.4/float64(d), 32*d+1), d)
+	}
+
+	rr.sampleRate = p.header.SampleRate / p.decimation
+	rr.started = time.Now()
+	rr.read = 0
+}
+
+func (rr *replayReader) SampleRate() uint {
+	rr.update()
+	return rr.sampleRate
+}
+
+func (rr *replayReader) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatC64
+}
+
+func (rr *replayReader) Close() error {
+	return nil
+}
+
+func (rr *replayReader) Read(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesC64)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if len(iq) == 0 {
+		return 0, nil
+	}
+	if rr.err != nil {
+		return 0, rr.err
+	}
+
+	rr.update()
+	for {
+		n := len(rr.buf)
+		if rr.fir == nil {
+			if len(iq) < n {
+				n = len(iq)
+			}
+		} else {
+			for n > 1 && rr.fir.OutputLength(n) > len(iq) {
+				n /= 2
+			}
+		}
+
+		i, err := rr.body.Read(rr.buf[:n])
+		if rr.mixer != nil {
+			rr.mixer.Mix(rr.buf[:i])
+		}
+
+		out := i
+		if rr.fir == nil {
+			copy(iq, rr.buf[:i])
+		} else {
+			out = rr.fir.Filter(iq, rr.buf[:i])
+		}
+
+		if err == io.EOF && rr.replay.opts.Loop {
+			err = rr.open()
+		}
+		if out > 0 {
+			// Any error is returned on the next Read, once these
+			// samples have been dealt with.
+			rr.err = err
+			rr.pace(out)
+			return out, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// pace will sleep until the samples just read would have come off a live
+// radio, if the Replay is Realtime.
+func (rr *replayReader) pace(n int) {
+	if !rr.replay.opts.Realtime {
+		return
+	}
+	rr.read += int64(n)
+	var (
+		rate = int64(rr.sampleRate)
+		due  = rr.started.Add(
+			time.Duration(rr.read/rate)*time.Second +
+				time.Duration(rr.read%rate)*time.Second/time.Duration(rate),
+		)
+	)
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,148 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func readReplay(t *testing.T, rx sdr.Reader, length int) sdr.SamplesC64 {
+	samples := sdr.SamplesC64{}
+	buf := make(sdr.SamplesC64, 256)
+	for len(samples) < length {
+		chunk := buf
+		if remaining := length - len(samples); remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		n, err := rx.Read(chunk)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+	}
+	return samples
+}
+
+// brokenReader is a bytes.Reader that fails once with err, along with the
+// last of its bytes.
+type brokenReader struct {
+	*bytes.Reader
+	err error
+}
+
+func (br *brokenReader) Read(b []byte) (int, error) {
+	n, err := br.Reader.Read(b)
+	if n > 0 && br.Len() == 0 {
+		err = br.err
+	}
+	return n, err
+}
+
+func TestReplayError(t *testing.T) {
+	broken := errors.New("broken")
+	replay, err := rfcap.ReplaySdr(&brokenReader{
+		Reader: bytes.NewReader(transformCapture(t, 10, 1000).Bytes()),
+		err:    broken,
+	}, rfcap.ReplayOptions{})
+	assert.NoError(t, err)
+
+	rx, err := replay.StartRx()
+	assert.NoError(t, err)
+
+	samples := make(sdr.SamplesC64, 2000)
+	n, err := rx.Read(samples)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, n)
+
+	// The error that came along with the samples isn't lost.
+	n, err = rx.Read(samples)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, broken, err)
+}
+
+func TestReplayTune(t *testing.T) {
+	replay, err := rfcap.ReplaySdr(transformCapture(t, 100, 1000), rfcap.ReplayOptions{})
+	assert.NoError(t, err)
+	defer replay.Close()
+
+	assert.Error(t, replay.SetCenterFrequency(100*rf.MHz+600))
+	assert.NoError(t, replay.SetCenterFrequency(100*rf.MHz+100))
+	freq, err := replay.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz+100, freq)
+
+	rx, err := replay.StartRx()
+	assert.NoError(t, err)
+	samples := readReplay(t, rx, 2000)
+	assert.Equal(t, 1000, len(samples))
+
+	// The tone is now at DC, so the phase shouldn't move.
+	for _, i := range []int{100, 500, 999} {
+		assert.InDelta(t, 0, cmplx.Abs(complex128(samples[i]-samples[0])), 0.01)
+	}
+}
+
+func TestReplaySampleRate(t *testing.T) {
+	replay, err := rfcap.ReplaySdr(transformCapture(t, 10, 1000), rfcap.ReplayOptions{})
+	assert.NoError(t, err)
+
+	assert.Error(t, replay.SetSampleRate(300))
+	assert.Error(t, replay.SetSampleRate(2000))
+	assert.NoError(t, replay.SetSampleRate(250))
+
+	rx, err := replay.StartRx()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(250), rx.SampleRate())
+
+	samples := readReplay(t, rx, 1000)
+	assert.InDelta(t, 250, len(samples), 1)
+	assert.InDelta(t, 0.25, meanPower(samples[100:200]), 0.01)
+}
+
+func TestReplayLoop(t *testing.T) {
+	buf := transformCapture(t, 10, 1000)
+
+	_, err := rfcap.ReplaySdr(buf, rfcap.ReplayOptions{Loop: true})
+	assert.Error(t, err)
+
+	replay, err := rfcap.ReplaySdr(bytes.NewReader(transformCapture(t, 10, 1000).Bytes()), rfcap.ReplayOptions{Loop: true})
+	assert.NoError(t, err)
+
+	rx, err := replay.StartRx()
+	assert.NoError(t, err)
+	samples := readReplay(t, rx, 2500)
+	assert.Equal(t, 2500, len(samples))
+	assert.Equal(t, samples[10], samples[1010])
+	assert.Equal(t, samples[10], samples[2010])
+}
+
+// vim: foldmethod=marker
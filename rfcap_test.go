@@ -21,7 +21,9 @@
 package rfcap_test
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -52,6 +54,36 @@ func TestHeaderMarshalUnmarshal(t *testing.T) {
 	assert.Equal(t, 1337*rf.MHz, hdr.CenterFrequency)
 }
 
+func TestHeaderJSON(t *testing.T) {
+	hdr := rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Unix(1600000000, 0).UTC(),
+		CenterFrequency: rf.MustParseHz("1337MHz"),
+		SampleRate:      1.8e+8,
+		SampleFormat:    sdr.SampleFormatI16,
+		Compressed:      true,
+		Endianness:      binary.BigEndian,
+	}
+	buf, err := json.Marshal(hdr)
+	assert.NoError(t, err)
+
+	out := rfcap.Header{}
+	assert.NoError(t, json.Unmarshal(buf, &out))
+	assert.Equal(t, hdr, out)
+}
+
+func TestHeaderSampleCount(t *testing.T) {
+	hdr := rfcap.Header{SampleFormat: sdr.SampleFormatC64}
+	assert.Equal(t, int64(10), hdr.SampleCount(84))
+
+	hdr = rfcap.Header{SampleFormat: sdr.SampleFormatI16, Compressed: true}
+	assert.Equal(t, int64(4), hdr.SampleCount(12))
+	assert.Equal(t, int64(4), hdr.SampleCount(18))
+
+	hdr = rfcap.Header{SampleRate: 100}
+	assert.Equal(t, 500*time.Millisecond, hdr.Duration(50))
+}
+
 func TestHeaderFromSdr(t *testing.T) {
 	mockSdr := mock.New(mock.Config{})
 
@@ -65,6 +97,103 @@ func TestHeaderFromSdr(t *testing.T) {
 	assert.Equal(t, header.SampleRate, uint(10e6))
 }
 
+func TestHeaderFromSdrGain(t *testing.T) {
+	lna := rfcap.GainSetting{
+		Name:      "LNA",
+		StageType: sdr.GainStageTypeRecieve | sdr.GainStageTypeFE,
+		Min:       0,
+		Max:       40,
+	}
+	mockSdr := mock.New(mock.Config{
+		SampleRate:   10e6,
+		SampleFormat: sdr.SampleFormatU8,
+		GainStages:   sdr.GainStages{lna},
+	})
+	assert.NoError(t, mockSdr.SetGain(lna, 32))
+
+	// The extension is opt-in, so captures stay readable as version 1.
+	header, err := rfcap.HeaderFromSDR(mockSdr)
+	assert.NoError(t, err)
+	assert.Nil(t, header.Extension)
+	buf, err := header.Marshal()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("RFCAP1"), buf[:6])
+
+	header.Extension, err = rfcap.HeaderExtensionFromSDR(mockSdr)
+	assert.NoError(t, err)
+	assert.Equal(t, "mocksdr", header.Extension.HardwareInfo.Product)
+	assert.Equal(t, 1, len(header.Extension.GainStages))
+	assert.Equal(t, float32(32), header.Extension.GainStages[0].Gain)
+
+	buf, err = header.Marshal()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("RFCAP2"), buf[:6])
+	assert.Equal(t, 0, len(buf)%16)
+
+	fakeSdr, err := rfcap.ReaderSdr(bytes.NewReader(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, mockSdr.HardwareInfo(), fakeSdr.HardwareInfo())
+
+	gainStages, err := fakeSdr.GetGainStages()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(gainStages))
+	assert.Equal(t, "LNA", gainStages[0].String())
+	assert.Equal(t, [2]float32{0, 40}, gainStages[0].Range())
+
+	gain, err := fakeSdr.GetGain(lna)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(32), gain)
+}
+
+func TestHeaderVersion(t *testing.T) {
+	hdr := rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Unix(1600000000, 0),
+		CenterFrequency: rf.MustParseHz("1337MHz"),
+		SampleRate:      1.8e+8,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.LittleEndian,
+	}
+	buf, err := hdr.Marshal()
+	assert.NoError(t, err)
+	assert.Equal(t, rfcap.Size, len(buf))
+	assert.Equal(t, []byte("RFCAP1"), buf[:6])
+
+	// Old readers treated these bytes as reserved, so they have to be
+	// ignored in a version 1 header.
+	copy(buf[28:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	out, err := rfcap.ReadHeader(bytes.NewReader(buf))
+	assert.NoError(t, err)
+	offset, err := out.DataOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rfcap.Size), offset)
+	assert.True(t, hdr.Equal(out))
+
+	// Headers are comparable, so they can be used with == and as map keys.
+	seen := map[rfcap.Header]bool{hdr: true}
+	assert.True(t, seen[hdr])
+
+	hdr.Extension = &rfcap.HeaderExtension{
+		GainStages: []rfcap.GainSetting{{Name: "LNA", Max: 40, Gain: 20}},
+	}
+	buf, err = hdr.Marshal()
+	assert.NoError(t, err)
+	assert.True(t, len(buf) > rfcap.Size)
+	assert.Equal(t, []byte("RFCAP2"), buf[:6])
+
+	out, err = rfcap.ReadHeader(bytes.NewReader(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, rfcap.MagicVersion2, out.Magic)
+	offset, err = out.DataOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(buf)), offset)
+	assert.Equal(t, hdr.Extension.GainStages, out.Extension.GainStages)
+	assert.False(t, hdr.Equal(out))
+
+	hdr.Magic = rfcap.MagicVersion2
+	assert.True(t, hdr.Equal(out))
+}
+
 func TestRfcapEndianO(t *testing.T) {
 	fd, err := ioutil.TempFile("", "go-rf-rfcap_test")
 	if err != nil {
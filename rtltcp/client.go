@@ -0,0 +1,328 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rtltcp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+
+	"hz.tools/rf"
+	"hz.tools/sdr"
+)
+
+// tunerGain is the one sdr.GainStage an rtl_tcp Client can control.
+type tunerGain struct {
+	gains []int
+}
+
+// Type implements the sdr.GainStage interface.
+func (tg tunerGain) Type() sdr.GainStageType {
+	return sdr.GainStageTypeBB | sdr.GainStageTypeRecieve
+}
+
+// Range implements the sdr.GainStage interface.
+func (tg tunerGain) Range() [2]float32 {
+	return [2]float32{
+		float32(tg.gains[0]) / 10,
+		float32(tg.gains[len(tg.gains)-1]) / 10,
+	}
+}
+
+// String implements the sdr.GainStage interface.
+func (tg tunerGain) String() string {
+	return "Tuner"
+}
+
+// nearest will return the supported gain closest to the requested gain, in
+// tenths of a dB.
+func (tg tunerGain) nearest(gain float32) int {
+	var (
+		want = int(math.Round(float64(gain) * 10))
+		best = tg.gains[0]
+	)
+	for _, g := range tg.gains {
+		if abs(want-g) < abs(want-best) {
+			best = g
+		}
+	}
+	return best
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// Client is an sdr.Receiver that talks to a remote rtl_tcp server. Calls to
+// SetCenterFrequency, SetSampleRate, SetGain and SetAutomaticGain are sent
+// to the server as Requests, and StartRx will return the u8 samples sent
+// back.
+//
+// The rtl_tcp protocol has no way to ask the server how it's configured, so
+// the Get methods return whatever was last set through the Client, and an
+// error if nothing has been set yet. Set the center frequency and sample rate
+// before calling rfcap.HeaderFromSDR.
+type Client struct {
+	conn       net.Conn
+	dongleInfo DongleInfo
+	gain       tunerGain
+
+	lock            sync.Mutex
+	centerFrequency rf.Hz
+	sampleRate      uint
+	tunerGain       *float32
+	automaticGain   bool
+}
+
+// Dial will connect to the rtl_tcp server at the address.
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient will read the DongleInfo from an already connected rtl_tcp
+// server, and return a Client that talks to it.
+func NewClient(conn net.Conn) (*Client, error) {
+	di, err := ReadDongleInfo(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:       conn,
+		dongleInfo: di,
+		gain:       tunerGain{gains: di.TunerType.Gains()},
+	}, nil
+}
+
+// DongleInfo will return the DongleInfo sent by the server.
+func (c *Client) DongleInfo() DongleInfo {
+	return c.dongleInfo
+}
+
+// send will write a Request to the server.
+func (c *Client) send(cmd Command, arg uint32) error {
+	return Request{Command: cmd, Argument: arg}.Write(c.conn)
+}
+
+// Close implements the sdr.Sdr interface, and hangs up on the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// HardwareInfo implements the sdr.Sdr interface. rtl_tcp doesn't send the
+// serial number of the dongle, so only the tuner type is known.
+func (c *Client) HardwareInfo() sdr.HardwareInfo {
+	return sdr.HardwareInfo{
+		Product: fmt.Sprintf("rtl_tcp (%s)", c.dongleInfo.TunerType),
+	}
+}
+
+// SampleFormat implements the sdr.Sdr interface. rtl_tcp always sends u8
+// samples.
+func (c *Client) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatU8
+}
+
+// SetCenterFrequency implements the sdr.Sdr interface.
+func (c *Client) SetCenterFrequency(freq rf.Hz) error {
+	if freq < 0 || freq > math.MaxUint32 {
+		return fmt.Errorf("rtltcp: %s can't be sent to the server", freq)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.send(CommandSetFreq, uint32(freq)); err != nil {
+		return err
+	}
+	c.centerFrequency = freq
+	return nil
+}
+
+// GetCenterFrequency implements the sdr.Sdr interface.
+func (c *Client) GetCenterFrequency() (rf.Hz, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.centerFrequency == 0 {
+		return 0, fmt.Errorf("rtltcp: center frequency has not been set")
+	}
+	return c.centerFrequency, nil
+}
+
+// SetSampleRate implements the sdr.Sdr interface.
+func (c *Client) SetSampleRate(rate uint) error {
+	if rate == 0 || rate > math.MaxUint32 {
+		return fmt.Errorf("rtltcp: sample rate %d can't be sent to the server", rate)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.send(CommandSetSampleRate, uint32(rate)); err != nil {
+		return err
+	}
+	c.sampleRate = rate
+	return nil
+}
+
+// GetSampleRate implements the sdr.Sdr interface.
+func (c *Client) GetSampleRate() (uint, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.sampleRate == 0 {
+		return 0, fmt.Errorf("rtltcp: sample rate has not been set")
+	}
+	return c.sampleRate, nil
+}
+
+// GetGainStages implements the sdr.Sdr interface. There's only ever the
+// one "Tuner" stage.
+func (c *Client) GetGainStages() (sdr.GainStages, error) {
+	return sdr.GainStages{c.gain}, nil
+}
+
+// SetGain implements the sdr.Sdr interface. The gain is rounded to the
+// nearest one supported by the tuner, and switches the tuner to manual gain.
+func (c *Client) SetGain(stage sdr.GainStage, gain float32) error {
+	if stage.String() != c.gain.String() {
+		return sdr.ErrNotSupported
+	}
+	tenths := c.gain.nearest(gain)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.send(CommandSetGainMode, 1); err != nil {
+		return err
+	}
+	if err := c.send(CommandSetGain, uint32(int32(tenths))); err != nil {
+		return err
+	}
+	g := float32(tenths) / 10
+	c.tunerGain = &g
+	c.automaticGain = false
+	return nil
+}
+
+// GetGain implements the sdr.Sdr interface.
+func (c *Client) GetGain(stage sdr.GainStage) (float32, error) {
+	if stage.String() != c.gain.String() {
+		return 0, sdr.ErrNotSupported
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tunerGain == nil || c.automaticGain {
+		return 0, fmt.Errorf("rtltcp: gain has not been set")
+	}
+	return *c.tunerGain, nil
+}
+
+// SetAutomaticGain implements the sdr.Sdr interface.
+func (c *Client) SetAutomaticGain(automatic bool) error {
+	var manual uint32 = 1
+	if automatic {
+		manual = 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.send(CommandSetGainMode, manual); err != nil {
+		return err
+	}
+	c.automaticGain = automatic
+	return nil
+}
+
+// GetAutomaticGain will return true if automatic gain was last turned on
+// through the Client.
+func (c *Client) GetAutomaticGain() (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.automaticGain, nil
+}
+
+// StartRx implements the sdr.Receiver interface. Since rtl_tcp has no way
+// to stop the stream, Closing the returned sdr.ReadCloser will Close the
+// Client.
+func (c *Client) StartRx() (sdr.ReadCloser, error) {
+	return &clientReader{client: c}, nil
+}
+
+// clientReader is the sdr.ReadCloser of u8 samples sent by the server,
+// which holds on to half a sample if the server sends one.
+type clientReader struct {
+	client *Client
+	carry  []byte
+}
+
+func (cr *clientReader) SampleRate() uint {
+	rate, _ := cr.client.GetSampleRate()
+	return rate
+}
+
+func (cr *clientReader) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatU8
+}
+
+func (cr *clientReader) Close() error {
+	return cr.client.Close()
+}
+
+func (cr *clientReader) Read(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesU8)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if len(iq) == 0 {
+		return 0, nil
+	}
+
+	buf, err := sdr.UnsafeSamplesAsBytes(iq)
+	if err != nil {
+		return 0, err
+	}
+
+	total := copy(buf, cr.carry)
+	for total < 2 {
+		var n int
+		n, err = cr.client.conn.Read(buf[total:])
+		total += n
+		if err != nil {
+			break
+		}
+	}
+
+	whole := total / 2
+	cr.carry = append(cr.carry[:0], buf[whole*2:total]...)
+	if whole > 0 && err == io.EOF {
+		err = nil
+	}
+	return whole, err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,155 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rtltcp_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/rtltcp"
+	"hz.tools/sdr"
+)
+
+// fakeServer will accept one client, send the DongleInfo and body, and
+// pass along every Request the client sends.
+func fakeServer(t *testing.T, body []byte) (net.Listener, chan rtltcp.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	requests := make(chan rtltcp.Request, 16)
+	go func() {
+		defer close(requests)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rtltcp.DefaultDongleInfo.Write(conn)
+		// Send half a sample first, to make sure it's held on to.
+		conn.Write(body[:1])
+		conn.Write(body[1:])
+
+		for {
+			req, err := rtltcp.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			requests <- req
+		}
+	}()
+	return l, requests
+}
+
+func TestClientRequests(t *testing.T) {
+	l, requests := fakeServer(t, []byte{1, 2, 3, 4})
+	defer l.Close()
+
+	client, err := rtltcp.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	assert.Equal(t, rtltcp.TunerR820T, client.DongleInfo().TunerType)
+	assert.Equal(t, "", client.HardwareInfo().Serial)
+
+	_, err = rfcap.HeaderFromSDR(client)
+	assert.Error(t, err)
+
+	assert.NoError(t, client.SetCenterFrequency(100*rf.MHz))
+	assert.NoError(t, client.SetSampleRate(2048000))
+	stages, err := client.GetGainStages()
+	assert.NoError(t, err)
+	assert.NoError(t, client.SetGain(stages[0], 30))
+	assert.NoError(t, client.SetAutomaticGain(true))
+
+	hdr, err := rfcap.HeaderFromSDR(client)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, hdr.CenterFrequency)
+	assert.Equal(t, uint(2048000), hdr.SampleRate)
+	assert.Equal(t, sdr.SampleFormatU8, hdr.SampleFormat)
+
+	ext, err := rfcap.HeaderExtensionFromSDR(client)
+	assert.NoError(t, err)
+	assert.True(t, ext.AutomaticGain)
+
+	rx, err := client.StartRx()
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesU8, 2)
+	n, err := sdr.ReadFull(rx, samples)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, sdr.SamplesU8{{1, 2}, {3, 4}}, samples)
+	assert.NoError(t, rx.Close())
+
+	want := []rtltcp.Request{
+		{Command: rtltcp.CommandSetFreq, Argument: 100000000},
+		{Command: rtltcp.CommandSetSampleRate, Argument: 2048000},
+		{Command: rtltcp.CommandSetGainMode, Argument: 1},
+		{Command: rtltcp.CommandSetGain, Argument: 297},
+		{Command: rtltcp.CommandSetGainMode, Argument: 0},
+	}
+	got := []rtltcp.Request{}
+	for req := range requests {
+		got = append(got, req)
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestClientRecord(t *testing.T) {
+	server, l := replayServer(t, rfcap.ReplayOptions{Loop: true})
+	defer l.Close()
+	go server.Serve(l)
+
+	client, err := rtltcp.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	assert.NoError(t, client.SetCenterFrequency(100*rf.MHz))
+	assert.NoError(t, client.SetSampleRate(1000))
+
+	hdr, err := rfcap.HeaderFromSDR(client)
+	assert.NoError(t, err)
+
+	out := bytes.Buffer{}
+	w, err := rfcap.Writer(&out, hdr)
+	assert.NoError(t, err)
+
+	rx, err := client.StartRx()
+	assert.NoError(t, err)
+	defer rx.Close()
+
+	buf := make(sdr.SamplesU8, 250)
+	n, err := sdr.ReadFull(rx, buf)
+	assert.NoError(t, err)
+	_, err = w.Write(buf[:n])
+	assert.NoError(t, err)
+
+	r, got, err := rfcap.Reader(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz, got.CenterFrequency)
+	samples := make(sdr.SamplesU8, 250)
+	_, err = sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.InDelta(t, 191, samples[200][0], 1)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,237 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rtltcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Magic is the first four bytes sent by an rtl_tcp server.
+var Magic = [4]byte{'R', 'T', 'L', '0'}
+
+// TunerType is the type of tuner that the server reports the dongle has.
+type TunerType uint32
+
+// Tuners known to librtlsdr.
+const (
+	TunerUnknown TunerType = 0
+	TunerE4000   TunerType = 1
+	TunerFC0012  TunerType = 2
+	TunerFC0013  TunerType = 3
+	TunerFC2580  TunerType = 4
+	TunerR820T   TunerType = 5
+	TunerR828D   TunerType = 6
+)
+
+func (t TunerType) String() string {
+	switch t {
+	case TunerE4000:
+		return "E4000"
+	case TunerFC0012:
+		return "FC0012"
+	case TunerFC0013:
+		return "FC0013"
+	case TunerFC2580:
+		return "FC2580"
+	case TunerR820T:
+		return "R820T"
+	case TunerR828D:
+		return "R828D"
+	default:
+		return "Unknown"
+	}
+}
+
+// Gains will return the gains the tuner supports, in tenths of a dB, lowest
+// first. This is the same table librtlsdr has, which rtl_tcp has no way to
+// send to the client.
+func (t TunerType) Gains() []int {
+	switch t {
+	case TunerE4000:
+		return []int{-10, 15, 40, 65, 90, 115, 140, 165, 190, 215, 240, 290,
+			340, 420}
+	case TunerFC0012:
+		return []int{-99, -40, 71, 179, 192}
+	case TunerFC0013:
+		return []int{-99, -73, -65, -63, -60, -58, -54, 58, 61, 63, 65, 67, 68,
+			70, 71, 179, 181, 182, 184, 186, 188, 191, 197}
+	case TunerR820T, TunerR828D:
+		return []int{0, 9, 14, 27, 37, 77, 87, 125, 144, 157, 166, 197, 207,
+			229, 254, 280, 297, 328, 338, 364, 372, 386, 402, 421, 434, 439,
+			445, 480, 496}
+	default:
+		return []int{0}
+	}
+}
+
+// DongleInfo is sent by the server as soon as a client connects.
+type DongleInfo struct {
+	Magic          [4]byte
+	TunerType      TunerType
+	TunerGainCount uint32
+}
+
+// DefaultDongleInfo is sent by a Server that has no DongleInfo set. Most
+// clients know the gain table of an R820T, so this is the safest thing to
+// claim to be.
+var DefaultDongleInfo = DongleInfo{
+	Magic:          Magic,
+	TunerType:      TunerR820T,
+	TunerGainCount: 29,
+}
+
+// ReadDongleInfo will read and check the DongleInfo sent by a server.
+func ReadDongleInfo(r io.Reader) (DongleInfo, error) {
+	var di DongleInfo
+	if err := binary.Read(r, binary.BigEndian, &di); err != nil {
+		return DongleInfo{}, err
+	}
+	if di.Magic != Magic {
+		return DongleInfo{}, fmt.Errorf("rtltcp: magic is not RTL0")
+	}
+	return di, nil
+}
+
+// Write will send the DongleInfo to a client.
+func (di DongleInfo) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, di)
+}
+
+func (di DongleInfo) String() string {
+	return fmt.Sprintf("magic=%s tunerType=%d", di.Magic[:], di.TunerType)
+}
+
+// Command is the type of Request sent by a client.
+type Command uint8
+
+const (
+	// CommandSetFreq requests the server tune to the frequency in Hz.
+	CommandSetFreq Command = 0x01
+
+	// CommandSetSampleRate requests the server configure the number of
+	// samples per second.
+	CommandSetSampleRate Command = 0x02
+
+	// CommandSetGainMode requests manual tuner gain if the Argument is 1,
+	// or automatic tuner gain if it's 0.
+	CommandSetGainMode Command = 0x03
+
+	// CommandSetGain requests the server set the tuner gain, in tenths of
+	// a dB.
+	CommandSetGain Command = 0x04
+
+	// CommandSetFreqCorrection requests the server set a frequency
+	// correction, in ppm.
+	CommandSetFreqCorrection Command = 0x05
+
+	// CommandSetIFGain requests the server set the IF gain.
+	CommandSetIFGain Command = 0x06
+
+	// CommandSetTestMode requests the server set test mode.
+	CommandSetTestMode Command = 0x07
+
+	// CommandSetAGCMode will control the Automatic Gain Correction digital
+	// stage of the RTL2832, which is very different from
+	// CommandSetGainMode.
+	CommandSetAGCMode Command = 0x08
+
+	// CommandSetDirectSampling will set direct sampling.
+	CommandSetDirectSampling Command = 0x09
+
+	// CommandSetOffsetTuning will set offset tuning.
+	CommandSetOffsetTuning Command = 0x0a
+
+	// CommandSetRtlXtalFreq will set the xtal frequency.
+	CommandSetRtlXtalFreq Command = 0x0b
+
+	// CommandSetTunerXtalFreq will set the tuner xtal frequency.
+	CommandSetTunerXtalFreq Command = 0x0c
+
+	// CommandSetTunerGainByIndex will set the tuner gain to an entry in
+	// the tuner's gain table.
+	CommandSetTunerGainByIndex Command = 0x0d
+
+	// CommandSetBiasTee will set the bias tee state.
+	CommandSetBiasTee Command = 0x0e
+)
+
+func (c Command) String() string {
+	switch c {
+	case CommandSetFreq:
+		return "CommandSetFreq"
+	case CommandSetSampleRate:
+		return "CommandSetSampleRate"
+	case CommandSetGainMode:
+		return "CommandSetGainMode"
+	case CommandSetGain:
+		return "CommandSetGain"
+	case CommandSetFreqCorrection:
+		return "CommandSetFreqCorrection"
+	case CommandSetIFGain:
+		return "CommandSetIFGain"
+	case CommandSetTestMode:
+		return "CommandSetTestMode"
+	case CommandSetAGCMode:
+		return "CommandSetAGCMode"
+	case CommandSetDirectSampling:
+		return "CommandSetDirectSampling"
+	case CommandSetOffsetTuning:
+		return "CommandSetOffsetTuning"
+	case CommandSetRtlXtalFreq:
+		return "CommandSetRtlXtalFreq"
+	case CommandSetTunerXtalFreq:
+		return "CommandSetTunerXtalFreq"
+	case CommandSetTunerGainByIndex:
+		return "CommandSetTunerGainByIndex"
+	case CommandSetBiasTee:
+		return "CommandSetBiasTee"
+	default:
+		return fmt.Sprintf("Command(%d)", uint8(c))
+	}
+}
+
+// Request is a 5 byte command packet sent by a client.
+type Request struct {
+	Command  Command
+	Argument uint32
+}
+
+// ReadRequest will read the next Request sent by a client.
+func ReadRequest(r io.Reader) (Request, error) {
+	var req Request
+	if err := binary.Read(r, binary.BigEndian, &req); err != nil {
+		return Request{}, err
+	}
+	return req, nil
+}
+
+// Write will send the Request to a server.
+func (req Request) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, req)
+}
+
+func (req Request) String() string {
+	return fmt.Sprintf("%s(%d)", req.Command, req.Argument)
+}
+
+// vim: foldmethod=marker
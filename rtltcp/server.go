@@ -0,0 +1,180 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rtltcp
+
+import (
+	"io"
+	"net"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+)
+
+// serverBufferLength is the number of samples sent to the client in each
+// write.
+const serverBufferLength = 16 * 1024
+
+// Server will stream samples from an sdr.Receiver to rtl_tcp clients, as
+// unsigned 8 bit IQ samples, and apply Requests from the client to the
+// sdr.Receiver.
+type Server struct {
+	// Handler is called for each client that connects, and returns the
+	// sdr.Receiver to stream to it. The sdr.Receiver is Closed once the
+	// client goes away.
+	Handler func(net.Conn) (sdr.Receiver, error)
+
+	// OnRequest, if not nil, is called with every Request from a client
+	// along with the result of applying it. Since the rtl_tcp protocol has
+	// no way to tell a client that a Request was rejected, this is the only
+	// place errors are reported.
+	OnRequest func(net.Conn, Request, error)
+
+	// DongleInfo is sent to each client when it connects. If the Magic is
+	// not set, DefaultDongleInfo is sent instead.
+	DongleInfo DongleInfo
+}
+
+// Serve will accept clients from the net.Listener until it returns an
+// error, serving each in a new goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn will stream samples to a single client until either side hangs
+// up, or the sdr.Receiver runs out of samples. The net.Conn is Closed before
+// returning.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	dev, err := s.Handler(conn)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	di := s.DongleInfo
+	if di.Magic != Magic {
+		di = DefaultDongleInfo
+	}
+	if err := di.Write(conn); err != nil {
+		return err
+	}
+
+	rx, err := dev.StartRx()
+	if err != nil {
+		return err
+	}
+	defer rx.Close()
+
+	go s.handleRequests(conn, dev)
+	return stream(conn, rx)
+}
+
+// handleRequests will apply each Request from the client to the
+// sdr.Receiver until the connection is closed.
+func (s *Server) handleRequests(conn net.Conn, dev sdr.Receiver) {
+	for {
+		req, err := ReadRequest(conn)
+		if err != nil {
+			// Either the client hung up, or the stream did and closed the
+			// connection; either way, we're done.
+			conn.Close()
+			return
+		}
+		err = Apply(dev, req)
+		if s.OnRequest != nil {
+			s.OnRequest(conn, req, err)
+		}
+	}
+}
+
+// Apply will make the change requested by the client to the sdr.Receiver.
+// Requests that have no equivalent in the sdr.Sdr interface return
+// sdr.ErrNotSupported.
+func Apply(dev sdr.Receiver, req Request) error {
+	switch req.Command {
+	case CommandSetFreq:
+		return dev.SetCenterFrequency(rf.Hz(req.Argument))
+	case CommandSetSampleRate:
+		return dev.SetSampleRate(uint(req.Argument))
+	case CommandSetGainMode:
+		return dev.SetAutomaticGain(req.Argument == 0)
+	case CommandSetGain:
+		stages, err := dev.GetGainStages()
+		if err != nil {
+			return err
+		}
+		if len(stages) == 0 {
+			return sdr.ErrNotSupported
+		}
+		// The gain is a signed number of tenths of a dB.
+		return dev.SetGain(stages[0], float32(int32(req.Argument))/10)
+	default:
+		return sdr.ErrNotSupported
+	}
+}
+
+// stream will copy samples from the sdr.ReadCloser to the client as u8
+// samples until either fails. Running out of samples is not an error.
+func stream(conn net.Conn, rx sdr.ReadCloser) error {
+	buf, err := sdr.MakeSamples(rx.SampleFormat(), serverBufferLength)
+	if err != nil {
+		return err
+	}
+	out := make(sdr.SamplesU8, serverBufferLength)
+
+	for {
+		n, err := rx.Read(buf)
+		if n > 0 {
+			chunk := buf.Slice(0, n)
+			if iq, ok := chunk.(sdr.SamplesC64); ok {
+				// Conversion to u8 wraps around rather than clipping.
+				dsp.Clamp(iq)
+			}
+			if _, err := sdr.ConvertBuffer(out[:n], chunk); err != nil {
+				return err
+			}
+			b, err := sdr.UnsafeSamplesAsBytes(out[:n])
+			if err != nil {
+				return err
+			}
+			if _, err := conn.Write(b); err != nil {
+				return err
+			}
+		}
+		switch err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// vim: foldmethod=marker
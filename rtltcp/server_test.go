@@ -0,0 +1,139 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rtltcp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/rtltcp"
+	"hz.tools/sdr"
+)
+
+func replayServer(t *testing.T, opts rfcap.ReplayOptions) (*rtltcp.Server, net.Listener) {
+	samples := make(sdr.SamplesC64, 100)
+	for i := range samples {
+		samples[i] = complex(0.5, -0.5)
+	}
+
+	buf := bytes.Buffer{}
+	w, err := rfcap.Writer(&buf, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	})
+	assert.NoError(t, err)
+	_, err = w.Write(samples)
+	assert.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &rtltcp.Server{
+		Handler: func(net.Conn) (sdr.Receiver, error) {
+			return rfcap.ReplaySdr(bytes.NewReader(buf.Bytes()), opts)
+		},
+	}
+	return server, l
+}
+
+func TestServerStream(t *testing.T) {
+	server, l := replayServer(t, rfcap.ReplayOptions{})
+	defer l.Close()
+	go server.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	di, err := rtltcp.ReadDongleInfo(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, rtltcp.TunerR820T, di.TunerType)
+
+	// The server hangs up once the capture is over.
+	body, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, len(body))
+	assert.InDelta(t, 191, body[0], 1)
+	assert.InDelta(t, 64, body[1], 1)
+}
+
+func TestServerRequests(t *testing.T) {
+	server, l := replayServer(t, rfcap.ReplayOptions{Loop: true})
+	defer l.Close()
+
+	type result struct {
+		req rtltcp.Request
+		err error
+	}
+	results := make(chan result, 2)
+	server.OnRequest = func(_ net.Conn, req rtltcp.Request, err error) {
+		results <- result{req, err}
+	}
+	go server.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, conn)
+
+	assert.NoError(t, rtltcp.Request{
+		Command:  rtltcp.CommandSetFreq,
+		Argument: uint32(100*rf.MHz + 200),
+	}.Write(conn))
+	r := <-results
+	assert.Equal(t, rtltcp.CommandSetFreq, r.req.Command)
+	assert.NoError(t, r.err)
+
+	assert.NoError(t, rtltcp.Request{
+		Command:  rtltcp.CommandSetFreq,
+		Argument: uint32(101 * rf.MHz),
+	}.Write(conn))
+	r = <-results
+	assert.Error(t, r.err)
+}
+
+func TestApply(t *testing.T) {
+	server, l := replayServer(t, rfcap.ReplayOptions{})
+	l.Close()
+	dev, err := server.Handler(nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, rtltcp.Apply(dev, rtltcp.Request{Command: rtltcp.CommandSetSampleRate, Argument: 500}))
+	rate, err := dev.GetSampleRate()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(500), rate)
+
+	assert.Error(t, rtltcp.Apply(dev, rtltcp.Request{Command: rtltcp.CommandSetSampleRate, Argument: 300}))
+	assert.Equal(t, sdr.ErrNotSupported, rtltcp.Apply(dev, rtltcp.Request{Command: rtltcp.CommandSetBiasTee, Argument: 1}))
+}
+
+// vim: foldmethod=marker
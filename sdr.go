@@ -29,27 +29,32 @@ import (
 
 // ReaderSdr will return a fake "SDR" that complies with the sdr.Sdr interface,
 // where StartRx will provide the rfcap Reader. There are a number of read
-// only attributes (frequency, samples per second), and calls to a number of
-// methods will return sdr.ErrNotSupported.
+// only attributes (frequency, samples per second, hardware info and gain),
+// and calls to a number of methods will return sdr.ErrNotSupported.
 func ReaderSdr(in io.Reader) (sdr.Receiver, error) {
 	reader, header, err := Reader(in)
 	if err != nil {
 		return nil, err
 	}
 
-	return fakeSdr{
+	s := fakeSdr{
 		header: header,
 		reader: reader,
-	}, nil
+	}
+	if header.Extension != nil {
+		s.ext = *header.Extension
+	}
+	return s, nil
 }
 
 type fakeSdr struct {
 	header Header
+	ext    HeaderExtension
 	reader sdr.Reader
 }
 
 func (s fakeSdr) HardwareInfo() sdr.HardwareInfo {
-	return sdr.HardwareInfo{}
+	return s.ext.HardwareInfo
 }
 
 func (s fakeSdr) Close() error {
@@ -82,11 +87,35 @@ func (s fakeSdr) StartRx() (sdr.ReadCloser, error) {
 	return newNopCloser(s.reader), nil
 }
 
-func (s fakeSdr) SetCenterFrequency(rf.Hz) error         { return sdr.ErrNotSupported }
-func (s fakeSdr) SetAutomaticGain(bool) error            { return sdr.ErrNotSupported }
-func (s fakeSdr) GetGainStages() (sdr.GainStages, error) { return nil, nil }
-func (s fakeSdr) GetGain(sdr.GainStage) (float32, error) { return 0, sdr.ErrNotSupported }
-func (s fakeSdr) SetGain(sdr.GainStage, float32) error   { return sdr.ErrNotSupported }
-func (s fakeSdr) SetSampleRate(uint) error               { return sdr.ErrNotSupported }
+func (s fakeSdr) GetGainStages() (sdr.GainStages, error) {
+	if len(s.ext.GainStages) == 0 {
+		return nil, nil
+	}
+	gainStages := make(sdr.GainStages, len(s.ext.GainStages))
+	for i, gainStage := range s.ext.GainStages {
+		gainStages[i] = gainStage
+	}
+	return gainStages, nil
+}
+
+func (s fakeSdr) GetGain(gainStage sdr.GainStage) (float32, error) {
+	for _, setting := range s.ext.GainStages {
+		if setting.Name == gainStage.String() {
+			return setting.Gain, nil
+		}
+	}
+	return 0, sdr.ErrNotSupported
+}
+
+// GetAutomaticGain will return true if the device that made this capture
+// had automatic gain control enabled.
+func (s fakeSdr) GetAutomaticGain() (bool, error) {
+	return s.ext.AutomaticGain, nil
+}
+
+func (s fakeSdr) SetCenterFrequency(rf.Hz) error       { return sdr.ErrNotSupported }
+func (s fakeSdr) SetAutomaticGain(bool) error          { return sdr.ErrNotSupported }
+func (s fakeSdr) SetGain(sdr.GainStage, float32) error { return sdr.ErrNotSupported }
+func (s fakeSdr) SetSampleRate(uint) error             { return sdr.ErrNotSupported }
 
 // vim: foldmethod=marker
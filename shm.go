@@ -0,0 +1,534 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux
+// +build linux
+
+package rfcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"hz.tools/rfcap/internal"
+	"hz.tools/sdr"
+)
+
+// A shared memory ring is a file, usually under /dev/shm, which is mapped
+// into the memory of one producer and any number of consumers. It starts
+// with a control page, laid out as follows, with all integers in the host's
+// byte order:
+//
+//	bytes 0-7     magic, "RFCAPSHM"
+//	bytes 8-15    ring capacity, in samples
+//	bytes 16-23   reserved cursor, in samples
+//	bytes 24-31   committed cursor, in samples
+//	bytes 32-35   closed flag
+//	bytes 36-39   length of the marshaled Header
+//	bytes 40-43   process ID of the producer
+//	bytes 64-     the marshaled Header
+//
+// followed by the ring itself, with sample n stored at slot n modulo the
+// capacity. The producer moves the reserved cursor forward before writing
+// samples into the ring, and the committed cursor after, so a consumer can
+// tell if the samples it copied out were overwritten while it was reading.
+//
+// A producer that dies without closing the ring never sets the closed flag,
+// so a consumer that's waiting for samples will check that the producer's
+// process is still around every shmLivenessInterval. This only works if the
+// consumer can see the producer's process, which isn't the case across PID
+// namespaces (such as between containers sharing /dev/shm); there, a
+// consumer will wait for as long as the ring exists.
+const (
+	shmControlLength = 4096
+	shmHeaderOffset  = 64
+
+	// ShmDefaultSamples is the capacity of a shared memory ring if none is
+	// given in the ShmOptions.
+	ShmDefaultSamples = 4 * 1024 * 1024
+
+	// shmPollInterval is how long a ShmReader will wait before checking for
+	// new samples again.
+	shmPollInterval = time.Millisecond
+
+	// shmLivenessInterval is how long a ShmReader will wait for new samples
+	// before checking that the producer is still running.
+	shmLivenessInterval = 100 * time.Millisecond
+)
+
+var shmMagic = []byte("RFCAPSHM")
+
+// ShmOptions control the shared memory ring created by CreateShm.
+type ShmOptions struct {
+	// Samples is the capacity of the ring. Consumers that fall this many
+	// samples behind the producer will lose samples. If zero,
+	// ShmDefaultSamples is used.
+	Samples int
+}
+
+// shmRing is a mapped shared memory ring, used by both the producer and
+// consumers.
+type shmRing struct {
+	data     []byte
+	capacity uint64
+	size     int
+}
+
+func (ring shmRing) uint64At(offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&ring.data[offset]))
+}
+
+func (ring shmRing) reserved() *uint64  { return ring.uint64At(16) }
+func (ring shmRing) committed() *uint64 { return ring.uint64At(24) }
+
+func (ring shmRing) closed() *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring.data[32]))
+}
+
+// slots will return the bytes in the ring for the samples starting at
+// sample, up to n samples or the end of the ring, whichever is first.
+func (ring shmRing) slots(sample uint64, n int) []byte {
+	start := sample % ring.capacity
+	if end := start + uint64(n); end > ring.capacity {
+		n = int(ring.capacity - start)
+	}
+	offset := shmControlLength + int(start)*ring.size
+	return ring.data[offset : offset+n*ring.size]
+}
+
+// pid will return the process ID of the producer, or 0 if it isn't known.
+func (ring shmRing) pid() int {
+	return int(internal.NativeEndian.Uint32(ring.data[40:44]))
+}
+
+// producerGone will return true if the producer's process has exited.
+func (ring shmRing) producerGone() bool {
+	pid := ring.pid()
+	if pid == 0 {
+		return false
+	}
+	// EPERM means the process is there, but belongs to someone else.
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+func (ring shmRing) unmap() error {
+	return syscall.Munmap(ring.data)
+}
+
+// ShmWriter is an sdr.Writer that writes samples into a shared memory ring,
+// where any number of ShmReaders in other processes can read them.
+//
+// The ShmWriter will never wait on a ShmReader; readers that fall too far
+// behind will lose samples.
+//
+// Close may be called from another goroutine while a Write is in progress,
+// such as when handling a signal; it will wait for the Write to finish.
+type ShmWriter struct {
+	path   string
+	header Header
+	ring   shmRing
+
+	lock   sync.Mutex
+	sample uint64
+	closed bool
+}
+
+// CreateShm will create a shared memory ring at the provided path, such as
+// /dev/shm/rfcap, replacing any file already there. Samples are always
+// stored uncompressed, in the host's byte order, and the Header is changed
+// to match.
+//
+// The file is only moved into place once it's ready, so a ShmReader will
+// never see one that's half set up.
+func CreateShm(path string, header Header, opts ShmOptions) (*ShmWriter, error) {
+	header.Endianness = internal.NativeEndian
+	header.Compressed = false
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+	marshaled, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if len(marshaled) > shmControlLength-shmHeaderOffset {
+		return nil, fmt.Errorf("rfcap: header is too large for a shared memory ring")
+	}
+
+	capacity := opts.Samples
+	if capacity == 0 {
+		capacity = ShmDefaultSamples
+	}
+	if capacity < 0 {
+		return nil, fmt.Errorf("rfcap: shared memory ring capacity must be positive")
+	}
+	size := header.SampleFormat.Size()
+
+	tmp := filepath.Join(
+		filepath.Dir(path),
+		fmt.Sprintf(".%s.%d", filepath.Base(path), os.Getpid()),
+	)
+	fd, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	length := shmControlLength + capacity*size
+	if err := fd.Truncate(int64(length)); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	data, err := syscall.Mmap(
+		int(fd.Fd()), 0, length,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
+	)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	copy(data, shmMagic)
+	internal.NativeEndian.PutUint64(data[8:16], uint64(capacity))
+	internal.NativeEndian.PutUint32(data[36:40], uint32(len(marshaled)))
+	internal.NativeEndian.PutUint32(data[40:44], uint32(os.Getpid()))
+	copy(data[shmHeaderOffset:], marshaled)
+
+	ring := shmRing{data: data, capacity: uint64(capacity), size: size}
+	if err := os.Rename(tmp, path); err != nil {
+		ring.unmap()
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return &ShmWriter{path: path, header: header, ring: ring}, nil
+}
+
+// Header will return the Header as written to the shared memory ring.
+func (sw *ShmWriter) Header() Header {
+	return sw.header
+}
+
+// SampleRate implements the sdr.Writer interface.
+func (sw *ShmWriter) SampleRate() uint {
+	return sw.header.SampleRate
+}
+
+// SampleFormat implements the sdr.Writer interface.
+func (sw *ShmWriter) SampleFormat() sdr.SampleFormat {
+	return sw.header.SampleFormat
+}
+
+// Write implements the sdr.Writer interface.
+func (sw *ShmWriter) Write(s sdr.Samples) (int, error) {
+	if s.Format() != sw.header.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	buf, err := sdr.UnsafeSamplesAsBytes(s)
+	if err != nil {
+		return 0, err
+	}
+
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if sw.closed {
+		return 0, fmt.Errorf("rfcap: write to a closed shared memory ring")
+	}
+
+	for len(buf) > 0 {
+		slots := sw.ring.slots(sw.sample, len(buf)/sw.ring.size)
+		n := uint64(len(slots) / sw.ring.size)
+
+		atomic.StoreUint64(sw.ring.reserved(), sw.sample+n)
+		copy(slots, buf)
+		sw.sample += n
+		atomic.StoreUint64(sw.ring.committed(), sw.sample)
+
+		buf = buf[len(slots):]
+	}
+	return s.Length(), nil
+}
+
+// Close will mark the stream as over, so ShmReaders return io.EOF once they
+// have read the last sample, and remove the file. ShmReaders that already
+// have it open can keep reading. Calling Close more than once does nothing.
+func (sw *ShmWriter) Close() error {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+
+	atomic.StoreUint32(sw.ring.closed(), 1)
+	err := os.Remove(sw.path)
+	if uerr := sw.ring.unmap(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// ShmReader is an sdr.Reader of samples from a shared memory ring written by
+// a ShmWriter, likely in another process. Each ShmReader has its own cursor,
+// starting at the newest sample when it was opened.
+//
+// If the ShmReader falls so far behind that the samples it was about to read
+// have been overwritten, it will skip ahead to the newest sample, and replace
+// the samples it missed with silence, recorded as an EventGap.
+//
+// Close may be called from another goroutine to stop a Read that's waiting
+// for samples.
+type ShmReader struct {
+	ring   shmRing
+	header Header
+
+	lock    sync.Mutex
+	closed  bool
+	start   uint64
+	sample  uint64
+	gap     uint64
+	silence sdr.Samples
+	events  []Event
+}
+
+// OpenShm will open a shared memory ring created by CreateShm.
+func OpenShm(path string) (*ShmReader, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < shmControlLength {
+		return nil, fmt.Errorf("rfcap: %s is not a shared memory ring", path)
+	}
+	data, err := syscall.Mmap(
+		int(fd.Fd()), 0, int(stat.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sr, err := newShmReader(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("rfcap: %s: %w", path, err)
+	}
+	return sr, nil
+}
+
+func newShmReader(data []byte) (*ShmReader, error) {
+	if !bytes.Equal(data[:8], shmMagic) {
+		return nil, fmt.Errorf("not a shared memory ring")
+	}
+	var (
+		capacity  = internal.NativeEndian.Uint64(data[8:16])
+		headerLen = int(internal.NativeEndian.Uint32(data[36:40]))
+	)
+	if headerLen > shmControlLength-shmHeaderOffset {
+		return nil, fmt.Errorf("shared memory ring header is corrupt")
+	}
+
+	hdr := Header{}
+	if err := hdr.Unmarshal(data[shmHeaderOffset : shmHeaderOffset+headerLen]); err != nil {
+		return nil, err
+	}
+	if hdr.Endianness != binary.ByteOrder(internal.NativeEndian) {
+		return nil, fmt.Errorf("shared memory ring is in a foreign byte order")
+	}
+
+	size := hdr.SampleFormat.Size()
+	if capacity == 0 || uint64(len(data)) != shmControlLength+capacity*uint64(size) {
+		return nil, fmt.Errorf("shared memory ring is the wrong size")
+	}
+
+	silence, err := makeSilence(hdr.SampleFormat, 32*1024)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := shmRing{data: data, capacity: capacity, size: size}
+	sample := atomic.LoadUint64(ring.committed())
+	hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(int64(sample)))
+
+	return &ShmReader{
+		ring:    ring,
+		header:  hdr,
+		start:   sample,
+		sample:  sample,
+		silence: silence,
+	}, nil
+}
+
+// Header will return the Header of the shared memory ring, with the
+// CaptureTime of the first sample this ShmReader will read.
+func (sr *ShmReader) Header() Header {
+	return sr.header
+}
+
+// Events will return an EventGap for each time the ShmReader fell behind and
+// lost samples. The Sample of each Event is in terms of this ShmReader.
+func (sr *ShmReader) Events() []Event {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	return append([]Event{}, sr.events...)
+}
+
+// SampleRate implements the sdr.Reader interface.
+func (sr *ShmReader) SampleRate() uint {
+	return sr.header.SampleRate
+}
+
+// SampleFormat implements the sdr.Reader interface.
+func (sr *ShmReader) SampleFormat() sdr.SampleFormat {
+	return sr.header.SampleFormat
+}
+
+// skip will move the cursor up to the newest sample, recording the samples
+// skipped over as a gap.
+func (sr *ShmReader) skip(to uint64) {
+	sr.events = append(sr.events, Event{
+		Kind:   EventGap,
+		Sample: sr.sample - sr.start,
+		Time:   time.Now(),
+		Length: to - sr.sample,
+	})
+	sr.gap += to - sr.sample
+	sr.sample = to
+}
+
+// Read implements the sdr.Reader interface. Read will wait for the
+// ShmWriter if there are no new samples yet. If the ShmWriter's process
+// exits without closing the ring, Read will return io.ErrUnexpectedEOF.
+func (sr *ShmReader) Read(s sdr.Samples) (int, error) {
+	if s.Format() != sr.header.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if s.Length() == 0 {
+		return 0, nil
+	}
+
+	idle := time.Now()
+	for {
+		n, wait, err := sr.read(s)
+		if !wait {
+			return n, err
+		}
+		if time.Since(idle) >= shmLivenessInterval {
+			if sr.ring.producerGone() {
+				return 0, fmt.Errorf(
+					"rfcap: shared memory ring producer (pid %d) exited without closing it: %w",
+					sr.ring.pid(), io.ErrUnexpectedEOF,
+				)
+			}
+			idle = time.Now()
+		}
+		time.Sleep(shmPollInterval)
+	}
+}
+
+// read will read whatever samples are available, or return true if there
+// are none yet, and the caller should wait for some.
+func (sr *ShmReader) read(s sdr.Samples) (int, bool, error) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	if sr.closed {
+		return 0, false, fmt.Errorf("rfcap: read from a closed shared memory ring")
+	}
+
+	for {
+		if sr.gap > 0 {
+			n := s.Length()
+			if uint64(n) > sr.gap {
+				n = int(sr.gap)
+			}
+			if n > sr.silence.Length() {
+				n = sr.silence.Length()
+			}
+			if _, err := sdr.CopySamples(s.Slice(0, n), sr.silence.Slice(0, n)); err != nil {
+				return 0, false, err
+			}
+			sr.gap -= uint64(n)
+			return n, false, nil
+		}
+
+		committed := atomic.LoadUint64(sr.ring.committed())
+		if committed == sr.sample {
+			// The closed flag is set after the last commit, so if it's set
+			// the committed cursor we just loaded is the last one.
+			if atomic.LoadUint32(sr.ring.closed()) != 0 &&
+				atomic.LoadUint64(sr.ring.committed()) == sr.sample {
+				return 0, false, io.EOF
+			}
+			return 0, true, nil
+		}
+		if committed-sr.sample > sr.ring.capacity {
+			sr.skip(committed)
+			continue
+		}
+
+		n := s.Length()
+		if uint64(n) > committed-sr.sample {
+			n = int(committed - sr.sample)
+		}
+		buf, err := sdr.UnsafeSamplesAsBytes(s.Slice(0, n))
+		if err != nil {
+			return 0, false, err
+		}
+		copied := copy(buf, sr.ring.slots(sr.sample, n)) / sr.ring.size
+
+		// If the producer has started writing over the slots we just
+		// copied, what we have may be a mix of old and new samples.
+		if atomic.LoadUint64(sr.ring.reserved()) > sr.sample+sr.ring.capacity {
+			sr.skip(atomic.LoadUint64(sr.ring.committed()))
+			continue
+		}
+
+		sr.sample += uint64(copied)
+		return copied, false, nil
+	}
+}
+
+// Close will unmap the shared memory ring. Calling Close more than once does
+// nothing.
+func (sr *ShmReader) Close() error {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	if sr.closed {
+		return nil
+	}
+	sr.closed = true
+	return sr.ring.unmap()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,277 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux
+// +build linux
+
+package rfcap_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/internal"
+	"hz.tools/sdr"
+)
+
+var shmEpoch = time.Unix(1600000000, 0)
+
+// createShm will create a shared memory ring of I16 samples in a temporary
+// directory, returning the ShmWriter and a function to clean up.
+func createShm(t *testing.T, capacity int) (string, *rfcap.ShmWriter, func()) {
+	dir, err := ioutil.TempDir("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "ring")
+	w, err := rfcap.CreateShm(path, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     shmEpoch,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI16,
+	}, rfcap.ShmOptions{Samples: capacity})
+	assert.NoError(t, err)
+	return path, w, func() { os.RemoveAll(dir) }
+}
+
+// writeShm will write n samples, where the real part of each sample is its
+// index, starting from the provided index.
+func writeShm(t *testing.T, w *rfcap.ShmWriter, from, n int) {
+	samples := make(sdr.SamplesI16, n)
+	for i := range samples {
+		samples[i] = [2]int16{int16(from + i), 0}
+	}
+	written, err := w.Write(samples)
+	assert.NoError(t, err)
+	assert.Equal(t, n, written)
+}
+
+// readShm will read n samples from the ShmReader.
+func readShm(t *testing.T, r *rfcap.ShmReader, n int) sdr.SamplesI16 {
+	samples := make(sdr.SamplesI16, n)
+	for read := 0; read < n; {
+		i, err := r.Read(samples[read:])
+		assert.NoError(t, err)
+		read += i
+	}
+	return samples
+}
+
+func TestShm(t *testing.T) {
+	path, w, cleanup := createShm(t, 256)
+	defer cleanup()
+
+	r, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, shmEpoch, r.Header().CaptureTime)
+	assert.Equal(t, 100*rf.MHz, r.Header().CenterFrequency)
+	assert.Equal(t, sdr.SampleFormatI16, r.SampleFormat())
+
+	// Write across the end of the ring a few times, reading as we go.
+	for i := 0; i < 1000; i += 200 {
+		writeShm(t, w, i, 200)
+		for j, s := range readShm(t, r, 200) {
+			assert.Equal(t, int16(i+j), s[0])
+		}
+	}
+
+	assert.NoError(t, w.Close())
+	_, err = r.Read(make(sdr.SamplesI16, 10))
+	assert.Equal(t, io.EOF, err)
+	assert.Empty(t, r.Events())
+
+	// The ring is removed once the writer is closed.
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestShmLateOpen(t *testing.T) {
+	path, w, cleanup := createShm(t, 256)
+	defer cleanup()
+	defer w.Close()
+
+	writeShm(t, w, 0, 100)
+
+	r, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, shmEpoch.Add(100*time.Millisecond), r.Header().CaptureTime)
+
+	writeShm(t, w, 100, 50)
+	for i, s := range readShm(t, r, 50) {
+		assert.Equal(t, int16(i+100), s[0])
+	}
+}
+
+func TestShmOverrun(t *testing.T) {
+	path, w, cleanup := createShm(t, 100)
+	defer cleanup()
+
+	fast, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer fast.Close()
+	slow, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer slow.Close()
+
+	// Each reader has its own cursor, so the fast reader keeping up doesn't
+	// help the slow one.
+	for i := 0; i < 500; i += 50 {
+		writeShm(t, w, i, 50)
+		for j, s := range readShm(t, fast, 50) {
+			assert.Equal(t, int16(i+j), s[0])
+		}
+	}
+	// The slow reader skips to the newest sample once it notices it has
+	// fallen behind, and carries on from there.
+	for _, s := range readShm(t, slow, 500) {
+		assert.Equal(t, int16(0), s[0])
+	}
+	writeShm(t, w, 500, 10)
+	assert.NoError(t, w.Close())
+	for i, s := range readShm(t, slow, 10) {
+		assert.Equal(t, int16(i+500), s[0])
+	}
+	_, err = slow.Read(make(sdr.SamplesI16, 10))
+	assert.Equal(t, io.EOF, err)
+
+	assert.Empty(t, fast.Events())
+	events := slow.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.Equal(t, uint64(0), events[0].Sample)
+	assert.Equal(t, uint64(500), events[0].Length)
+}
+
+func TestShmConcurrent(t *testing.T) {
+	path, w, cleanup := createShm(t, 1<<16)
+	defer cleanup()
+
+	r, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	go func() {
+		for i := 0; i < 20000; i += 1000 {
+			writeShm(t, w, i, 1000)
+		}
+		w.Close()
+	}()
+
+	var (
+		buf  = make(sdr.SamplesI16, 777)
+		next = 0
+	)
+	for {
+		n, err := r.Read(buf)
+		for _, s := range buf[:n] {
+			assert.Equal(t, int16(next), s[0])
+			next++
+		}
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 20000, next)
+}
+
+func TestShmClose(t *testing.T) {
+	path, w, cleanup := createShm(t, 256)
+	defer cleanup()
+
+	r, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+
+	// A Read that's waiting for samples is stopped by Close.
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make(sdr.SamplesI16, 10))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, r.Close())
+	assert.Error(t, <-done)
+	assert.NoError(t, r.Close())
+
+	// Close can race with a Write, such as from a signal handler.
+	go func() {
+		samples := make(sdr.SamplesI16, 100)
+		for {
+			if _, err := w.Write(samples); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, w.Close())
+	assert.Error(t, <-done)
+	assert.NoError(t, w.Close())
+}
+
+func TestShmProducerGone(t *testing.T) {
+	path, w, cleanup := createShm(t, 256)
+	defer cleanup()
+	defer w.Close()
+
+	// Pretend the ring was made by a process that has since exited.
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	pid := make([]byte, 4)
+	internal.NativeEndian.PutUint32(pid, uint32(cmd.Process.Pid))
+	_, err = fd.WriteAt(pid, 40)
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	r, err := rfcap.OpenShm(path)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Read(make(sdr.SamplesI16, 10))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestShmNotARing(t *testing.T) {
+	fd, err := ioutil.TempFile("", "go-rf-rfcap_test")
+	assert.NoError(t, err)
+	defer os.Remove(fd.Name())
+	_, err = fd.Write(make([]byte, 8192))
+	assert.NoError(t, err)
+	fd.Close()
+
+	_, err = rfcap.OpenShm(fd.Name())
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
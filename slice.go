@@ -0,0 +1,304 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+type slicePointKind uint8
+
+const (
+	slicePointNone slicePointKind = iota
+	slicePointSample
+	slicePointOffset
+	slicePointTime
+)
+
+// SlicePoint is a position within a capture, expressed as either a sample
+// index, an offset from the start of the capture, or a wall clock time. The
+// zero value is the start of the capture when used as the start of a Slice,
+// and the end of the capture when used as the end.
+type SlicePoint struct {
+	kind   slicePointKind
+	sample int64
+	offset time.Duration
+	time   time.Time
+}
+
+// AtSample will return a SlicePoint at the provided sample index.
+func AtSample(n int64) SlicePoint {
+	return SlicePoint{kind: slicePointSample, sample: n}
+}
+
+// AtOffset will return a SlicePoint at the provided duration from the start
+// of the capture.
+func AtOffset(d time.Duration) SlicePoint {
+	return SlicePoint{kind: slicePointOffset, offset: d}
+}
+
+// AtTime will return a SlicePoint at the provided wall clock time, as
+// measured from the CaptureTime of the capture.
+func AtTime(t time.Time) SlicePoint {
+	return SlicePoint{kind: slicePointTime, time: t}
+}
+
+// IsZero will return true if this SlicePoint was not set.
+func (sp SlicePoint) IsZero() bool {
+	return sp.kind == slicePointNone
+}
+
+// Sample will return the sample index of this SlicePoint in a capture
+// described by the provided Header. If the SlicePoint is the zero value,
+// this will return -1.
+func (sp SlicePoint) Sample(h Header) int64 {
+	var offset time.Duration
+	switch sp.kind {
+	case slicePointSample:
+		return sp.sample
+	case slicePointOffset:
+		offset = sp.offset
+	case slicePointTime:
+		offset = sp.time.Sub(h.CaptureTime)
+	default:
+		return -1
+	}
+	return int64(offset.Seconds() * float64(h.SampleRate))
+}
+
+// ParseSlicePoint will parse a sample index ("48000"), an offset from the
+// start of the capture ("1m30s"), or an RFC 3339 wall clock time. An empty
+// string is the zero SlicePoint.
+func ParseSlicePoint(value string) (SlicePoint, error) {
+	if value == "" {
+		return SlicePoint{}, nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return AtSample(n), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return AtOffset(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return AtTime(t), nil
+	}
+	return SlicePoint{}, fmt.Errorf(
+		"rfcap: %q is not a sample index, duration or RFC 3339 time", value,
+	)
+}
+
+func (sp SlicePoint) String() string {
+	switch sp.kind {
+	case slicePointSample:
+		return fmt.Sprintf("sample %d", sp.sample)
+	case slicePointOffset:
+		return sp.offset.String()
+	case slicePointTime:
+		return sp.time.Format(time.RFC3339Nano)
+	default:
+		return "unset"
+	}
+}
+
+// Slice will copy the samples from start up to (but not including) end of the
+// capture read from the io.Reader into a new capture written to the
+// io.Writer. The CaptureTime of the new capture will be adjusted to the time
+// of the first sample kept, and the new Header will be returned.
+//
+// If the io.Reader is also an io.Seeker, Slice will seek to the start rather
+// than reading through the capture.
+func Slice(in io.Reader, out io.Writer, start, end SlicePoint) (Header, error) {
+	hdr, r, err := sliceReader(in, start, end)
+	if err != nil {
+		return Header{}, err
+	}
+
+	w, err := Writer(out, hdr)
+	if err != nil {
+		return Header{}, err
+	}
+	if _, err := copySamples(w, r, -1); err != nil {
+		return Header{}, err
+	}
+	if err := Flush(w); err != nil {
+		return Header{}, err
+	}
+	return hdr, nil
+}
+
+// sliceReader will read the Header from the io.Reader, and return an
+// sdr.Reader of the samples from start up to (but not including) end, along
+// with a Header whose CaptureTime has been adjusted to the first of those
+// samples.
+func sliceReader(in io.Reader, start, end SlicePoint) (Header, sdr.Reader, error) {
+	hdr, err := ReadHeader(in)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	var (
+		startSample = start.Sample(hdr)
+		endSample   = end.Sample(hdr)
+	)
+	if startSample < 0 {
+		if !start.IsZero() {
+			return Header{}, nil, fmt.Errorf("rfcap: slice start %s is before the capture", start)
+		}
+		startSample = 0
+	}
+	if !end.IsZero() && endSample < startSample {
+		return Header{}, nil, fmt.Errorf("rfcap: slice end %s is before the start %s", end, start)
+	}
+
+	pastEnd := fmt.Errorf("rfcap: slice start %s is past the end of the capture", start)
+
+	skip, err := seekToSample(in, hdr, startSample)
+	if err == io.ErrUnexpectedEOF {
+		return Header{}, nil, pastEnd
+	}
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	r, err := bodyReader(in, hdr)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	skipped, err := copySamples(sdr.Discard(hdr.SampleRate, hdr.SampleFormat), r, skip)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	if skipped < skip {
+		return Header{}, nil, pastEnd
+	}
+
+	if !end.IsZero() {
+		r = &limitReader{Reader: r, remaining: endSample - startSample}
+	}
+
+	outHdr := hdr
+	outHdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(startSample))
+	return outHdr, r, nil
+}
+
+// limitReader is an sdr.Reader that will return io.EOF after a fixed number
+// of samples.
+type limitReader struct {
+	sdr.Reader
+	remaining int64
+}
+
+func (lr *limitReader) Read(s sdr.Samples) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(s.Length()) > lr.remaining {
+		s = s.Slice(0, int(lr.remaining))
+	}
+	n, err := lr.Reader.Read(s)
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+// seekToSample will attempt to seek the io.Reader (which must be just past
+// the header) to the provided sample. Since not every stream can seek, or
+// land precisely on every sample, this will return the number of samples that
+// still need to be read and thrown away to get to the requested sample. If
+// the sample is past the end of a seekable stream, io.ErrUnexpectedEOF is
+// returned.
+func seekToSample(in io.Reader, hdr Header, sample int64) (int64, error) {
+	seeker, ok := in.(io.Seeker)
+	if !ok || sample == 0 {
+		return sample, nil
+	}
+
+	offset, err := hdr.DataOffset()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		aligned = sample
+		bytes   = sample * int64(hdr.SampleFormat.Size())
+	)
+	if hdr.Compressed {
+		// Compressed samples can only be unpacked in whole blocks.
+		aligned = sample - sample%4
+		bytes = (aligned / 4) * 12
+	}
+
+	// Seeking past the end isn't an error, so check for it up front.
+	if size, err := seeker.Seek(0, io.SeekEnd); err == nil && offset+bytes > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := seeker.Seek(offset+bytes, io.SeekStart); err != nil {
+		// Some io.Seekers (such as pipes) aren't really seekable.
+		return sample, nil
+	}
+	return sample - aligned, nil
+}
+
+// copySamples will copy up to n samples from the sdr.Reader to the
+// sdr.Writer, or until the end of the stream if n is negative. The number of
+// samples copied is returned. Reaching the end of the stream is not an
+// error.
+func copySamples(w sdr.Writer, r sdr.Reader, n int64) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+
+	buf, err := sdr.MakeSamples(r.SampleFormat(), 32*1024)
+	if err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	for n < 0 || copied < n {
+		chunk := buf
+		if n >= 0 && n-copied < int64(chunk.Length()) {
+			chunk = buf.Slice(0, int(n-copied))
+		}
+
+		i, err := r.Read(chunk)
+		if i > 0 {
+			if _, werr := w.Write(chunk.Slice(0, i)); werr != nil {
+				return copied, werr
+			}
+			copied += int64(i)
+		}
+		switch err {
+		case nil:
+		case io.EOF:
+			return copied, nil
+		default:
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,142 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// streamOnly hides any io.Seeker implementation of the io.Reader.
+type streamOnly struct {
+	io.Reader
+}
+
+func TestSlice(t *testing.T) {
+	when := time.Unix(1600000000, 0)
+	ref := make(sdr.SamplesU8, 100)
+	for i := range ref {
+		ref[i] = [2]uint8{uint8(i), uint8(i)}
+	}
+	capture := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  when,
+		SampleRate:   10,
+		SampleFormat: sdr.SampleFormatU8,
+	}, ref).Bytes()
+
+	for _, tc := range []struct {
+		name       string
+		start, end rfcap.SlicePoint
+	}{
+		{"sample", rfcap.AtSample(15), rfcap.AtSample(25)},
+		{"offset", rfcap.AtOffset(1500 * time.Millisecond), rfcap.AtOffset(2500 * time.Millisecond)},
+		{"time", rfcap.AtTime(when.Add(1500 * time.Millisecond)), rfcap.AtSample(25)},
+	} {
+		for _, in := range []io.Reader{
+			bytes.NewReader(capture),
+			streamOnly{bytes.NewReader(capture)},
+		} {
+			out := &bytes.Buffer{}
+			hdr, err := rfcap.Slice(in, out, tc.start, tc.end)
+			assert.NoError(t, err, tc.name)
+			assert.True(t, hdr.CaptureTime.Equal(when.Add(1500*time.Millisecond)), tc.name)
+
+			r, hdr, err := rfcap.Reader(out)
+			assert.NoError(t, err)
+			assert.True(t, hdr.CaptureTime.Equal(when.Add(1500*time.Millisecond)), tc.name)
+
+			samples := make(sdr.SamplesU8, 20)
+			n, err := sdr.ReadAtLeast(r, samples, 10)
+			assert.NoError(t, err, tc.name)
+			assert.Equal(t, 10, n, tc.name)
+			assert.Equal(t, ref[15:25], samples[:n], tc.name)
+		}
+	}
+}
+
+func TestSliceCompressed(t *testing.T) {
+	ref := make(sdr.SamplesI16, 32)
+	for i := range ref {
+		ref[i] = [2]int16{int16(i << 4), int16(-i << 4)}
+	}
+	capture := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   10,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+		Compressed:   true,
+	}, ref).Bytes()
+
+	out := &bytes.Buffer{}
+	_, err := rfcap.Slice(bytes.NewReader(capture), out, rfcap.AtSample(5), rfcap.SlicePoint{})
+	assert.NoError(t, err)
+
+	r, _, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesI16, 32)
+	n, err := sdr.ReadAtLeast(r, samples, 28)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, n)
+	assert.Equal(t, ref[5:], samples[:27])
+
+	// The last partial block is padded out with silence.
+	assert.Equal(t, [2]int16{0, 0}, samples[27])
+}
+
+func TestSliceBadRange(t *testing.T) {
+	capture := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  time.Unix(1600000000, 0),
+		SampleRate:   10,
+		SampleFormat: sdr.SampleFormatU8,
+	}, sdr.SamplesU8{{0, 0}})
+
+	_, err := rfcap.Slice(bytes.NewReader(capture.Bytes()), &bytes.Buffer{},
+		rfcap.AtSample(10), rfcap.AtSample(5))
+	assert.Error(t, err)
+
+	_, err = rfcap.Slice(bytes.NewReader(capture.Bytes()), &bytes.Buffer{},
+		rfcap.AtTime(time.Unix(1500000000, 0)), rfcap.SlicePoint{})
+	assert.Error(t, err)
+
+	// A start past the end of the capture is an error, rather than an
+	// empty capture, whether or not the input can seek.
+	for _, in := range []io.Reader{
+		bytes.NewReader(capture.Bytes()),
+		streamOnly{bytes.NewReader(capture.Bytes())},
+	} {
+		out := &bytes.Buffer{}
+		_, err = rfcap.Slice(in, out, rfcap.AtSample(2), rfcap.SlicePoint{})
+		assert.Error(t, err)
+		assert.Equal(t, 0, out.Len())
+	}
+}
+
+// vim: foldmethod=marker
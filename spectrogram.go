@@ -0,0 +1,569 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+	"hz.tools/sdr/fft"
+)
+
+// Window is a window function applied to samples before each FFT.
+type Window uint8
+
+const (
+	// WindowHann is the Hann window, and is the default.
+	WindowHann Window = iota
+
+	// WindowHamming is the Hamming window.
+	WindowHamming
+
+	// WindowBlackman is the Blackman window.
+	WindowBlackman
+
+	// WindowRectangular leaves the samples unchanged.
+	WindowRectangular
+)
+
+// String will return the name of the Window, as accepted by ParseWindow.
+func (w Window) String() string {
+	switch w {
+	case WindowHann:
+		return "hann"
+	case WindowHamming:
+		return "hamming"
+	case WindowBlackman:
+		return "blackman"
+	case WindowRectangular:
+		return "rectangular"
+	default:
+		return fmt.Sprintf("Window(%d)", uint8(w))
+	}
+}
+
+// ParseWindow will return the Window with the provided name.
+func ParseWindow(name string) (Window, error) {
+	for _, w := range []Window{WindowHann, WindowHamming, WindowBlackman, WindowRectangular} {
+		if w.String() == name {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("rfcap: unknown window %q", name)
+}
+
+func (w Window) coefficients(n int) ([]float32, error) {
+	switch w {
+	case WindowHann:
+		return dsp.Hann(n), nil
+	case WindowHamming:
+		return dsp.Hamming(n), nil
+	case WindowBlackman:
+		return dsp.Blackman(n), nil
+	case WindowRectangular:
+		return dsp.Rectangular(n), nil
+	default:
+		return nil, fmt.Errorf("rfcap: unknown window %s", w)
+	}
+}
+
+// SpectrogramOptions control how a spectrogram is rendered.
+type SpectrogramOptions struct {
+	// FFTSize is the number of frequency bins in each FFT, which is also the
+	// width of the plot in pixels. This must be a power of two, and defaults
+	// to 1024.
+	FFTSize int
+
+	// Window is applied to the samples before each FFT.
+	Window Window
+
+	// Average is the number of consecutive FFTs that are averaged together
+	// into each row of the plot. This defaults to 1.
+	Average int
+
+	// MaxHeight is the most rows the plot may have. If the capture is long
+	// enough to need more, Average is doubled until it fits. This defaults
+	// to 4096.
+	MaxHeight int
+
+	// MinDB and MaxDB are the range of power, in dBFS, that is mapped onto
+	// the color scale. If both are zero, the range is picked from the
+	// capture.
+	MinDB float64
+	MaxDB float64
+
+	// Start and End limit the span of the capture that is rendered. The zero
+	// value renders the whole capture.
+	Start SlicePoint
+	End   SlicePoint
+}
+
+const (
+	// spectrogramMarginLeft leaves room for the time labels.
+	spectrogramMarginLeft = glyphAdvance*12 + 6
+
+	// spectrogramMarginRight leaves room for half of a frequency label.
+	spectrogramMarginRight = glyphAdvance * 6
+
+	spectrogramMarginTop    = 4
+	spectrogramMarginBottom = glyphHeight + 8
+
+	// spectrogramTickLength is the length of the tick marks on each axis.
+	spectrogramTickLength = 3
+
+	// spectrogramTimeTick is the number of rows between each label on the
+	// time axis.
+	spectrogramTimeTick = 64
+
+	// spectrogramFrequencyTick is the rough number of pixels between each
+	// label on the frequency axis.
+	spectrogramFrequencyTick = 64
+
+	// spectrogramMaxHeight is the default SpectrogramOptions.MaxHeight.
+	spectrogramMaxHeight = 4096
+)
+
+var (
+	spectrogramBackground = color.RGBA{0x20, 0x20, 0x20, 0xFF}
+	spectrogramForeground = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+
+	// spectrogramColors are the stops of the color scale, from MinDB to
+	// MaxDB.
+	spectrogramColors = []color.RGBA{
+		{0x00, 0x00, 0x00, 0xFF},
+		{0x00, 0x00, 0x80, 0xFF},
+		{0x80, 0x00, 0xA0, 0xFF},
+		{0xE6, 0x3C, 0x1E, 0xFF},
+		{0xFF, 0xDC, 0x00, 0xFF},
+		{0xFF, 0xFF, 0xFF, 0xFF},
+	}
+)
+
+// Spectrogram will render a waterfall plot of the capture read from the
+// io.Reader. Frequency runs left to right, labeled from the CenterFrequency
+// and SampleRate of the capture, and time runs top to bottom, labeled in
+// UTC from the CaptureTime.
+func Spectrogram(in io.Reader, opts SpectrogramOptions) (*image.RGBA, error) {
+	if opts.FFTSize == 0 {
+		opts.FFTSize = 1024
+	}
+	if opts.Average == 0 {
+		opts.Average = 1
+	}
+	if opts.Average < 0 {
+		return nil, fmt.Errorf("rfcap: spectrogram average must be positive")
+	}
+	if opts.MaxHeight == 0 {
+		opts.MaxHeight = spectrogramMaxHeight
+	}
+	if opts.MaxHeight < 0 {
+		return nil, fmt.Errorf("rfcap: spectrogram max height must be positive")
+	}
+	if opts.MinDB > opts.MaxDB {
+		return nil, fmt.Errorf("rfcap: spectrogram dB range is backwards")
+	}
+
+	hdr, r, err := sliceReader(in, opts.Start, opts.End)
+	if err != nil {
+		return nil, err
+	}
+	r, err = newConvertReader(r, sdr.SampleFormatC64)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, average, err := spectrogramRows(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("rfcap: capture is shorter than one fft")
+	}
+
+	minDB, maxDB := opts.MinDB, opts.MaxDB
+	if minDB == 0 && maxDB == 0 {
+		minDB, maxDB = spectrogramRange(rows)
+	}
+
+	var (
+		width  = spectrogramMarginLeft + opts.FFTSize + spectrogramMarginRight
+		height = spectrogramMarginTop + len(rows) + spectrogramMarginBottom
+		img    = image.NewRGBA(image.Rect(0, 0, width, height))
+	)
+	draw.Draw(img, img.Bounds(), image.NewUniform(spectrogramBackground), image.Point{}, draw.Src)
+
+	for y, row := range rows {
+		for x, db := range row {
+			img.SetRGBA(
+				spectrogramMarginLeft+x,
+				spectrogramMarginTop+y,
+				spectrogramColor((float64(db)-minDB)/(maxDB-minDB)),
+			)
+		}
+	}
+
+	rowDuration := hdr.Duration(int64(opts.FFTSize * average))
+	drawTimeAxis(img, hdr.CaptureTime, rowDuration, len(rows))
+	drawFrequencyAxis(img, hdr.CenterFrequency, hdr.SampleRate, opts.FFTSize, len(rows))
+	return img, nil
+}
+
+// WriteSpectrogram will render a Spectrogram of the capture read from the
+// io.Reader, and write it to the io.Writer as a PNG.
+func WriteSpectrogram(in io.Reader, out io.Writer, opts SpectrogramOptions) error {
+	img, err := Spectrogram(in, opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(out, img)
+}
+
+// spectrum adds up the power in each bin of consecutive FFTs.
+type spectrum struct {
+	// iq is filled by the caller before each call to add.
+	iq sdr.SamplesC64
+
+	freq   []complex64
+	sum    []float64
+	window []float32
+	gain   float64
+	plan   fft.Plan
+	count  int
+}
+
+func newSpectrum(size int, w Window) (*spectrum, error) {
+	window, err := w.coefficients(size)
+	if err != nil {
+		return nil, err
+	}
+	sp := &spectrum{
+		iq:     make(sdr.SamplesC64, size),
+		freq:   make([]complex64, size),
+		sum:    make([]float64, size),
+		window: window,
+	}
+
+	// Scale so that a full scale tone in the middle of a bin is 0 dBFS,
+	// regardless of the window or FFT size.
+	for _, w := range window {
+		sp.gain += float64(w)
+	}
+	sp.gain *= sp.gain
+
+	if sp.plan, err = dsp.Plan(sp.iq, sp.freq, fft.Forward); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// add will window and transform the samples in iq, and add their power to
+// the running total. The samples in iq are windowed in place.
+func (sp *spectrum) add() error {
+	dsp.ApplyWindow(sp.iq, sp.window)
+	if err := sp.plan.Transform(); err != nil {
+		return err
+	}
+	for i, v := range sp.freq {
+		sp.sum[i] += float64(real(v)*real(v) + imag(v)*imag(v))
+	}
+	sp.count++
+	return nil
+}
+
+// power will return the average power in each bin of the FFTs added since
+// the last row, relative to full scale, with the negative frequencies first.
+func (sp *spectrum) power() []float64 {
+	var (
+		size  = len(sp.sum)
+		half  = size / 2
+		power = make([]float64, size)
+	)
+	for i, sum := range sp.sum {
+		power[(i+half)%size] = sum / sp.gain / float64(sp.count)
+		sp.sum[i] = 0
+	}
+	sp.count = 0
+	return power
+}
+
+// restore will put back a row returned by power, as if the count FFTs that
+// went into it had just been added. This must be called right after power.
+func (sp *spectrum) restore(power []float64, count int) {
+	var (
+		size = len(sp.sum)
+		half = size / 2
+	)
+	for i := range sp.sum {
+		sp.sum[i] = power[(i+half)%size] * sp.gain * float64(count)
+	}
+	sp.count = count
+}
+
+// row will return the average power in each bin of the FFTs added since the
+// last row, in dBFS, with the negative frequencies first.
+func (sp *spectrum) row() []float32 {
+	return dBFSRow(sp.power())
+}
+
+// dBFSRow will convert a row of power, relative to full scale, to dBFS.
+func dBFSRow(power []float64) []float32 {
+	row := make([]float32, len(power))
+	for i, p := range power {
+		row[i] = float32(dBFS(p))
+	}
+	return row
+}
+
+// powerRow is the average power in each bin of a row of the spectrogram,
+// and the number of FFTs that were averaged into it.
+type powerRow struct {
+	power []float64
+	count int
+}
+
+// spectrogramRows will compute each row of the spectrogram, in dBFS, with
+// the negative frequencies first, along with the number of FFTs averaged
+// into each row. Any samples at the end of the capture that don't fill a
+// whole FFT are dropped.
+//
+// The length of the capture isn't known up front, so whenever there are
+// more than MaxHeight rows, each pair of rows is averaged into one, and the
+// number of FFTs in each row from then on is doubled.
+func spectrogramRows(r sdr.Reader, opts SpectrogramOptions) ([][]float32, int, error) {
+	sp, err := newSpectrum(opts.FFTSize, opts.Window)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		average = opts.Average
+		rows    = []powerRow{}
+	)
+	for {
+		n, err := readSamples(r, sp.iq)
+		if n < len(sp.iq) {
+			if err != io.EOF {
+				return nil, 0, err
+			}
+			if sp.count > 0 {
+				rows = append(rows, powerRow{count: sp.count, power: sp.power()})
+			}
+			if len(rows) > opts.MaxHeight {
+				rows = mergeRows(rows)
+				average *= 2
+			}
+			ret := make([][]float32, len(rows))
+			for i, row := range rows {
+				ret[i] = dBFSRow(row.power)
+			}
+			return ret, average, nil
+		}
+
+		if err := sp.add(); err != nil {
+			return nil, 0, err
+		}
+		if sp.count < average {
+			continue
+		}
+		rows = append(rows, powerRow{count: sp.count, power: sp.power()})
+		if len(rows) > opts.MaxHeight {
+			if len(rows)%2 == 1 {
+				// The last row is only half of a row at the new
+				// average, so it goes back to be filled out.
+				last := rows[len(rows)-1]
+				sp.restore(last.power, last.count)
+				rows = rows[:len(rows)-1]
+			}
+			rows = mergeRows(rows)
+			average *= 2
+		}
+	}
+}
+
+// mergeRows will average each pair of rows into one, weighted by the number
+// of FFTs in each. An odd row out at the end is left as is.
+func mergeRows(rows []powerRow) []powerRow {
+	merged := make([]powerRow, (len(rows)+1)/2)
+	for i := range merged {
+		if 2*i+1 == len(rows) {
+			merged[i] = rows[2*i]
+			continue
+		}
+		a, b := rows[2*i], rows[2*i+1]
+		count := a.count + b.count
+		for j := range a.power {
+			a.power[j] = (a.power[j]*float64(a.count) + b.power[j]*float64(b.count)) / float64(count)
+		}
+		merged[i] = powerRow{power: a.power, count: count}
+	}
+	return merged
+}
+
+// spectrogramRange will pick a dB range for the color scale, from the 5th
+// percentile of all bins up to the loudest bin.
+func spectrogramRange(rows [][]float32) (float64, float64) {
+	all := make([]float64, 0, len(rows)*len(rows[0]))
+	for _, row := range rows {
+		for _, db := range row {
+			all = append(all, float64(db))
+		}
+	}
+	sort.Float64s(all)
+
+	minDB, maxDB := all[len(all)/20], all[len(all)-1]
+	if maxDB-minDB < 1 {
+		minDB = maxDB - 1
+	}
+	return minDB, maxDB
+}
+
+// spectrogramColor will return the color for a value between 0 and 1.
+func spectrogramColor(v float64) color.RGBA {
+	switch {
+	case math.IsNaN(v) || v <= 0:
+		return spectrogramColors[0]
+	case v >= 1:
+		return spectrogramColors[len(spectrogramColors)-1]
+	}
+
+	var (
+		pos  = v * float64(len(spectrogramColors)-1)
+		i    = int(pos)
+		frac = pos - float64(i)
+		a    = spectrogramColors[i]
+		b    = spectrogramColors[i+1]
+	)
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*frac)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 0xFF}
+}
+
+func drawTimeAxis(img *image.RGBA, start time.Time, rowDuration time.Duration, rows int) {
+	x := spectrogramMarginLeft - 1
+	for y := 0; y < rows; y++ {
+		img.SetRGBA(x, spectrogramMarginTop+y, spectrogramForeground)
+	}
+
+	for row := 0; row < rows; row += spectrogramTimeTick {
+		var (
+			y     = spectrogramMarginTop + row
+			label = start.Add(rowDuration * time.Duration(row)).UTC().Format("15:04:05.000")
+		)
+		for i := 1; i <= spectrogramTickLength; i++ {
+			img.SetRGBA(x-i, y, spectrogramForeground)
+		}
+		drawText(
+			img,
+			x-spectrogramTickLength-2-textWidth(label),
+			y,
+			label,
+			spectrogramForeground,
+		)
+	}
+}
+
+func drawFrequencyAxis(img *image.RGBA, center rf.Hz, sampleRate uint, bins, rows int) {
+	y := spectrogramMarginTop + rows
+	for x := 0; x < bins; x++ {
+		img.SetRGBA(spectrogramMarginLeft+x, y, spectrogramForeground)
+	}
+
+	var (
+		span  = float64(sampleRate)
+		low   = float64(center) - span/2
+		ticks = bins / spectrogramFrequencyTick
+	)
+	if ticks < 2 {
+		ticks = 2
+	}
+	step := niceStep(span / float64(ticks))
+
+	for f := math.Ceil(low/step) * step; f <= low+span; f += step {
+		x := spectrogramMarginLeft + int(math.Round((f-low)/span*float64(bins)))
+		if x >= spectrogramMarginLeft+bins {
+			x = spectrogramMarginLeft + bins - 1
+		}
+		for i := 1; i <= spectrogramTickLength; i++ {
+			img.SetRGBA(x, y+i, spectrogramForeground)
+		}
+		label := formatFrequency(f, step)
+		drawText(
+			img,
+			x-textWidth(label)/2,
+			y+spectrogramTickLength+2,
+			label,
+			spectrogramForeground,
+		)
+	}
+}
+
+// niceStep will round the step up to the next 1, 2 or 5 times a power of ten.
+func niceStep(step float64) float64 {
+	if step <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if step <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatFrequency will format the frequency with just enough precision to
+// tell apart frequencies step apart. This is used rather than rf.Hz.String
+// since that prints every digit of floating point noise.
+func formatFrequency(f, step float64) string {
+	var (
+		units = []string{"Hz", "kHz", "MHz", "GHz"}
+		scale = 1.0
+		unit  = 0
+		abs   = math.Abs(f)
+	)
+	if abs == 0 {
+		abs = step
+	}
+	for unit < len(units)-1 && abs >= scale*1000 {
+		scale *= 1000
+		unit++
+	}
+
+	decimals := int(math.Ceil(-math.Log10(step/scale) - 1e-9))
+	if decimals < 0 {
+		decimals = 0
+	}
+	return strconv.FormatFloat(f/scale, 'f', decimals, 64) + units[unit]
+}
+
+// vim: foldmethod=marker
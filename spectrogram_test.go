@@ -0,0 +1,178 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"math"
+	"math/cmplx"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func toneCapture(t *testing.T, bin, fftSize, length int) *bytes.Buffer {
+	tone := make(sdr.SamplesC64, length)
+	for i := range tone {
+		tone[i] = complex64(0.5 * cmplx.Rect(1, 2*math.Pi*float64(bin*i)/float64(fftSize)))
+	}
+	return captureBuffer(t, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		CenterFrequency: rf.MustParseHz("100MHz"),
+		SampleRate:      1024000,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	}, tone)
+}
+
+// brightestColumn will return the x coordinate of the brightest pixel in
+// the middle row of the image, skipping the axis, which is pure white.
+func brightestColumn(img *image.RGBA) int {
+	var (
+		bounds = img.Bounds()
+		row    = bounds.Min.Y + bounds.Dy()/2
+		column = -1
+		peak   = -1
+	)
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		c := img.RGBAAt(x, row)
+		if c.R == 0xFF && c.G == 0xFF && c.B == 0xFF {
+			continue
+		}
+		if v := int(c.R) + int(c.G) + int(c.B); v > peak {
+			peak, column = v, x
+		}
+	}
+	return column
+}
+
+func TestSpectrogramTone(t *testing.T) {
+	opts := rfcap.SpectrogramOptions{
+		FFTSize: 256,
+		Average: 2,
+		MinDB:   -120,
+		MaxDB:   10,
+	}
+
+	high, err := rfcap.Spectrogram(toneCapture(t, 16, 256, 256*40), opts)
+	assert.NoError(t, err)
+	low, err := rfcap.Spectrogram(toneCapture(t, -16, 256, 256*40), opts)
+	assert.NoError(t, err)
+
+	// The plot is one pixel per bin, so the tones are 32 pixels apart.
+	assert.Equal(t, 32, brightestColumn(high)-brightestColumn(low))
+
+	// There's room for labels around the plot, but there's only one row
+	// per 2 ffts.
+	assert.True(t, high.Bounds().Dx() > 256)
+	assert.True(t, high.Bounds().Dy() > 20)
+	assert.True(t, high.Bounds().Dy() < 20+64)
+}
+
+func TestSpectrogramPNG(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, rfcap.WriteSpectrogram(toneCapture(t, 3, 64, 64*4), out, rfcap.SpectrogramOptions{
+		FFTSize: 64,
+		Window:  rfcap.WindowBlackman,
+		MinDB:   -100,
+		MaxDB:   0,
+	}))
+
+	img, err := png.Decode(out)
+	assert.NoError(t, err)
+	assert.True(t, img.Bounds().Dx() > 64)
+}
+
+func TestSpectrogramSpan(t *testing.T) {
+	full, err := rfcap.Spectrogram(toneCapture(t, 3, 64, 64*100), rfcap.SpectrogramOptions{
+		FFTSize: 64,
+	})
+	assert.NoError(t, err)
+
+	span, err := rfcap.Spectrogram(toneCapture(t, 3, 64, 64*100), rfcap.SpectrogramOptions{
+		FFTSize: 64,
+		Start:   rfcap.AtSample(64 * 10),
+		End:     rfcap.AtSample(64 * 30),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 80, full.Bounds().Dy()-span.Bounds().Dy())
+}
+
+func TestSpectrogramMaxHeight(t *testing.T) {
+	capped, err := rfcap.Spectrogram(toneCapture(t, 3, 64, 64*100), rfcap.SpectrogramOptions{
+		FFTSize:   64,
+		MaxHeight: 30,
+		MinDB:     -120,
+		MaxDB:     10,
+	})
+	assert.NoError(t, err)
+
+	// 100 ffts don't fit in 30 rows, or in 30 rows of 2, so they're
+	// averaged 4 to a row.
+	averaged, err := rfcap.Spectrogram(toneCapture(t, 3, 64, 64*100), rfcap.SpectrogramOptions{
+		FFTSize: 64,
+		Average: 4,
+		MinDB:   -120,
+		MaxDB:   10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, averaged.Bounds(), capped.Bounds())
+	assert.Equal(t, brightestColumn(averaged), brightestColumn(capped))
+}
+
+func TestSpectrogramErrors(t *testing.T) {
+	_, err := rfcap.Spectrogram(toneCapture(t, 3, 64, 32), rfcap.SpectrogramOptions{
+		FFTSize: 64,
+	})
+	assert.Error(t, err)
+
+	_, err = rfcap.Spectrogram(toneCapture(t, 3, 64, 64*4), rfcap.SpectrogramOptions{
+		FFTSize: 48,
+	})
+	assert.Error(t, err)
+}
+
+func TestParseWindow(t *testing.T) {
+	for _, w := range []rfcap.Window{
+		rfcap.WindowHann,
+		rfcap.WindowHamming,
+		rfcap.WindowBlackman,
+		rfcap.WindowRectangular,
+	} {
+		parsed, err := rfcap.ParseWindow(w.String())
+		assert.NoError(t, err)
+		assert.Equal(t, w, parsed)
+	}
+
+	_, err := rfcap.ParseWindow("kaiser")
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
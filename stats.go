@@ -0,0 +1,296 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"math"
+
+	"hz.tools/sdr"
+)
+
+const (
+	// statsBlockLength is the number of samples averaged together to
+	// estimate the noise floor.
+	statsBlockLength = 1024
+
+	// statsHistogramMin is the lowest block power tracked by the noise
+	// floor histogram, in dBFS. Anything quieter is counted as this.
+	statsHistogramMin = -200
+
+	// statsHistogramStep is the width of each bin in the noise floor
+	// histogram, in dB.
+	statsHistogramStep = 0.1
+
+	// statsNoiseFloorPercentile is the percentile of block powers that is
+	// reported as the noise floor.
+	statsNoiseFloorPercentile = 0.1
+)
+
+// Stats is a streaming accumulator of signal statistics, such as power, DC
+// offset and IQ imbalance. Samples are added with Add, and a StatsReport can
+// be generated at any point.
+//
+// Full scale (0 dBFS) is a sample with a magnitude of 1 once converted to
+// complex64, and nothing is reported as quieter than -200 dBFS.
+type Stats struct {
+	format sdr.SampleFormat
+	buf    sdr.SamplesC64
+
+	samples int64
+	clipped int64
+
+	sumI, sumQ          float64
+	sumII, sumQQ, sumIQ float64
+	peak                float64
+
+	blockPower   float64
+	blockSamples int
+	histogram    []int64
+}
+
+// StatsReport is a snapshot of the statistics accumulated by Stats.
+type StatsReport struct {
+	// Samples is the number of samples that were accumulated.
+	Samples int64 `json:"samples"`
+
+	// MeanPower is the mean power of all samples, in dBFS.
+	MeanPower float64 `json:"mean_power_dbfs"`
+
+	// PeakPower is the power of the single loudest sample, in dBFS.
+	PeakPower float64 `json:"peak_power_dbfs"`
+
+	// DCOffsetI and DCOffsetQ are the mean of the I and Q values, where
+	// 1 is full scale.
+	DCOffsetI float64 `json:"dc_offset_i"`
+	DCOffsetQ float64 `json:"dc_offset_q"`
+
+	// GainImbalance is the ratio of the power in I to the power in Q, in dB,
+	// once the DC offset has been removed.
+	GainImbalance float64 `json:"iq_gain_imbalance_db"`
+
+	// PhaseImbalance is an estimate of how far I and Q are from being 90
+	// degrees apart, in degrees.
+	PhaseImbalance float64 `json:"iq_phase_imbalance_degrees"`
+
+	// Clipped is the fraction of samples where I or Q was at the limit of
+	// an integer sample format. This is always 0 for complex64 samples.
+	Clipped float64 `json:"clipped_fraction"`
+
+	// NoiseFloor is an estimate of the noise floor, in dBFS, taken as the
+	// 10th percentile of the power of each block of 1024 samples.
+	NoiseFloor float64 `json:"noise_floor_dbfs"`
+}
+
+// NewStats will create a new Stats accumulator for samples in the provided
+// format.
+func NewStats(format sdr.SampleFormat) *Stats {
+	bins := int((0-statsHistogramMin)/statsHistogramStep) + 1
+	return &Stats{
+		format:    format,
+		buf:       make(sdr.SamplesC64, 32*1024),
+		histogram: make([]int64, bins),
+	}
+}
+
+// countClipped will return the number of samples where either I or Q are at
+// the limits of the integer sample format. Since int16 samples are often
+// 12 bit values shifted to the MSB, any int16 value within the top 4 bits of
+// the limit is also counted.
+func countClipped(s sdr.Samples) int64 {
+	var n int64
+	switch s := s.(type) {
+	case sdr.SamplesU8:
+		for _, v := range s {
+			if v[0] == 0 || v[0] == math.MaxUint8 || v[1] == 0 || v[1] == math.MaxUint8 {
+				n++
+			}
+		}
+	case sdr.SamplesI8:
+		for _, v := range s {
+			if v[0] == math.MinInt8 || v[0] == math.MaxInt8 ||
+				v[1] == math.MinInt8 || v[1] == math.MaxInt8 {
+				n++
+			}
+		}
+	case sdr.SamplesI16:
+		const max = math.MaxInt16 &^ 0xF
+		for _, v := range s {
+			if v[0] == math.MinInt16 || v[0] >= max || v[1] == math.MinInt16 || v[1] >= max {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Add will accumulate the provided samples.
+func (st *Stats) Add(s sdr.Samples) error {
+	if s.Format() != st.format {
+		return sdr.ErrSampleFormatMismatch
+	}
+	st.clipped += countClipped(s)
+
+	for i := 0; i < s.Length(); i += st.buf.Length() {
+		end := i + st.buf.Length()
+		if end > s.Length() {
+			end = s.Length()
+		}
+		n, err := sdr.ConvertBuffer(st.buf, s.Slice(i, end))
+		if err != nil {
+			return err
+		}
+		st.addC64(st.buf[:n])
+	}
+	return nil
+}
+
+func (st *Stats) addC64(s sdr.SamplesC64) {
+	for _, v := range s {
+		var (
+			i     = float64(real(v))
+			q     = float64(imag(v))
+			power = i*i + q*q
+		)
+		st.sumI += i
+		st.sumQ += q
+		st.sumII += i * i
+		st.sumQQ += q * q
+		st.sumIQ += i * q
+		if power > st.peak {
+			st.peak = power
+		}
+
+		st.blockPower += power
+		st.blockSamples++
+		if st.blockSamples == statsBlockLength {
+			st.histogram[st.histogramBin(st.blockPower/statsBlockLength)]++
+			st.blockPower = 0
+			st.blockSamples = 0
+		}
+	}
+	st.samples += int64(len(s))
+}
+
+func (st *Stats) histogramBin(power float64) int {
+	bin := int((dBFS(power) - statsHistogramMin) / statsHistogramStep)
+	switch {
+	case bin < 0:
+		return 0
+	case bin >= len(st.histogram):
+		return len(st.histogram) - 1
+	default:
+		return bin
+	}
+}
+
+// dBFS will convert a power (where 1 is full scale) to dBFS. Anything
+// quieter than statsHistogramMin, including digital silence, is reported as
+// statsHistogramMin, so reports never contain an infinity.
+func dBFS(power float64) float64 {
+	if power <= 0 {
+		return statsHistogramMin
+	}
+	return math.Max(10*math.Log10(power), statsHistogramMin)
+}
+
+// Report will return the statistics of all samples added so far.
+func (st *Stats) Report() StatsReport {
+	if st.samples == 0 {
+		return StatsReport{}
+	}
+
+	var (
+		n     = float64(st.samples)
+		meanI = st.sumI / n
+		meanQ = st.sumQ / n
+		varI  = st.sumII/n - meanI*meanI
+		varQ  = st.sumQQ/n - meanQ*meanQ
+		cov   = st.sumIQ/n - meanI*meanQ
+	)
+
+	report := StatsReport{
+		Samples:   st.samples,
+		MeanPower: dBFS((st.sumII + st.sumQQ) / n),
+		PeakPower: dBFS(st.peak),
+		DCOffsetI: meanI,
+		DCOffsetQ: meanQ,
+		Clipped:   float64(st.clipped) / n,
+	}
+
+	if varI > 0 && varQ > 0 {
+		report.GainImbalance = 10 * math.Log10(varI/varQ)
+		report.PhaseImbalance = math.Asin(cov/math.Sqrt(varI*varQ)) * 180 / math.Pi
+	}
+
+	histogram := append([]int64{}, st.histogram...)
+	if st.blockSamples > 0 {
+		histogram[st.histogramBin(st.blockPower/float64(st.blockSamples))]++
+	}
+	var blocks int64
+	for _, count := range histogram {
+		blocks += count
+	}
+	threshold := int64(math.Ceil(float64(blocks) * statsNoiseFloorPercentile))
+	var seen int64
+	for bin, count := range histogram {
+		seen += count
+		if seen >= threshold && count > 0 {
+			report.NoiseFloor = statsHistogramMin + (float64(bin)+0.5)*statsHistogramStep
+			break
+		}
+	}
+
+	return report
+}
+
+// StatsReader is an sdr.Reader that accumulates Stats for every sample
+// read through it.
+type StatsReader struct {
+	sdr.Reader
+	stats *Stats
+}
+
+// NewStatsReader will wrap the provided sdr.Reader, such as one returned by
+// Reader, and accumulate statistics for every sample read.
+func NewStatsReader(r sdr.Reader) *StatsReader {
+	return &StatsReader{
+		Reader: r,
+		stats:  NewStats(r.SampleFormat()),
+	}
+}
+
+// Read implements the sdr.Reader interface.
+func (sr *StatsReader) Read(s sdr.Samples) (int, error) {
+	n, err := sr.Reader.Read(s)
+	if n > 0 {
+		if serr := sr.stats.Add(s.Slice(0, n)); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
+
+// Report will return the statistics of all samples read so far.
+func (sr *StatsReader) Report() StatsReport {
+	return sr.stats.Report()
+}
+
+// vim: foldmethod=marker
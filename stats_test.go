@@ -0,0 +1,113 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func TestStatsTone(t *testing.T) {
+	stats := rfcap.NewStats(sdr.SampleFormatC64)
+
+	tone := make(sdr.SamplesC64, 4096)
+	for i := range tone {
+		tone[i] = complex64(0.5*cmplx.Rect(1, 2*math.Pi*float64(i)/64)) + complex(0.1, -0.05)
+	}
+	assert.NoError(t, stats.Add(tone))
+
+	report := stats.Report()
+	assert.Equal(t, int64(4096), report.Samples)
+	assert.InDelta(t, 0.1, report.DCOffsetI, 1e-6)
+	assert.InDelta(t, -0.05, report.DCOffsetQ, 1e-6)
+	assert.InDelta(t, 0, report.GainImbalance, 1e-3)
+	assert.InDelta(t, 0, report.PhaseImbalance, 1e-3)
+	assert.InDelta(t, 10*math.Log10(0.25+0.0125), report.MeanPower, 0.01)
+	assert.InDelta(t, report.MeanPower, report.NoiseFloor, 0.1)
+	assert.Equal(t, float64(0), report.Clipped)
+}
+
+func TestStatsImbalance(t *testing.T) {
+	stats := rfcap.NewStats(sdr.SampleFormatC64)
+
+	phase := 5 * math.Pi / 180
+	tone := make(sdr.SamplesC64, 4096)
+	for i := range tone {
+		theta := 2 * math.Pi * float64(i) / 64
+		tone[i] = complex(float32(math.Cos(theta)), float32(0.5*math.Sin(theta+phase)))
+	}
+	assert.NoError(t, stats.Add(tone))
+
+	report := stats.Report()
+	assert.InDelta(t, 10*math.Log10(4), report.GainImbalance, 0.01)
+	assert.InDelta(t, 5, report.PhaseImbalance, 0.1)
+}
+
+func TestStatsReaderClipping(t *testing.T) {
+	in := captureBuffer(t, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CenterFrequency: rf.MustParseHz("100MHz"),
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI8,
+		Endianness:      binary.LittleEndian,
+	}, sdr.SamplesI8{{127, 0}, {0, 0}, {0, -128}, {10, 10}})
+
+	r, _, err := rfcap.Reader(in)
+	assert.NoError(t, err)
+	sr := rfcap.NewStatsReader(r)
+
+	buf := make(sdr.SamplesI8, 3)
+	for {
+		if _, err := sr.Read(buf); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+	}
+
+	report := sr.Report()
+	assert.Equal(t, int64(4), report.Samples)
+	assert.Equal(t, 0.5, report.Clipped)
+}
+
+func TestStatsSilence(t *testing.T) {
+	stats := rfcap.NewStats(sdr.SampleFormatC64)
+	assert.NoError(t, stats.Add(make(sdr.SamplesC64, 100)))
+
+	report := stats.Report()
+	assert.Equal(t, float64(-200), report.MeanPower)
+	assert.False(t, math.IsInf(report.NoiseFloor, 0))
+}
+
+func TestStatsFormatMismatch(t *testing.T) {
+	stats := rfcap.NewStats(sdr.SampleFormatC64)
+	assert.Equal(t, sdr.ErrSampleFormatMismatch, stats.Add(make(sdr.SamplesI16, 1)))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,169 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+
+	"hz.tools/sdr"
+)
+
+// convertReader will convert samples read from the underlying sdr.Reader
+// into a different sample format. This is a bit like stream.ConvertReader,
+// except it will not drop a trailing partial buffer at the end of a file,
+// which matters a lot more for captures than it does for live radios.
+type convertReader struct {
+	r      sdr.Reader
+	buf    sdr.Samples
+	format sdr.SampleFormat
+}
+
+// newConvertReader will return an sdr.Reader that will return samples in
+// the requested format. If the sdr.Reader is already in the target format,
+// it will be returned as-is.
+func newConvertReader(r sdr.Reader, format sdr.SampleFormat) (sdr.Reader, error) {
+	if r.SampleFormat() == format {
+		return r, nil
+	}
+
+	buf, err := sdr.MakeSamples(r.SampleFormat(), 32*1024)
+	if err != nil {
+		return nil, err
+	}
+
+	return &convertReader{
+		r:      r,
+		buf:    buf,
+		format: format,
+	}, nil
+}
+
+func (cr *convertReader) SampleRate() uint {
+	return cr.r.SampleRate()
+}
+
+func (cr *convertReader) SampleFormat() sdr.SampleFormat {
+	return cr.format
+}
+
+func (cr *convertReader) Read(s sdr.Samples) (int, error) {
+	if s.Format() != cr.format {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+
+	n := s.Length()
+	if n > cr.buf.Length() {
+		n = cr.buf.Length()
+	}
+
+	i, err := cr.r.Read(cr.buf.Slice(0, n))
+	if i > 0 {
+		if _, cerr := sdr.ConvertBuffer(s, cr.buf.Slice(0, i)); cerr != nil {
+			return 0, cerr
+		}
+	}
+	return i, err
+}
+
+// readSamples will read from the sdr.Reader until the buffer is full, or
+// until the stream ends. Unlike sdr.ReadFull, a short read at the end of the
+// stream returns the samples read along with io.EOF, which makes it a lot
+// easier to handle the end of a capture.
+func readSamples(r sdr.Reader, s sdr.Samples) (int, error) {
+	var n int
+	for n < s.Length() {
+		i, err := r.Read(s.Slice(n, s.Length()))
+		n += i
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// makeSilence will allocate a buffer of samples that represent no signal at
+// all. This is usually all zeros, except for uint8 samples, which are
+// centered around 127.5 rather than 0.
+func makeSilence(format sdr.SampleFormat, length int) (sdr.Samples, error) {
+	buf, err := sdr.MakeSamples(format, length)
+	if err != nil {
+		return nil, err
+	}
+	if format == sdr.SampleFormatU8 {
+		if _, err := sdr.ConvertBuffer(buf, make(sdr.SamplesC64, length)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// concatReader will read from each sdr.Reader in turn until it returns an
+// io.EOF, and move on to the next one. This is here because sdr.MultiReader
+// will index past the end of its readers after the last one is exhausted.
+type concatReader struct {
+	readers []sdr.Reader
+}
+
+// newConcatReader will create a new sdr.Reader that reads each of the
+// provided readers back to back. All readers must share the same sample rate
+// and sample format.
+func newConcatReader(readers ...sdr.Reader) (sdr.Reader, error) {
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("rfcap: no readers to concatenate")
+	}
+
+	for _, r := range readers[1:] {
+		if r.SampleFormat() != readers[0].SampleFormat() {
+			return nil, sdr.ErrSampleFormatMismatch
+		}
+		if r.SampleRate() != readers[0].SampleRate() {
+			return nil, fmt.Errorf("rfcap: sample rate mismatch")
+		}
+	}
+
+	return &concatReader{readers: readers}, nil
+}
+
+func (cr *concatReader) SampleRate() uint {
+	return cr.readers[0].SampleRate()
+}
+
+func (cr *concatReader) SampleFormat() sdr.SampleFormat {
+	return cr.readers[0].SampleFormat()
+}
+
+func (cr *concatReader) Read(s sdr.Samples) (int, error) {
+	for len(cr.readers) > 1 {
+		n, err := cr.readers[0].Read(s)
+		if err == io.EOF {
+			cr.readers = cr.readers[1:]
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+	return cr.readers[0].Read(s)
+}
+
+// vim: foldmethod=marker
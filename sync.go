@@ -0,0 +1,375 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// A synced stream is a series of segments, each of which starts with a sync
+// block, followed by up to the segment length of samples. This lets a
+// reader that joins partway through (such as on a FIFO or serial link) scan
+// forward to the next sync block and start decoding from there.
+//
+// Each sync block is laid out as follows, with all integers little endian:
+//
+//	bytes 0-7    the sync word, 1A CF FC 1D 52 46 43 53
+//	bytes 8-15   index of the first sample after this block
+//	bytes 16-19  number of samples before the next sync block
+//	bytes 20-23  length of the marshaled Header that follows
+//	bytes 24-27  CRC-32 (IEEE) of bytes 8-23 and the marshaled Header
+//
+// followed by the marshaled Header, whose CaptureTime is the time of the
+// first sample after the block.
+const (
+	syncBlockLength = 28
+
+	// syncMaxHeader is the largest Header a SyncReader will accept, which
+	// keeps a corrupt length from making it wait on a huge read.
+	syncMaxHeader = 64 * 1024
+)
+
+var syncWord = []byte{0x1A, 0xCF, 0xFC, 0x1D, 'R', 'F', 'C', 'S'}
+
+// SyncWriter is an sdr.Writer that writes a synced stream, inserting a sync
+// block with a snapshot of the Header every interval samples.
+//
+// Since each segment has to make sense on its own, samples are always
+// written uncompressed, even if the Header says the capture was compressed.
+type SyncWriter struct {
+	out      io.Writer
+	header   Header
+	interval int
+	w        sdr.Writer
+
+	sample    uint64
+	remaining int
+}
+
+// NewSyncWriter will create a SyncWriter of samples described by the
+// Header, with a sync block every interval samples. If interval is zero, a
+// sync block is written every second's worth of samples.
+func NewSyncWriter(out io.Writer, header Header, interval int) (*SyncWriter, error) {
+	header.Compressed = false
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = int(header.SampleRate)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("rfcap: sync interval must be positive")
+	}
+
+	return &SyncWriter{
+		out:      out,
+		header:   header,
+		interval: interval,
+		w: sdr.ByteWriter(
+			out, header.Endianness, header.SampleRate, header.SampleFormat,
+		),
+	}, nil
+}
+
+// SampleRate implements the sdr.Writer interface.
+func (sw *SyncWriter) SampleRate() uint {
+	return sw.header.SampleRate
+}
+
+// SampleFormat implements the sdr.Writer interface.
+func (sw *SyncWriter) SampleFormat() sdr.SampleFormat {
+	return sw.header.SampleFormat
+}
+
+// sync will write a sync block for the next segment.
+func (sw *SyncWriter) sync() error {
+	hdr := sw.header
+	hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(int64(sw.sample)))
+	marshaled, err := hdr.Marshal()
+	if err != nil {
+		return err
+	}
+
+	block := make([]byte, syncBlockLength, syncBlockLength+len(marshaled))
+	copy(block, syncWord)
+	binary.LittleEndian.PutUint64(block[8:16], sw.sample)
+	binary.LittleEndian.PutUint32(block[16:20], uint32(sw.interval))
+	binary.LittleEndian.PutUint32(block[20:24], uint32(len(marshaled)))
+	block = append(block, marshaled...)
+
+	crc := crc32.NewIEEE()
+	crc.Write(block[8:24])
+	crc.Write(marshaled)
+	binary.LittleEndian.PutUint32(block[24:28], crc.Sum32())
+
+	if _, err := sw.out.Write(block); err != nil {
+		return err
+	}
+	sw.remaining = sw.interval
+	return nil
+}
+
+// Write implements the sdr.Writer interface.
+func (sw *SyncWriter) Write(s sdr.Samples) (int, error) {
+	if s.Format() != sw.header.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+
+	var written int
+	for written < s.Length() {
+		if sw.remaining == 0 {
+			if err := sw.sync(); err != nil {
+				return written, err
+			}
+		}
+
+		end := written + sw.remaining
+		if end > s.Length() {
+			end = s.Length()
+		}
+		n, err := sw.w.Write(s.Slice(written, end))
+		written += n
+		sw.sample += uint64(n)
+		sw.remaining -= n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// SyncReader is an sdr.Reader of a synced stream written by a SyncWriter,
+// which can be started at any byte of the stream.
+//
+// The SyncReader will scan forward to the first sync block it can find,
+// and start from there. If a sync block isn't where it's expected to be,
+// such as when bytes were lost, it will scan forward to the next one, and
+// replace any samples skipped over with silence, recorded as an EventGap.
+type SyncReader struct {
+	in     *bufio.Reader
+	header Header
+	body   sdr.Reader
+
+	start     uint64
+	next      uint64
+	remaining int64
+	gap       uint64
+	silence   sdr.Samples
+	events    []Event
+}
+
+// NewSyncReader will scan the io.Reader for the first sync block, and
+// return a SyncReader positioned just after it.
+func NewSyncReader(in io.Reader) (*SyncReader, error) {
+	sr := &SyncReader{
+		in: bufio.NewReaderSize(in, syncBlockLength+syncMaxHeader),
+	}
+
+	hdr, sample, length, err := sr.scan()
+	if err != nil {
+		return nil, err
+	}
+	if sr.silence, err = makeSilence(hdr.SampleFormat, 32*1024); err != nil {
+		return nil, err
+	}
+	sr.header = hdr
+	sr.body = newByteReader(sr.in, hdr.Endianness, hdr.SampleRate, hdr.SampleFormat)
+	sr.start = sample
+	sr.next = sample
+	sr.remaining = length
+	return sr, nil
+}
+
+// Header will return the Header from the first sync block read, which has
+// the time of the first sample returned.
+func (sr *SyncReader) Header() Header {
+	return sr.header
+}
+
+// Events will return an EventGap for each time the SyncReader had to skip
+// forward to find a sync block. The Sample of each Event is in terms of this
+// stream.
+func (sr *SyncReader) Events() []Event {
+	return append([]Event{}, sr.events...)
+}
+
+// SampleRate implements the sdr.Reader interface.
+func (sr *SyncReader) SampleRate() uint {
+	return sr.header.SampleRate
+}
+
+// SampleFormat implements the sdr.Reader interface.
+func (sr *SyncReader) SampleFormat() sdr.SampleFormat {
+	return sr.header.SampleFormat
+}
+
+// parse will try to parse a sync block at the current position, returning
+// false if there isn't a valid one there. Nothing is consumed unless the
+// block is valid.
+func (sr *SyncReader) parse() (Header, uint64, int64, bool, error) {
+	block, err := sr.in.Peek(syncBlockLength)
+	if err != nil {
+		return Header{}, 0, 0, false, err
+	}
+	if !bytes.Equal(block[:8], syncWord) {
+		return Header{}, 0, 0, false, nil
+	}
+
+	var (
+		sample    = binary.LittleEndian.Uint64(block[8:16])
+		length    = binary.LittleEndian.Uint32(block[16:20])
+		headerLen = binary.LittleEndian.Uint32(block[20:24])
+		want      = binary.LittleEndian.Uint32(block[24:28])
+	)
+	if headerLen > syncMaxHeader || length == 0 {
+		return Header{}, 0, 0, false, nil
+	}
+
+	block, err = sr.in.Peek(syncBlockLength + int(headerLen))
+	if err != nil {
+		return Header{}, 0, 0, false, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(block[8:24])
+	crc.Write(block[syncBlockLength:])
+	if crc.Sum32() != want {
+		return Header{}, 0, 0, false, nil
+	}
+
+	hdr := Header{}
+	if err := hdr.Unmarshal(block[syncBlockLength:]); err != nil {
+		return Header{}, 0, 0, false, nil
+	}
+	if _, err := sr.in.Discard(len(block)); err != nil {
+		return Header{}, 0, 0, false, err
+	}
+	return hdr, sample, int64(length), true, nil
+}
+
+// scan will skip forward until a valid sync block is found.
+func (sr *SyncReader) scan() (Header, uint64, int64, error) {
+	for {
+		hdr, sample, length, ok, err := sr.parse()
+		if err != nil {
+			return Header{}, 0, 0, err
+		}
+		if ok {
+			return hdr, sample, length, nil
+		}
+
+		// Skip ahead to the next place the sync word could start.
+		buf, err := sr.in.Peek(sr.in.Buffered())
+		if err != nil {
+			return Header{}, 0, 0, err
+		}
+		skip := 1
+		if i := bytes.IndexByte(buf[1:], syncWord[0]); i >= 0 {
+			skip += i
+		} else if len(buf) > 1 {
+			skip = len(buf)
+		}
+		if _, err := sr.in.Discard(skip); err != nil {
+			return Header{}, 0, 0, err
+		}
+	}
+}
+
+// resync will read the sync block for the next segment, scanning forward
+// for one if it's not where it should be.
+func (sr *SyncReader) resync() error {
+	hdr, sample, length, ok, err := sr.parse()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if hdr, sample, length, err = sr.scan(); err != nil {
+			return err
+		}
+	}
+
+	if hdr.SampleFormat != sr.header.SampleFormat || hdr.SampleRate != sr.header.SampleRate {
+		return fmt.Errorf("rfcap: synced stream changed format mid-stream")
+	}
+	if sample > sr.next {
+		sr.events = append(sr.events, Event{
+			Kind:   EventGap,
+			Sample: sr.next - sr.start,
+			Time:   time.Now(),
+			Length: sample - sr.next,
+		})
+		sr.gap += sample - sr.next
+	}
+	sr.next = sample
+	sr.remaining = length
+
+	// Anything half read out of the last segment is garbage now.
+	sr.body = newByteReader(sr.in, hdr.Endianness, hdr.SampleRate, hdr.SampleFormat)
+	return nil
+}
+
+// Read implements the sdr.Reader interface.
+func (sr *SyncReader) Read(s sdr.Samples) (int, error) {
+	if s.Format() != sr.header.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if s.Length() == 0 {
+		return 0, nil
+	}
+
+	if sr.gap == 0 && sr.remaining == 0 {
+		if err := sr.resync(); err != nil {
+			return 0, err
+		}
+	}
+
+	if sr.gap > 0 {
+		n := s.Length()
+		if uint64(n) > sr.gap {
+			n = int(sr.gap)
+		}
+		if n > sr.silence.Length() {
+			n = sr.silence.Length()
+		}
+		if _, err := sdr.CopySamples(s.Slice(0, n), sr.silence.Slice(0, n)); err != nil {
+			return 0, err
+		}
+		sr.gap -= uint64(n)
+		return n, nil
+	}
+
+	if int64(s.Length()) > sr.remaining {
+		s = s.Slice(0, int(sr.remaining))
+	}
+	n, err := sr.body.Read(s)
+	sr.remaining -= int64(n)
+	sr.next += uint64(n)
+	return n, err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,143 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// syncStream will write 1000 big endian I16 samples with a testHeader, where
+// the real part of each sample is its index, with a sync block every 100
+// samples.
+func syncStream(t *testing.T) []byte {
+	buf := bytes.Buffer{}
+	hdr := testHeader(sdr.SampleFormatI16)
+	hdr.Endianness = binary.BigEndian
+	w, err := rfcap.NewSyncWriter(&buf, hdr, 100)
+	assert.NoError(t, err)
+
+	samples := make(sdr.SamplesI16, 1000)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i), 0}
+	}
+	// Write in uneven chunks, to cross segment boundaries mid-write.
+	for i := 0; i < len(samples); i += 333 {
+		end := i + 333
+		if end > len(samples) {
+			end = len(samples)
+		}
+		n, err := w.Write(samples[i:end])
+		assert.NoError(t, err)
+		assert.Equal(t, end-i, n)
+	}
+	return buf.Bytes()
+}
+
+func readSync(t *testing.T, in io.Reader) (*rfcap.SyncReader, sdr.SamplesI16) {
+	r, err := rfcap.NewSyncReader(in)
+	assert.NoError(t, err)
+
+	out := sdr.SamplesI16{}
+	buf := make(sdr.SamplesI16, 64)
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+	}
+	return r, out
+}
+
+func TestSync(t *testing.T) {
+	r, samples := readSync(t, bytes.NewReader(syncStream(t)))
+	assert.Equal(t, testEpoch, r.Header().CaptureTime)
+	assert.Equal(t, 100*rf.MHz, r.Header().CenterFrequency)
+	assert.Empty(t, r.Events())
+
+	assert.Equal(t, 1000, len(samples))
+	for i, s := range samples {
+		assert.Equal(t, int16(i), s[0])
+	}
+}
+
+// syncSegment is the length of each segment written by syncStream: a sync
+// block, a Header, and 100 I16 samples.
+const syncSegment = 28 + 48 + 100*4
+
+func TestSyncMidStream(t *testing.T) {
+	stream := syncStream(t)
+
+	// Start partway into the third segment, so the first sync block found
+	// is the one in front of sample 300.
+	r, samples := readSync(t, bytes.NewReader(stream[2*syncSegment+123:]))
+	assert.Equal(t, testEpoch.Add(300*time.Millisecond), r.Header().CaptureTime)
+	assert.Empty(t, r.Events())
+
+	assert.Equal(t, 700, len(samples))
+	for i, s := range samples {
+		assert.Equal(t, int16(i+300), s[0])
+	}
+}
+
+func TestSyncLost(t *testing.T) {
+	stream := syncStream(t)
+
+	// Drop the fifth segment, and put some junk (including a bogus sync
+	// word) where it was.
+	lossy := append([]byte{}, stream[:4*syncSegment]...)
+	lossy = append(lossy, 0x1A, 0xCF, 0xFC, 0x1D, 'R', 'F', 'C', 'S', 0xFF, 0x1A)
+	lossy = append(lossy, stream[5*syncSegment:]...)
+
+	r, samples := readSync(t, bytes.NewReader(lossy))
+	assert.Equal(t, 1000, len(samples))
+	for i, s := range samples {
+		if i >= 400 && i < 500 {
+			assert.Equal(t, int16(0), s[0])
+			continue
+		}
+		assert.Equal(t, int16(i), s[0])
+	}
+
+	events := r.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.Equal(t, uint64(400), events[0].Sample)
+	assert.Equal(t, uint64(100), events[0].Length)
+}
+
+func TestSyncNoSyncWord(t *testing.T) {
+	_, err := rfcap.NewSyncReader(bytes.NewReader(make([]byte, 1024)))
+	assert.Equal(t, io.EOF, err)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,326 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap/internal/dsp"
+	"hz.tools/sdr"
+)
+
+// defaultFilterTaps is the number of taps used by LowPass and BandPass if
+// none are given.
+const defaultFilterTaps = 129
+
+// Transform is a change to a stream of samples, such as a frequency shift or
+// decimation, along with the change it makes to the Header describing those
+// samples. Transforms always operate on complex64 samples.
+type Transform interface {
+	// Header will return the Header of the samples produced by this
+	// Transform, given the Header of its input.
+	Header(in Header) (Header, error)
+
+	// Reader will wrap an sdr.Reader of complex64 samples described by the
+	// provided Header.
+	Reader(in Header, r sdr.Reader) (sdr.Reader, error)
+}
+
+// Transformer will apply the Transforms, in order, to the sdr.Reader of
+// samples described by the provided Header, returning the transformed
+// samples as complex64 along with their new Header.
+func Transformer(hdr Header, r sdr.Reader, transforms ...Transform) (sdr.Reader, Header, error) {
+	r, err := newConvertReader(r, sdr.SampleFormatC64)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	for _, transform := range transforms {
+		next, err := transform.Header(hdr)
+		if err != nil {
+			return nil, Header{}, err
+		}
+		if r, err = transform.Reader(hdr, r); err != nil {
+			return nil, Header{}, err
+		}
+		hdr = next
+	}
+	return r, hdr, nil
+}
+
+// Apply will read a capture from the io.Reader, apply the Transforms in
+// order, and write the result to the io.Writer as a new capture in the same
+// sample format as the input. Samples that end up out of range for an
+// integer sample format are clipped. The new Header is returned.
+func Apply(in io.Reader, out io.Writer, transforms ...Transform) (Header, error) {
+	r, hdr, err := Reader(in)
+	if err != nil {
+		return Header{}, err
+	}
+	format := hdr.SampleFormat
+
+	r, hdr, err = Transformer(hdr, r, transforms...)
+	if err != nil {
+		return Header{}, err
+	}
+	hdr.SampleFormat = format
+
+	if err := writeC64(out, hdr, r); err != nil {
+		return Header{}, err
+	}
+	return hdr, nil
+}
+
+// writeC64 will write a capture with the provided Header to the io.Writer,
+// converting the complex64 samples read from the sdr.Reader to the sample
+// format of the Header. Samples that are out of range for an integer sample
+// format are clipped.
+func writeC64(out io.Writer, hdr Header, r sdr.Reader) error {
+	if hdr.SampleFormat != sdr.SampleFormatC64 {
+		r = &c64Reader{r: r, proc: func(iq []complex64) { dsp.Clamp(iq) }}
+	}
+	r, err := newConvertReader(r, hdr.SampleFormat)
+	if err != nil {
+		return err
+	}
+
+	w, err := Writer(out, hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := copySamples(w, r, -1); err != nil {
+		return err
+	}
+	return Flush(w)
+}
+
+// c64Reader is an sdr.Reader that will process complex64 samples in place as
+// they're read.
+type c64Reader struct {
+	r    sdr.Reader
+	proc func([]complex64)
+}
+
+func (cr *c64Reader) SampleRate() uint {
+	return cr.r.SampleRate()
+}
+
+func (cr *c64Reader) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatC64
+}
+
+func (cr *c64Reader) Read(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesC64)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	n, err := cr.r.Read(iq)
+	cr.proc(iq[:n])
+	return n, err
+}
+
+// firFilter is a streaming filter, such as a dsp.FIR or dsp.Cascade.
+type firFilter interface {
+	OutputLength(n int) int
+	Filter(dst, src []complex64) int
+}
+
+// firReader is an sdr.Reader that will run complex64 samples through an FIR
+// filter, decimating them if the filter is set up to do so.
+type firReader struct {
+	r          sdr.Reader
+	fir        firFilter
+	sampleRate uint
+	buf        sdr.SamplesC64
+	err        error
+}
+
+func newFIRReader(r sdr.Reader, fir firFilter, decimation int) *firReader {
+	return &firReader{
+		r:          r,
+		fir:        fir,
+		sampleRate: r.SampleRate() / uint(decimation),
+		buf:        make(sdr.SamplesC64, 32*1024),
+	}
+}
+
+func (fr *firReader) SampleRate() uint {
+	return fr.sampleRate
+}
+
+func (fr *firReader) SampleFormat() sdr.SampleFormat {
+	return sdr.SampleFormatC64
+}
+
+func (fr *firReader) Read(s sdr.Samples) (int, error) {
+	iq, ok := s.(sdr.SamplesC64)
+	if !ok {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+	if len(iq) == 0 {
+		return 0, nil
+	}
+
+	for fr.err == nil {
+		// Don't read any more than we can write out to iq.
+		n := len(fr.buf)
+		for n > 1 && fr.fir.OutputLength(n) > len(iq) {
+			n /= 2
+		}
+
+		var i int
+		i, fr.err = fr.r.Read(fr.buf[:n])
+		if out := fr.fir.Filter(iq, fr.buf[:i]); out > 0 {
+			return out, nil
+		}
+	}
+	return 0, fr.err
+}
+
+// transformFunc is a Transform built out of a pair of functions.
+type transformFunc struct {
+	header func(Header) (Header, error)
+	reader func(Header, sdr.Reader) (sdr.Reader, error)
+}
+
+func (tf transformFunc) Header(in Header) (Header, error) {
+	return tf.header(in)
+}
+
+func (tf transformFunc) Reader(in Header, r sdr.Reader) (sdr.Reader, error) {
+	return tf.reader(in, r)
+}
+
+// Shift will return a Transform that moves the center frequency of a capture
+// by offset, so that whatever was at offset from the old center frequency is
+// now at 0 Hz.
+func Shift(offset rf.Hz) Transform {
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			in.CenterFrequency += offset
+			return in, nil
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			mixer := dsp.NewMixer(-float64(offset), float64(in.SampleRate))
+			return &c64Reader{r: r, proc: mixer.Mix}, nil
+		},
+	}
+}
+
+// ShiftTo will return a Transform that retunes a capture to a new center
+// frequency, like Shift.
+func ShiftTo(freq rf.Hz) Transform {
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			return Shift(freq - in.CenterFrequency).Header(in)
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			return Shift(freq-in.CenterFrequency).Reader(in, r)
+		},
+	}
+}
+
+// Gain will return a Transform that changes the amplitude of the samples by
+// the provided number of dB.
+func Gain(db float64) Transform {
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			return in, nil
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			gain := float32(math.Pow(10, db/20))
+			return &c64Reader{r: r, proc: func(iq []complex64) {
+				dsp.Scale(iq, gain)
+			}}, nil
+		},
+	}
+}
+
+// Decimate will return a Transform that low pass filters the samples to
+// avoid aliasing, and then keeps one of every factor samples. The sample rate
+// of the capture must be a multiple of factor.
+func Decimate(factor uint) Transform {
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			if factor == 0 || in.SampleRate%factor != 0 {
+				return Header{}, fmt.Errorf(
+					"rfcap: can't decimate a sample rate of %d by %d", in.SampleRate, factor,
+				)
+			}
+			in.SampleRate /= factor
+			return in, nil
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			taps := dsp.LowPass(0.4/float64(factor), 32*int(factor)+1)
+			return newFIRReader(r, dsp.NewFIR(taps, int(factor)), int(factor)), nil
+		},
+	}
+}
+
+// filterBand will return the pass band (as an offset from the center
+// frequency) as cycles per sample, checking it fits in the capture.
+func filterBand(in Header, low, high rf.Hz) (float64, float64, error) {
+	nyquist := rf.Hz(in.SampleRate) / 2
+	if low >= high {
+		return 0, 0, fmt.Errorf("rfcap: filter low edge %s is above the high edge %s", low, high)
+	}
+	if low < -nyquist || high > nyquist {
+		return 0, 0, fmt.Errorf(
+			"rfcap: filter from %s to %s is outside the capture (±%s)", low, high, nyquist,
+		)
+	}
+	rate := float64(in.SampleRate)
+	return float64(low) / rate, float64(high) / rate, nil
+}
+
+// BandPass will return a Transform that filters out everything outside of
+// low to high, which are offsets from the center frequency of the capture. If
+// taps is 0, a reasonable default is used. Like any FIR filter, this delays
+// the samples by half the number of taps.
+func BandPass(low, high rf.Hz, taps int) Transform {
+	if taps <= 0 {
+		taps = defaultFilterTaps
+	}
+	return transformFunc{
+		header: func(in Header) (Header, error) {
+			_, _, err := filterBand(in, low, high)
+			return in, err
+		},
+		reader: func(in Header, r sdr.Reader) (sdr.Reader, error) {
+			lo, hi, err := filterBand(in, low, high)
+			if err != nil {
+				return nil, err
+			}
+			return newFIRReader(r, dsp.NewFIR(dsp.BandPass(lo, hi, taps), 1), 1), nil
+		},
+	}
+}
+
+// LowPass will return a Transform that filters out everything further than
+// cutoff from the center frequency of the capture. If taps is 0, a
+// reasonable default is used.
+func LowPass(cutoff rf.Hz, taps int) Transform {
+	return BandPass(-cutoff, cutoff, taps)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,192 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// transformCapture is a testCapture of a tone at freq Hz.
+func transformCapture(t *testing.T, freq float64, length int) *bytes.Buffer {
+	tone := make(sdr.SamplesC64, length)
+	for i := range tone {
+		tone[i] = complex64(0.5 * cmplx.Rect(1, 2*math.Pi*freq*float64(i)/1000))
+	}
+	return testCapture(t, tone, nil)
+}
+
+func readAllC64(t *testing.T, in io.Reader) (sdr.SamplesC64, rfcap.Header) {
+	r, hdr, err := rfcap.Reader(in)
+	assert.NoError(t, err)
+	samples := sdr.SamplesC64{}
+	buf := make(sdr.SamplesC64, 1024)
+	for {
+		n, err := r.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples, hdr
+		}
+		assert.NoError(t, err)
+	}
+}
+
+func meanPower(iq sdr.SamplesC64) float64 {
+	var sum float64
+	for _, v := range iq {
+		sum += float64(real(v)*real(v) + imag(v)*imag(v))
+	}
+	return sum / float64(len(iq))
+}
+
+func TestShift(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(transformCapture(t, 100, 1000), out, rfcap.Shift(100))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz+100, hdr.CenterFrequency)
+
+	samples, outHdr := readAllC64(t, out)
+	assert.Equal(t, hdr.CenterFrequency, outHdr.CenterFrequency)
+	assert.Equal(t, 1000, len(samples))
+	for _, v := range samples {
+		assert.InDelta(t, 0.5, real(v), 1e-3)
+		assert.InDelta(t, 0, imag(v), 1e-3)
+	}
+}
+
+func TestShiftTo(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(transformCapture(t, -200, 1000), out, rfcap.ShiftTo(100*rf.MHz-200))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz-200, hdr.CenterFrequency)
+
+	samples, _ := readAllC64(t, out)
+	assert.InDelta(t, 0.5, real(samples[500]), 1e-3)
+	assert.InDelta(t, 0, imag(samples[500]), 1e-3)
+}
+
+func TestDecimate(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(transformCapture(t, 20, 4000), out, rfcap.Decimate(4))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(250), hdr.SampleRate)
+
+	samples, _ := readAllC64(t, out)
+	assert.Equal(t, 1000, len(samples))
+	assert.InDelta(t, 0.25, meanPower(samples[100:]), 0.01)
+
+	// A tone above the new nyquist frequency is filtered out, rather than
+	// aliased.
+	out = &bytes.Buffer{}
+	_, err = rfcap.Apply(transformCapture(t, 300, 4000), out, rfcap.Decimate(4))
+	assert.NoError(t, err)
+	samples, _ = readAllC64(t, out)
+	assert.True(t, meanPower(samples[100:]) < 1e-4)
+}
+
+func TestDecimateBadFactor(t *testing.T) {
+	_, err := rfcap.Apply(transformCapture(t, 20, 100), &bytes.Buffer{}, rfcap.Decimate(3))
+	assert.Error(t, err)
+	_, err = rfcap.Apply(transformCapture(t, 20, 100), &bytes.Buffer{}, rfcap.Decimate(0))
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	for _, c := range []struct {
+		transform rfcap.Transform
+		freq      float64
+		pass      bool
+	}{
+		{rfcap.LowPass(100, 0), 50, true},
+		{rfcap.LowPass(100, 0), -300, false},
+		{rfcap.BandPass(100, 300, 0), 200, true},
+		{rfcap.BandPass(100, 300, 0), -200, false},
+	} {
+		out := &bytes.Buffer{}
+		_, err := rfcap.Apply(transformCapture(t, c.freq, 2000), out, c.transform)
+		assert.NoError(t, err)
+		samples, _ := readAllC64(t, out)
+		if c.pass {
+			assert.InDelta(t, 0.25, meanPower(samples[200:]), 0.01, "%g Hz", c.freq)
+		} else {
+			assert.True(t, meanPower(samples[200:]) < 1e-4, "%g Hz", c.freq)
+		}
+	}
+}
+
+func TestFilterOutOfRange(t *testing.T) {
+	_, err := rfcap.Apply(transformCapture(t, 20, 100), &bytes.Buffer{}, rfcap.LowPass(600, 0))
+	assert.Error(t, err)
+	_, err = rfcap.Apply(transformCapture(t, 20, 100), &bytes.Buffer{}, rfcap.BandPass(200, 100, 0))
+	assert.Error(t, err)
+}
+
+func TestGainClips(t *testing.T) {
+	in := captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+	}, sdr.SamplesI16{{1000, -1000}, {30000, -30000}})
+
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(in, out, rfcap.Gain(6))
+	assert.NoError(t, err)
+	assert.Equal(t, sdr.SampleFormatI16, hdr.SampleFormat)
+
+	r, _, err := rfcap.Reader(out)
+	assert.NoError(t, err)
+	samples := make(sdr.SamplesI16, 2)
+	_, err = sdr.ReadFull(r, samples)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1995, samples[0][0], 2)
+	assert.InDelta(t, -1995, samples[0][1], 2)
+	assert.Equal(t, [2]int16{32767, -32767}, samples[1])
+}
+
+func TestTransformChain(t *testing.T) {
+	out := &bytes.Buffer{}
+	hdr, err := rfcap.Apply(
+		transformCapture(t, 120, 4000), out,
+		rfcap.Shift(100),
+		rfcap.LowPass(50, 0),
+		rfcap.Decimate(2),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*rf.MHz+100, hdr.CenterFrequency)
+	assert.Equal(t, uint(500), hdr.SampleRate)
+
+	samples, _ := readAllC64(t, out)
+	assert.Equal(t, 2000, len(samples))
+	assert.InDelta(t, 0.25, meanPower(samples[200:]), 0.01)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,248 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"hz.tools/sdr"
+)
+
+// TransmitOptions controls how a capture is sent out through a Transmitter.
+type TransmitOptions struct {
+	// Repeat is the number of times the capture will be transmitted. If this
+	// is 0, the capture is transmitted once. If this is negative, the capture
+	// will be transmitted until an error is encountered.
+	//
+	// Repeating a capture requires the io.Reader to also be an io.Seeker.
+	Repeat int
+
+	// Gap is the amount of silence to transmit between each repetition of
+	// the capture.
+	Gap time.Duration
+
+	// OnUnderrun, if not nil, will be called with an EventUnderrun each time
+	// samples aren't able to be written to the device quickly enough to keep
+	// up with its sample rate.
+	OnUnderrun func(Event)
+
+	// Realtime will pace writes to the sample rate, for devices that take
+	// samples as fast as they're written rather than blocking until they
+	// have been sent.
+	Realtime bool
+}
+
+// clock is where the transmitter gets the time from, and how it waits, so
+// that pacing and underruns can be tested without depending on how long
+// things actually take.
+type clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+// wallClock is a clock that uses the time package.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time        { return time.Now() }
+func (wallClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// transmitClock is the clock used by Transmit.
+var transmitClock clock = wallClock{}
+
+// transmitBufferLength is the number of samples written to the device in
+// each call to Write.
+const transmitBufferLength = 32 * 1024
+
+// Transmit will send the rfcap capture out through the provided
+// sdr.Transmitter. The center frequency and sample rate of the device will be
+// set from the Header, and samples will be converted to the device's sample
+// format.
+func Transmit(dev sdr.Transmitter, r io.Reader, opts TransmitOptions) error {
+	header, err := ReadHeader(r)
+	if err != nil {
+		return err
+	}
+
+	seeker, seekable := r.(io.Seeker)
+	if opts.Repeat != 0 && opts.Repeat != 1 && !seekable {
+		return fmt.Errorf("rfcap: repeating a transmission requires an io.Seeker")
+	}
+
+	offset, err := header.DataOffset()
+	if err != nil {
+		return err
+	}
+
+	if err := dev.SetCenterFrequency(header.CenterFrequency); err != nil {
+		return err
+	}
+	if err := dev.SetSampleRate(header.SampleRate); err != nil {
+		return err
+	}
+
+	tx, err := dev.StartTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	t := &transmitter{
+		tx:     tx,
+		header: header,
+		opts:   opts,
+		clock:  transmitClock,
+	}
+	if err := t.init(); err != nil {
+		return err
+	}
+
+	for i := 0; opts.Repeat <= 0 || i < opts.Repeat; i++ {
+		if i > 0 {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			if err := t.silence(opts.Gap); err != nil {
+				return err
+			}
+		}
+
+		body, err := bodyReader(r, header)
+		if err != nil {
+			return err
+		}
+		if err := t.send(body); err != nil {
+			return err
+		}
+
+		if opts.Repeat == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// transmitter keeps track of how many samples have been written, so that
+// writes can be paced and underruns can be detected.
+type transmitter struct {
+	tx     sdr.WriteCloser
+	header Header
+	opts   TransmitOptions
+	clock  clock
+
+	buf   sdr.Samples
+	start time.Time
+	sent  uint64
+}
+
+func (t *transmitter) init() error {
+	var err error
+	t.buf, err = sdr.MakeSamples(t.tx.SampleFormat(), transmitBufferLength)
+	return err
+}
+
+// write will send the samples to the device, and check to see if we've
+// fallen behind. If we're Realtime and ahead, this will wait until the
+// samples are due.
+func (t *transmitter) write(s sdr.Samples) error {
+	now := t.clock.Now()
+	if t.start.IsZero() {
+		t.start = now
+	}
+
+	var (
+		expected = t.header.Duration(int64(t.sent))
+		elapsed  = now.Sub(t.start)
+	)
+	if behind := elapsed - expected; behind > t.header.Duration(int64(s.Length())) {
+		if t.opts.OnUnderrun != nil {
+			t.opts.OnUnderrun(Event{
+				Kind:   EventUnderrun,
+				Sample: t.sent,
+				Time:   now,
+				Length: uint64(behind.Seconds() * float64(t.header.SampleRate)),
+			})
+		}
+		// Once we've reported it, start measuring from here, otherwise
+		// every write after the first underrun will be reported too.
+		t.start = now.Add(-expected)
+	} else if t.opts.Realtime && elapsed < expected {
+		t.clock.Sleep(expected - elapsed)
+	}
+
+	n, err := t.tx.Write(s)
+	t.sent += uint64(n)
+	if err != nil {
+		return err
+	}
+	if n != s.Length() {
+		return sdr.ErrShortWrite
+	}
+	return nil
+}
+
+// send will transmit everything in the sdr.Reader.
+func (t *transmitter) send(body sdr.Reader) error {
+	r, err := newConvertReader(body, t.tx.SampleFormat())
+	if err != nil {
+		return err
+	}
+
+	for {
+		n, err := sdr.ReadFull(r, t.buf)
+		if n > 0 {
+			if werr := t.write(t.buf.Slice(0, n)); werr != nil {
+				return werr
+			}
+		}
+		switch err {
+		case nil:
+		case io.EOF, sdr.ErrUnexpectedEOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// silence will transmit zeros for the provided duration.
+func (t *transmitter) silence(d time.Duration) error {
+	zeros, err := makeSilence(t.tx.SampleFormat(), transmitBufferLength)
+	if err != nil {
+		return err
+	}
+
+	remaining := int64(d.Seconds() * float64(t.header.SampleRate))
+	for remaining > 0 {
+		n := int64(zeros.Length())
+		if remaining < n {
+			n = remaining
+		}
+		if err := t.write(zeros.Slice(0, int(n))); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,212 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+	"hz.tools/sdr/mock"
+)
+
+// recordingWriter is an sdr.Writer that keeps a copy of everything written
+// to it.
+type recordingWriter struct {
+	samples sdr.SamplesI16
+}
+
+func (rw *recordingWriter) SampleRate() uint               { return 10 }
+func (rw *recordingWriter) SampleFormat() sdr.SampleFormat { return sdr.SampleFormatI16 }
+func (rw *recordingWriter) Write(s sdr.Samples) (int, error) {
+	rw.samples = append(rw.samples, s.(sdr.SamplesI16)...)
+	return s.Length(), nil
+}
+
+func TestTransmit(t *testing.T) {
+	capture := &bytes.Buffer{}
+	w, err := rfcap.Writer(capture, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Now(),
+		CenterFrequency: rf.MustParseHz("433.92MHz"),
+		SampleRate:      10,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.LittleEndian,
+	})
+	assert.NoError(t, err)
+	_, err = w.Write(sdr.SamplesC64{0.5, 0.5, 0.5})
+	assert.NoError(t, err)
+
+	rw := &recordingWriter{}
+	dev := mock.New(mock.Config{
+		SampleFormat: sdr.SampleFormatI16,
+		Tx: func(sdr.Transceiver) (sdr.WriteCloser, error) {
+			return sdr.WriterWithCloser(rw, func() error { return nil }), nil
+		},
+	})
+
+	underruns := 0
+	assert.NoError(t, rfcap.Transmit(dev, bytes.NewReader(capture.Bytes()), rfcap.TransmitOptions{
+		Repeat: 2,
+		Gap:    200 * time.Millisecond,
+		OnUnderrun: func(rfcap.Event) {
+			underruns++
+		},
+	}))
+	assert.Equal(t, 0, underruns)
+
+	cf, err := dev.GetCenterFrequency()
+	assert.NoError(t, err)
+	assert.Equal(t, rf.MustParseHz("433.92MHz"), cf)
+	sps, err := dev.GetSampleRate()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), sps)
+
+	half := [2]int16{16383, 0}
+	assert.Equal(t, sdr.SamplesI16{
+		half, half, half,
+		{0, 0}, {0, 0},
+		half, half, half,
+	}, rw.samples)
+}
+
+// stallingReader is an io.Reader that calls stall once, after the first
+// after bytes have been read.
+type stallingReader struct {
+	r     io.Reader
+	after int
+	stall func()
+	read  int
+}
+
+func (sr *stallingReader) Read(b []byte) (int, error) {
+	if sr.read >= sr.after && sr.stall != nil {
+		sr.stall()
+		sr.stall = nil
+	}
+	if left := sr.after - sr.read; left > 0 && left < len(b) {
+		b = b[:left]
+	}
+	n, err := sr.r.Read(b)
+	sr.read += n
+	return n, err
+}
+
+const (
+	transmitBufferLength = 32 * 1024
+	// Each buffer of samples takes 100ms to send.
+	transmitSampleRate = transmitBufferLength * 10
+)
+
+// transmitCapture is an i16 capture of the provided number of buffers of
+// silence, at transmitSampleRate.
+func transmitCapture(t *testing.T, buffers int) *bytes.Buffer {
+	return captureBuffer(t, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  time.Now(),
+		SampleRate:   transmitSampleRate,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+	}, make(sdr.SamplesI16, buffers*transmitBufferLength))
+}
+
+func transmitSdr(rw *recordingWriter) sdr.Transmitter {
+	return mock.New(mock.Config{
+		SampleFormat: sdr.SampleFormatI16,
+		Tx: func(sdr.Transceiver) (sdr.WriteCloser, error) {
+			return sdr.WriterWithCloser(rw, func() error { return nil }), nil
+		},
+	})
+}
+
+func TestTransmitUnderrun(t *testing.T) {
+	clock := rfcap.NewFakeClock(time.Unix(1600000000, 0))
+	defer rfcap.SetTransmitClock(clock)()
+
+	// Stall for 300ms once the first buffer has been read, which leaves
+	// the device 200ms behind when the second buffer is sent.
+	capture := transmitCapture(t, 2)
+	r := &stallingReader{
+		r:     capture,
+		after: capture.Len() - transmitBufferLength*4,
+		stall: func() { clock.Advance(300 * time.Millisecond) },
+	}
+
+	rw := &recordingWriter{}
+	underruns := []rfcap.Event{}
+	assert.NoError(t, rfcap.Transmit(transmitSdr(rw), r, rfcap.TransmitOptions{
+		OnUnderrun: func(e rfcap.Event) {
+			underruns = append(underruns, e)
+		},
+	}))
+	assert.Equal(t, 2*transmitBufferLength, len(rw.samples))
+
+	assert.Equal(t, 1, len(underruns))
+	assert.Equal(t, rfcap.EventUnderrun, underruns[0].Kind)
+	assert.Equal(t, uint64(transmitBufferLength), underruns[0].Sample)
+	assert.Equal(t, uint64(2*transmitBufferLength), underruns[0].Length)
+	assert.Equal(t, 0, len(clock.Sleeps))
+}
+
+func TestTransmitRealtime(t *testing.T) {
+	clock := rfcap.NewFakeClock(time.Unix(1600000000, 0))
+	defer rfcap.SetTransmitClock(clock)()
+
+	rw := &recordingWriter{}
+	underruns := 0
+	assert.NoError(t, rfcap.Transmit(transmitSdr(rw), transmitCapture(t, 3), rfcap.TransmitOptions{
+		Realtime: true,
+		OnUnderrun: func(rfcap.Event) {
+			underruns++
+		},
+	}))
+	assert.Equal(t, 3*transmitBufferLength, len(rw.samples))
+	assert.Equal(t, 0, underruns)
+
+	// The recordingWriter never blocks, so each buffer is held back until
+	// the one before it would have been sent.
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	}, clock.Sleeps)
+}
+
+func TestTransmitRepeatNeedsSeeker(t *testing.T) {
+	capture := &bytes.Buffer{}
+	_, err := rfcap.Writer(capture, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		SampleRate:   10,
+		SampleFormat: sdr.SampleFormatU8,
+	})
+	assert.NoError(t, err)
+
+	dev := mock.New(mock.Config{SampleFormat: sdr.SampleFormatU8})
+	assert.Error(t, rfcap.Transmit(dev, capture, rfcap.TransmitOptions{Repeat: 2}))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,526 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"hz.tools/rfcap/internal"
+	"hz.tools/sdr"
+)
+
+// Each UDP datagram starts with a small fixed-size preamble, followed by
+// either a marshaled Header, or samples in the format and byte order of the
+// last Header sent.
+//
+//	bytes 0-3    "RFCU"
+//	byte  4      packet kind (1 for a Header, 2 for samples)
+//	bytes 5-7    reserved, zero
+//	bytes 8-11   sequence number, incremented for every packet
+//	bytes 12-19  index of the first sample in the packet, or of the next
+//	             sample to be sent, for a Header packet
+//
+// All integers are little endian.
+const (
+	udpPreambleLength = 20
+
+	udpKindHeader  = 1
+	udpKindSamples = 2
+
+	// udpMaxPayload keeps packets under a 1500 byte MTU once the IPv4 and
+	// UDP headers are added.
+	udpMaxPayload = 1500 - 20 - 8 - udpPreambleLength
+
+	// udpRestartPackets is how far the sequence number has to go backwards
+	// before it's taken to mean that the sender was restarted, rather than
+	// that the packet was reordered.
+	udpRestartPackets = 1024
+)
+
+var udpMagic = [4]byte{'R', 'F', 'C', 'U'}
+
+// udpPreamble is the start of every UDP packet.
+type udpPreamble struct {
+	Magic    [4]byte
+	Kind     uint8
+	Reserved [3]byte
+	Sequence uint32
+	Sample   uint64
+}
+
+func (p udpPreamble) put(b []byte) {
+	copy(b[0:4], p.Magic[:])
+	b[4] = p.Kind
+	copy(b[5:8], p.Reserved[:])
+	binary.LittleEndian.PutUint32(b[8:12], p.Sequence)
+	binary.LittleEndian.PutUint64(b[12:20], p.Sample)
+}
+
+func parseUDPPreamble(b []byte) (udpPreamble, error) {
+	var p udpPreamble
+	if len(b) < udpPreambleLength {
+		return p, fmt.Errorf("rfcap: udp packet too short")
+	}
+	copy(p.Magic[:], b[0:4])
+	if p.Magic != udpMagic {
+		return p, fmt.Errorf("rfcap: udp packet has the wrong magic")
+	}
+	p.Kind = b[4]
+	copy(p.Reserved[:], b[5:8])
+	p.Sequence = binary.LittleEndian.Uint32(b[8:12])
+	p.Sample = binary.LittleEndian.Uint64(b[12:20])
+	return p, nil
+}
+
+// UDPSenderOptions control how samples are split into packets.
+type UDPSenderOptions struct {
+	// PacketSamples is the number of samples sent in each packet. If zero,
+	// as many as will fit in a 1500 byte MTU are sent.
+	PacketSamples int
+
+	// HeaderInterval is how often the Header is sent, so receivers that
+	// join late know what they're looking at. If zero, it's sent every
+	// second. The Header is always sent before the first samples.
+	HeaderInterval time.Duration
+}
+
+// UDPSender is an sdr.Writer that sends samples as UDP datagrams, for
+// distribution over a LAN, usually to a multicast group. Every call to Write
+// on the underlying io.Writer is expected to send a single datagram, as
+// with a *net.UDPConn.
+//
+// Since each packet has to make sense on its own, samples are always sent
+// uncompressed, even if the Header says the capture was compressed.
+type UDPSender struct {
+	out     io.Writer
+	header  Header
+	marshal []byte
+	opts    UDPSenderOptions
+	swap    bool
+
+	sequence   uint32
+	sample     uint64
+	lastHeader time.Time
+	packet     []byte
+}
+
+// NewUDPSender will create a UDPSender that sends samples described by the
+// Header to the io.Writer.
+func NewUDPSender(out io.Writer, header Header, opts UDPSenderOptions) (*UDPSender, error) {
+	header.Compressed = false
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+	marshal, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	size := header.SampleFormat.Size()
+	if opts.PacketSamples <= 0 {
+		opts.PacketSamples = udpMaxPayload / size
+	}
+	if opts.HeaderInterval <= 0 {
+		opts.HeaderInterval = time.Second
+	}
+
+	return &UDPSender{
+		out:     out,
+		header:  header,
+		marshal: marshal,
+		opts:    opts,
+		swap:    header.Endianness != nil && header.Endianness != internal.NativeEndian,
+		packet:  make([]byte, udpPreambleLength+opts.PacketSamples*size),
+	}, nil
+}
+
+// SampleRate implements the sdr.Writer interface.
+func (us *UDPSender) SampleRate() uint {
+	return us.header.SampleRate
+}
+
+// SampleFormat implements the sdr.Writer interface.
+func (us *UDPSender) SampleFormat() sdr.SampleFormat {
+	return us.header.SampleFormat
+}
+
+// send will write a single packet.
+func (us *UDPSender) send(kind uint8, payload []byte) error {
+	packet := us.packet[:udpPreambleLength]
+	if kind == udpKindHeader {
+		packet = make([]byte, udpPreambleLength, udpPreambleLength+len(payload))
+	}
+	udpPreamble{
+		Magic:    udpMagic,
+		Kind:     kind,
+		Sequence: us.sequence,
+		Sample:   us.sample,
+	}.put(packet)
+	if kind == udpKindHeader {
+		packet = append(packet, payload...)
+	} else {
+		packet = us.packet[:udpPreambleLength+len(payload)]
+	}
+
+	us.sequence++
+	_, err := us.out.Write(packet)
+	return err
+}
+
+// Write implements the sdr.Writer interface, sending as many packets as
+// it takes to send all the samples.
+func (us *UDPSender) Write(s sdr.Samples) (int, error) {
+	if s.Format() != us.header.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+
+	var written int
+	for written < s.Length() {
+		if time.Since(us.lastHeader) >= us.opts.HeaderInterval {
+			if err := us.send(udpKindHeader, us.marshal); err != nil {
+				return written, err
+			}
+			us.lastHeader = time.Now()
+		}
+
+		end := written + us.opts.PacketSamples
+		if end > s.Length() {
+			end = s.Length()
+		}
+		b, err := sdr.UnsafeSamplesAsBytes(s.Slice(written, end))
+		if err != nil {
+			return written, err
+		}
+		payload := us.packet[udpPreambleLength : udpPreambleLength+len(b)]
+		copy(payload, b)
+		if us.swap {
+			swapBytes(us.header.SampleFormat, payload)
+		}
+
+		if err := us.send(udpKindSamples, payload); err != nil {
+			return written, err
+		}
+		us.sample += uint64(end - written)
+		written = end
+	}
+	return written, nil
+}
+
+// UDPStats count what's happened to the packets seen by a UDPReceiver.
+type UDPStats struct {
+	// Packets is the number of packets received.
+	Packets uint64
+
+	// Lost is the number of packets that never arrived, based on gaps in
+	// the sequence numbers.
+	Lost uint64
+
+	// Reordered is the number of packets that arrived after a later packet,
+	// and were thrown away.
+	Reordered uint64
+
+	// Invalid is the number of packets that couldn't be parsed.
+	Invalid uint64
+
+	// Restarts is the number of times the sender was restarted, and the
+	// stream picked up again from the new sender.
+	Restarts uint64
+}
+
+// UDPReceiver is an sdr.Reader of samples sent by a UDPSender. Every call
+// to Read on the underlying io.Reader is expected to return a single
+// datagram, as with a *net.UDPConn.
+//
+// Samples are thrown away until the first Header arrives, and the stream
+// starts at the sample the Header was sent at. Samples lost in transit, or
+// that arrive after later samples, are replaced with silence so that sample
+// indexes still line up with time, and recorded as an EventGap.
+//
+// If the sender is restarted, which shows up as a different Header, or as
+// the sequence number jumping backwards, samples are thrown away until the
+// new sender's Header arrives. The stream then carries on from the new
+// sender, with the time between the two senders filled with silence and
+// recorded as an EventGap. The new sender must use the same sample format
+// and rate.
+type UDPReceiver struct {
+	in     io.Reader
+	packet []byte
+
+	sender    []byte
+	restarted bool
+	start     uint64
+	next      uint64
+	sequence  uint32
+	swap      bool
+	silence   sdr.Samples
+	gap       uint64
+	pending   []byte
+
+	lock   sync.Mutex
+	header *Header
+	stats  UDPStats
+	events []Event
+}
+
+// NewUDPReceiver will create a UDPReceiver reading packets from the
+// io.Reader.
+func NewUDPReceiver(in io.Reader) *UDPReceiver {
+	return &UDPReceiver{
+		in:     in,
+		packet: make([]byte, 64*1024),
+	}
+}
+
+// Header will wait for a Header packet to arrive, and return it with the
+// CaptureTime adjusted to the first sample of this stream.
+func (ur *UDPReceiver) Header() (Header, error) {
+	for {
+		if hdr, ok := ur.getHeader(); ok {
+			return hdr, nil
+		}
+		if err := ur.receive(); err != nil {
+			return Header{}, err
+		}
+	}
+}
+
+func (ur *UDPReceiver) getHeader() (Header, bool) {
+	ur.lock.Lock()
+	defer ur.lock.Unlock()
+	if ur.header == nil {
+		return Header{}, false
+	}
+	return *ur.header, true
+}
+
+// Stats will return the packet counts so far.
+func (ur *UDPReceiver) Stats() UDPStats {
+	ur.lock.Lock()
+	defer ur.lock.Unlock()
+	return ur.stats
+}
+
+// Events will return an EventGap for each run of samples lost so far. The
+// Sample of each Event is in terms of this stream.
+func (ur *UDPReceiver) Events() []Event {
+	ur.lock.Lock()
+	defer ur.lock.Unlock()
+	return append([]Event{}, ur.events...)
+}
+
+// SampleRate implements the sdr.Reader interface. This is 0 until the
+// Header arrives.
+func (ur *UDPReceiver) SampleRate() uint {
+	hdr, _ := ur.getHeader()
+	return hdr.SampleRate
+}
+
+// SampleFormat implements the sdr.Reader interface. Since this can't be
+// known until the Header arrives, this will block waiting for it.
+func (ur *UDPReceiver) SampleFormat() sdr.SampleFormat {
+	hdr, err := ur.Header()
+	if err != nil {
+		return 0
+	}
+	return hdr.SampleFormat
+}
+
+// receive will read and handle a single packet.
+func (ur *UDPReceiver) receive() error {
+	n, err := ur.in.Read(ur.packet)
+	if err != nil {
+		return err
+	}
+	packet := ur.packet[:n]
+
+	ur.lock.Lock()
+	defer ur.lock.Unlock()
+
+	p, err := parseUDPPreamble(packet)
+	if err != nil {
+		ur.stats.Invalid++
+		return nil
+	}
+	ur.stats.Packets++
+
+	payload := packet[udpPreambleLength:]
+	if p.Kind == udpKindHeader && ur.header != nil && !bytes.Equal(payload, ur.sender) {
+		// Every Header from the same sender is the same, so this is a new
+		// sender, and its sequence numbers start over.
+		ur.restarted = true
+	} else if ur.stats.Packets > 1 {
+		switch delta := p.Sequence - ur.sequence; {
+		case -delta > udpRestartPackets && -delta < 1<<31:
+			// This is too far back to have been reordered, so the sender
+			// must have been restarted. Nothing it sends makes sense until
+			// its Header arrives.
+			ur.restarted = true
+		case delta == 0 || delta > 1<<31:
+			// This is older than the last packet we saw.
+			ur.stats.Reordered++
+			return nil
+		default:
+			ur.stats.Lost += uint64(delta - 1)
+		}
+	}
+	ur.sequence = p.Sequence
+
+	switch p.Kind {
+	case udpKindHeader:
+		if ur.header != nil && !ur.restarted {
+			return nil
+		}
+		hdr := Header{}
+		if err := hdr.Unmarshal(payload); err != nil {
+			ur.stats.Invalid++
+			return nil
+		}
+		if ur.header != nil {
+			return ur.resync(hdr, payload, p.Sample)
+		}
+		if ur.silence, err = makeSilence(hdr.SampleFormat, 32*1024); err != nil {
+			return err
+		}
+		ur.sender = append([]byte{}, payload...)
+		ur.start = p.Sample
+		ur.next = p.Sample
+		ur.swap = hdr.Endianness != nil && hdr.Endianness != internal.NativeEndian
+		hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(int64(p.Sample)))
+		ur.header = &hdr
+	case udpKindSamples:
+		if ur.header == nil || ur.restarted || p.Sample < ur.next {
+			// Either we don't know what this is yet, or it's from before
+			// the start of our stream.
+			return nil
+		}
+		size := ur.header.SampleFormat.Size()
+		if len(payload)%size != 0 {
+			ur.stats.Invalid++
+			return nil
+		}
+		if p.Sample > ur.next {
+			gap := p.Sample - ur.next
+			ur.events = append(ur.events, Event{
+				Kind:   EventGap,
+				Sample: ur.next - ur.start,
+				Time:   time.Now(),
+				Length: gap,
+			})
+			ur.gap += gap
+		}
+		ur.pending = payload
+		ur.next = p.Sample + uint64(len(payload)/size)
+	default:
+		ur.stats.Invalid++
+	}
+	return nil
+}
+
+// resync will carry on the stream from a new sender, whose Header has just
+// arrived. This must be called with the lock held.
+func (ur *UDPReceiver) resync(hdr Header, marshaled []byte, sample uint64) error {
+	if hdr.SampleFormat != ur.header.SampleFormat || hdr.SampleRate != ur.header.SampleRate {
+		return fmt.Errorf("rfcap: udp sender restarted with a different sample format or rate")
+	}
+
+	var (
+		position = ur.next - ur.start
+		end      = ur.header.CaptureTime.Add(ur.header.Duration(int64(position)))
+		gap      = hdr.CaptureTime.Add(hdr.Duration(int64(sample))).Sub(end)
+	)
+	if length := uint64(gap.Seconds() * float64(hdr.SampleRate)); gap > 0 && length > 0 {
+		ur.events = append(ur.events, Event{
+			Kind:   EventGap,
+			Sample: position,
+			Time:   time.Now(),
+			Length: length,
+		})
+		ur.gap += length
+		position += length
+	}
+
+	ur.stats.Restarts++
+	ur.restarted = false
+	ur.sender = append(ur.sender[:0], marshaled...)
+	ur.start = sample - position
+	ur.next = sample
+	ur.swap = hdr.Endianness != nil && hdr.Endianness != internal.NativeEndian
+	return nil
+}
+
+// Read implements the sdr.Reader interface, blocking until at least one
+// sample has arrived.
+func (ur *UDPReceiver) Read(s sdr.Samples) (int, error) {
+	hdr, err := ur.Header()
+	if err != nil {
+		return 0, err
+	}
+	if s.Format() != hdr.SampleFormat {
+		return 0, sdr.ErrSampleFormatMismatch
+	}
+
+	var (
+		size   = hdr.SampleFormat.Size()
+		filled int
+	)
+	for filled < s.Length() {
+		switch {
+		case ur.gap > 0:
+			n := s.Length() - filled
+			if uint64(n) > ur.gap {
+				n = int(ur.gap)
+			}
+			if n > ur.silence.Length() {
+				n = ur.silence.Length()
+			}
+			if _, err := sdr.CopySamples(s.Slice(filled, filled+n), ur.silence.Slice(0, n)); err != nil {
+				return filled, err
+			}
+			ur.gap -= uint64(n)
+			filled += n
+		case len(ur.pending) > 0:
+			b, err := sdr.UnsafeSamplesAsBytes(s.Slice(filled, s.Length()))
+			if err != nil {
+				return filled, err
+			}
+			n := copy(b, ur.pending)
+			if ur.swap {
+				swapBytes(hdr.SampleFormat, b[:n])
+			}
+			ur.pending = ur.pending[n:]
+			filled += n / size
+		case filled > 0:
+			return filled, nil
+		default:
+			if err := ur.receive(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return filled, nil
+}
+
+// vim: foldmethod=marker
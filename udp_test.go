@@ -0,0 +1,258 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+// packets is an in-memory list of datagrams, which can be written to by a
+// UDPSender and read back by a UDPReceiver.
+type packets [][]byte
+
+func (p *packets) Write(b []byte) (int, error) {
+	*p = append(*p, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (p *packets) Read(b []byte) (int, error) {
+	if len(*p) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, (*p)[0])
+	*p = (*p)[1:]
+	return n, nil
+}
+
+var udpEpoch = time.Unix(1600000000, 0)
+
+// udpPackets will send 1000 samples, where the real part of each sample is
+// its index, 100 samples to a packet.
+func udpPackets(t *testing.T, headerInterval time.Duration) packets {
+	p := packets{}
+	udpSend(t, &p, udpEpoch, headerInterval)
+	return p
+}
+
+// udpSend will send 1000 samples from a new UDPSender, as udpPackets does,
+// for a capture starting at the provided time.
+func udpSend(t *testing.T, p *packets, when time.Time, headerInterval time.Duration) {
+	sender, err := rfcap.NewUDPSender(p, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     when,
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatC64,
+		Endianness:      binary.BigEndian,
+	}, rfcap.UDPSenderOptions{
+		PacketSamples:  100,
+		HeaderInterval: headerInterval,
+	})
+	assert.NoError(t, err)
+
+	samples := make(sdr.SamplesC64, 1000)
+	for i := range samples {
+		samples[i] = complex(float32(i), 0)
+	}
+	n, err := sender.Write(samples)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, n)
+}
+
+func readUDP(t *testing.T, p packets) (*rfcap.UDPReceiver, rfcap.Header, sdr.SamplesC64) {
+	receiver := rfcap.NewUDPReceiver(&p)
+	hdr, err := receiver.Header()
+	assert.NoError(t, err)
+
+	samples := sdr.SamplesC64{}
+	buf := make(sdr.SamplesC64, 64)
+	for {
+		n, err := receiver.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return receiver, hdr, samples
+		}
+		assert.NoError(t, err)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	p := udpPackets(t, time.Hour)
+	assert.Equal(t, 11, len(p))
+
+	receiver, hdr, samples := readUDP(t, p)
+	assert.Equal(t, udpEpoch, hdr.CaptureTime)
+	assert.Equal(t, binary.BigEndian, hdr.Endianness)
+	assert.Equal(t, 1000, len(samples))
+	for i, s := range samples {
+		assert.Equal(t, float32(i), real(s))
+	}
+	assert.Equal(t, rfcap.UDPStats{Packets: 11}, receiver.Stats())
+	assert.Empty(t, receiver.Events())
+}
+
+func TestUDPLoss(t *testing.T) {
+	p := udpPackets(t, time.Hour)
+	// Packet 0 is the Header, so this is samples 200 to 299.
+	p = append(p[:3], p[4:]...)
+
+	receiver, _, samples := readUDP(t, p)
+	assert.Equal(t, 1000, len(samples))
+	assert.Equal(t, float32(199), real(samples[199]))
+	for _, s := range samples[200:300] {
+		assert.Equal(t, complex64(0), s)
+	}
+	assert.Equal(t, float32(300), real(samples[300]))
+
+	assert.Equal(t, uint64(1), receiver.Stats().Lost)
+	events := receiver.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, rfcap.EventGap, events[0].Kind)
+	assert.Equal(t, uint64(200), events[0].Sample)
+	assert.Equal(t, uint64(100), events[0].Length)
+}
+
+func TestUDPReordered(t *testing.T) {
+	p := udpPackets(t, time.Hour)
+	p[3], p[4] = p[4], p[3]
+
+	receiver, _, samples := readUDP(t, p)
+	assert.Equal(t, 1000, len(samples))
+	assert.Equal(t, complex64(0), samples[250])
+	assert.Equal(t, float32(300), real(samples[300]))
+
+	stats := receiver.Stats()
+	assert.Equal(t, uint64(1), stats.Lost)
+	assert.Equal(t, uint64(1), stats.Reordered)
+	assert.Equal(t, 1, len(receiver.Events()))
+}
+
+func TestUDPLateJoin(t *testing.T) {
+	// Send a Header before every packet, and miss the first of each.
+	p := udpPackets(t, time.Nanosecond)
+	assert.Equal(t, 20, len(p))
+	p = p[2:]
+
+	_, hdr, samples := readUDP(t, p)
+	assert.Equal(t, udpEpoch.Add(100*time.Millisecond), hdr.CaptureTime)
+	assert.Equal(t, 900, len(samples))
+	assert.Equal(t, float32(100), real(samples[0]))
+}
+
+func TestUDPRestart(t *testing.T) {
+	p := udpPackets(t, time.Hour)
+	udpSend(t, &p, udpEpoch.Add(2*time.Second), time.Hour)
+
+	receiver, hdr, samples := readUDP(t, p)
+	assert.Equal(t, udpEpoch, hdr.CaptureTime)
+	assert.Equal(t, 3000, len(samples))
+	assert.Equal(t, float32(999), real(samples[999]))
+	for _, s := range samples[1000:2000] {
+		assert.Equal(t, complex64(0), s)
+	}
+	assert.Equal(t, float32(0), real(samples[2000]))
+	assert.Equal(t, float32(999), real(samples[2999]))
+
+	stats := receiver.Stats()
+	assert.Equal(t, uint64(1), stats.Restarts)
+	assert.Equal(t, uint64(0), stats.Reordered)
+	events := receiver.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint64(1000), events[0].Sample)
+	assert.Equal(t, uint64(1000), events[0].Length)
+}
+
+func TestUDPRestartLostHeader(t *testing.T) {
+	// Send enough packets that going back to the start is too far to be
+	// mistaken for reordering.
+	p := packets{}
+	sender, err := rfcap.NewUDPSender(&p, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  udpEpoch,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatC64,
+		Endianness:   binary.BigEndian,
+	}, rfcap.UDPSenderOptions{PacketSamples: 1, HeaderInterval: time.Hour})
+	assert.NoError(t, err)
+	_, err = sender.Write(make(sdr.SamplesC64, 2000))
+	assert.NoError(t, err)
+
+	// Without its first Header, the new sender's first samples can't be
+	// placed, so they're thrown away until the next Header.
+	restarted := packets{}
+	udpSend(t, &restarted, udpEpoch.Add(4*time.Second), time.Nanosecond)
+	p = append(p, restarted[1:]...)
+
+	receiver, _, samples := readUDP(t, p)
+	assert.Equal(t, 2000+2100+900, len(samples))
+	assert.Equal(t, complex64(0), samples[4099])
+	assert.Equal(t, float32(100), real(samples[4100]))
+	assert.Equal(t, float32(999), real(samples[4999]))
+
+	stats := receiver.Stats()
+	assert.Equal(t, uint64(1), stats.Restarts)
+	assert.Equal(t, uint64(0), stats.Reordered)
+}
+
+func TestUDPLoopback(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	out, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(t, err)
+	defer out.Close()
+
+	sender, err := rfcap.NewUDPSender(out, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  udpEpoch,
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatI16,
+		Endianness:   binary.LittleEndian,
+	}, rfcap.UDPSenderOptions{})
+	assert.NoError(t, err)
+
+	samples := make(sdr.SamplesI16, 1000)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i), -1}
+	}
+	_, err = sender.Write(samples)
+	assert.NoError(t, err)
+
+	receiver := rfcap.NewUDPReceiver(conn)
+	got := make(sdr.SamplesI16, 1000)
+	_, err = sdr.ReadFull(receiver, got)
+	assert.NoError(t, err)
+	assert.Equal(t, samples, got)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,297 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"hz.tools/sdr"
+)
+
+// FindingSeverity is how serious a Finding is.
+type FindingSeverity string
+
+const (
+	// FindingError is a problem that makes the capture invalid, or will
+	// stop it from being read correctly.
+	FindingError FindingSeverity = "error"
+
+	// FindingWarning is something unusual that won't stop the capture from
+	// being read.
+	FindingWarning FindingSeverity = "warning"
+)
+
+// Finding is a single problem found by Verify.
+type Finding struct {
+	// Severity is how serious this problem is.
+	Severity FindingSeverity `json:"severity"`
+
+	// Code is a short, stable identifier for the problem, such as
+	// "partial-sample", for tools to match on.
+	Code string `json:"code"`
+
+	// Message is a human readable description of the problem.
+	Message string `json:"message"`
+
+	// Offset is the byte offset into the capture that the problem
+	// relates to.
+	Offset int64 `json:"offset"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s at byte %d)", f.Severity, f.Message, f.Code, f.Offset)
+}
+
+// VerifyReport is the result of checking a capture with Verify.
+type VerifyReport struct {
+	// Header is the decoded Header, or nil if it couldn't be decoded at all.
+	Header *Header `json:"header,omitempty"`
+
+	// Length is the total number of bytes in the capture.
+	Length int64 `json:"length"`
+
+	// Samples is the number of whole samples in the capture.
+	Samples int64 `json:"samples"`
+
+	// ValidLength is the number of bytes from the start of the capture that
+	// hold the header and whole samples. Truncating the capture to this
+	// length will remove any partial sample at the end. This is 0 if the
+	// header itself is unusable.
+	ValidLength int64 `json:"valid_length"`
+
+	// Findings are all problems found with the capture.
+	Findings []Finding `json:"findings"`
+}
+
+// OK will return true if there are no FindingError Findings.
+func (r VerifyReport) OK() bool {
+	for _, f := range r.Findings {
+		if f.Severity == FindingError {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *VerifyReport) add(severity FindingSeverity, code string, offset int64, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Offset:   offset,
+	})
+}
+
+// Verify will check the capture read from the io.Reader for problems, such as
+// an unknown magic, header fields that don't make sense together, or a body
+// that ends partway through a sample or packed block. This is a lot more
+// strict than ReadHeader, which will do its best to read anything.
+//
+// rfcap v1 captures don't contain checksums, so the sample data itself can't
+// be checked for corruption.
+//
+// Problems with the capture are returned as Findings in the VerifyReport.
+// An error is only returned if reading the capture fails.
+func Verify(in io.Reader) (VerifyReport, error) {
+	report := VerifyReport{Findings: []Finding{}}
+
+	buf := make([]byte, Size)
+	n, err := io.ReadFull(in, buf)
+	report.Length = int64(n)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		report.add(FindingError, "short-header", int64(n),
+			"capture is %d bytes, which is shorter than the %d byte header", n, Size)
+		return report, nil
+	default:
+		return report, err
+	}
+
+	raw := rawHeader{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &raw); err != nil {
+		return report, err
+	}
+
+	switch Magic(raw.Magic) {
+	case MagicVersion1, MagicVersion2:
+	default:
+		report.add(FindingError, "unknown-magic", 0,
+			"magic %q is not a known rfcap version", raw.Magic[:])
+		return report, nil
+	}
+	if !verifyRawHeader(&report, raw) {
+		return report, nil
+	}
+
+	hdr := raw.asExportHeader()
+	offset := int64(Size)
+	if raw.extensionLength() > 0 {
+		ext := make([]byte, raw.extensionLength())
+		n, err := io.ReadFull(in, ext)
+		report.Length += int64(n)
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			report.add(FindingError, "short-extension", offset+int64(n),
+				"capture ends %d bytes into a %d byte header extension", n, len(ext))
+			return report, nil
+		default:
+			return report, err
+		}
+		if err := hdr.setExtension(ext); err != nil {
+			report.add(FindingError, "malformed-extension", offset, "%s", err)
+			return report, nil
+		}
+		offset += int64(len(ext))
+	}
+	report.Header = &hdr
+
+	body, err := bodyLength(in)
+	if err != nil {
+		return report, err
+	}
+	report.Length += body
+
+	var whole int64
+	switch {
+	case hdr.SampleFormat.Size() == 0:
+		// This has already been reported, and there's no way to know
+		// where samples start or end.
+		return report, nil
+	case hdr.Compressed:
+		whole = body - body%12
+	default:
+		whole = body - body%int64(hdr.SampleFormat.Size())
+	}
+	report.Samples = hdr.SampleCount(body)
+	report.ValidLength = offset + whole
+
+	if whole != body {
+		unit := "sample"
+		if hdr.Compressed {
+			unit = "packed block"
+		}
+		report.add(FindingError, "partial-sample", offset+whole,
+			"capture ends %d bytes into a %s", body-whole, unit)
+	}
+	if body == 0 {
+		report.add(FindingWarning, "empty", offset, "capture has no samples")
+	}
+
+	return report, nil
+}
+
+// verifyRawHeader will check the fields of the fixed size header, adding
+// Findings to the report. This will return false if the header is too broken
+// to keep going.
+func verifyRawHeader(report *VerifyReport, raw rawHeader) bool {
+	var (
+		format     = raw.SampleFormat &^ 128
+		compressed = raw.SampleFormat&128 == 128
+		hdr        = raw.asExportHeader()
+		ok         = true
+	)
+
+	if hdr.SampleFormat.Size() == 0 {
+		report.add(FindingError, "unknown-sample-format", 26,
+			"sample format %d is not known", format)
+	}
+	if compressed && hdr.SampleFormat != sdr.SampleFormatI16 {
+		report.add(FindingError, "compressed-not-i16", 26,
+			"compressed is set for %s samples, but is only valid for i16", hdr.SampleFormat)
+	}
+
+	switch raw.Endianness {
+	case byteOrderLittleEndian, byteOrderBigEndian:
+	default:
+		report.add(FindingError, "unknown-endianness", 27,
+			"endianness byte %d is not known, and would be read as little endian", raw.Endianness)
+	}
+
+	if raw.SampleRate == 0 {
+		report.add(FindingError, "zero-sample-rate", 22, "sample rate is 0")
+	}
+
+	switch cf := raw.CenterFrequency; {
+	case math.IsNaN(cf) || math.IsInf(cf, 0):
+		report.add(FindingError, "bad-center-frequency", 14, "center frequency is %g", cf)
+	case cf < 0:
+		report.add(FindingWarning, "negative-center-frequency", 14,
+			"center frequency %s is negative", hdr.CenterFrequency)
+	}
+
+	if raw.CaptureTime == 0 {
+		report.add(FindingWarning, "no-capture-time", 6, "capture time is not set")
+	}
+
+	switch Magic(raw.Magic) {
+	case MagicVersion1:
+		if raw.ExtensionLength != 0 {
+			report.add(FindingWarning, "reserved-not-zero", 28,
+				"reserved header bytes are not zero")
+		}
+	default:
+		if raw.ExtensionLength%16 != 0 {
+			report.add(FindingError, "misaligned-extension", 28,
+				"header extension length %d is not a multiple of 16", raw.ExtensionLength)
+			ok = false
+		}
+		if raw.ExtensionLength > maxExtensionLength {
+			report.add(FindingError, "extension-too-large", 28,
+				"header extension length %d is larger than %d", raw.ExtensionLength, maxExtensionLength)
+			ok = false
+		}
+	}
+
+	for i, b := range raw.Reserved {
+		if b != 0 {
+			report.add(FindingWarning, "reserved-not-zero", int64(32+i),
+				"reserved header bytes are not zero")
+			break
+		}
+	}
+
+	return ok
+}
+
+// bodyLength will return the number of bytes left in the io.Reader. If it's
+// an io.Seeker, this will seek to the end rather than read everything.
+func bodyLength(in io.Reader) (int64, error) {
+	if seeker, ok := in.(io.Seeker); ok {
+		cur, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err != nil {
+				return 0, err
+			}
+			return end - cur, nil
+		}
+	}
+	return io.Copy(ioutil.Discard, in)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,142 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rf"
+	"hz.tools/rfcap"
+	"hz.tools/sdr"
+)
+
+func verifyCapture(t *testing.T, mutate func([]byte) []byte) rfcap.VerifyReport {
+	buf := captureBuffer(t, rfcap.Header{
+		Magic:           rfcap.MagicVersion1,
+		CaptureTime:     time.Unix(1600000000, 0),
+		CenterFrequency: 100 * rf.MHz,
+		SampleRate:      1000,
+		SampleFormat:    sdr.SampleFormatI16,
+		Endianness:      binary.LittleEndian,
+	}, make(sdr.SamplesI16, 10))
+
+	report, err := rfcap.Verify(bytes.NewReader(mutate(buf.Bytes())))
+	assert.NoError(t, err)
+	return report
+}
+
+func findingCodes(report rfcap.VerifyReport) []string {
+	codes := []string{}
+	for _, finding := range report.Findings {
+		codes = append(codes, finding.Code)
+	}
+	return codes
+}
+
+func TestVerifyOK(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte { return b })
+	assert.True(t, report.OK())
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, int64(10), report.Samples)
+	assert.Equal(t, int64(48+40), report.Length)
+	assert.Equal(t, report.Length, report.ValidLength)
+}
+
+func TestVerifyPartialSample(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte { return append(b, 1, 2, 3) })
+	assert.False(t, report.OK())
+	assert.Equal(t, []string{"partial-sample"}, findingCodes(report))
+	assert.Equal(t, int64(48+40), report.Findings[0].Offset)
+	assert.Equal(t, int64(48+40), report.ValidLength)
+	assert.Equal(t, int64(48+43), report.Length)
+}
+
+func TestVerifyCompressedPartialBlock(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := rfcap.Writer(buf, rfcap.Header{
+		Magic:        rfcap.MagicVersion1,
+		CaptureTime:  time.Unix(1600000000, 0),
+		SampleRate:   1000,
+		SampleFormat: sdr.SampleFormatI16,
+		Compressed:   true,
+		Endianness:   binary.LittleEndian,
+	})
+	assert.NoError(t, err)
+	_, err = w.Write(make(sdr.SamplesI16, 8))
+	assert.NoError(t, err)
+
+	// Two whole blocks of 12 bytes, and then half of a third.
+	report, err := rfcap.Verify(bytes.NewReader(append(buf.Bytes(), make([]byte, 6)...)))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"partial-sample"}, findingCodes(report))
+	assert.Equal(t, int64(8), report.Samples)
+	assert.Equal(t, int64(48+24), report.ValidLength)
+}
+
+func TestVerifyCompressedNotI16(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte {
+		b[26] = uint8(sdr.SampleFormatC64) | 128
+		return b
+	})
+	// 40 bytes of samples isn't a whole number of packed blocks either.
+	assert.Equal(t, []string{"compressed-not-i16", "partial-sample"}, findingCodes(report))
+}
+
+func TestVerifyUnknownEndianness(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte {
+		b[27] = 7
+		return b
+	})
+	assert.False(t, report.OK())
+	assert.Equal(t, []string{"unknown-endianness"}, findingCodes(report))
+	assert.Equal(t, int64(27), report.Findings[0].Offset)
+}
+
+func TestVerifyUnknownMagic(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte {
+		b[5] = '9'
+		return b
+	})
+	assert.Equal(t, []string{"unknown-magic"}, findingCodes(report))
+	assert.Nil(t, report.Header)
+}
+
+func TestVerifyShortHeader(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte { return b[:20] })
+	assert.Equal(t, []string{"short-header"}, findingCodes(report))
+	assert.Equal(t, int64(0), report.ValidLength)
+}
+
+func TestVerifyWarnings(t *testing.T) {
+	report := verifyCapture(t, func(b []byte) []byte {
+		b[40] = 1
+		return b[:48]
+	})
+	assert.True(t, report.OK())
+	assert.Equal(t, []string{"reserved-not-zero", "empty"}, findingCodes(report))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,716 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"hz.tools/rfcap/internal/websocket"
+	"hz.tools/sdr"
+)
+
+// WebSocketMode is what a stream served by WebSocketHandler sends.
+type WebSocketMode uint8
+
+const (
+	// WebSocketIQ sends the samples themselves, and is the default.
+	WebSocketIQ WebSocketMode = iota
+
+	// WebSocketFFT sends the power in each bin of an FFT, computed on the
+	// server, which is much less data for something like a waterfall.
+	WebSocketFFT
+)
+
+// String will return the name of the WebSocketMode, as accepted by
+// ParseWebSocketMode.
+func (m WebSocketMode) String() string {
+	switch m {
+	case WebSocketIQ:
+		return "iq"
+	case WebSocketFFT:
+		return "fft"
+	default:
+		return fmt.Sprintf("WebSocketMode(%d)", uint8(m))
+	}
+}
+
+// ParseWebSocketMode will return the WebSocketMode with the provided name.
+func ParseWebSocketMode(name string) (WebSocketMode, error) {
+	for _, m := range []WebSocketMode{WebSocketIQ, WebSocketFFT} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("rfcap: unknown websocket mode %q", name)
+}
+
+// WebSocketOptions are the settings each stream served by WebSocketHandler
+// starts with. Clients can change the Mode, FFTSize, Window, Average and
+// Decimate as they go.
+type WebSocketOptions struct {
+	// Mode is whether samples or FFTs are sent.
+	Mode WebSocketMode
+
+	// FFTSize is the number of bins in each FFT. This must be a power of
+	// two, and defaults to 1024. Clients can ask for up to 65536.
+	FFTSize int
+
+	// Window is applied to the samples before each FFT.
+	Window Window
+
+	// Average is the number of consecutive FFTs averaged into each message.
+	// This defaults to 1. Clients can ask for up to 1024.
+	Average int
+
+	// Decimate will decimate the samples by an integer factor before
+	// anything else is done to them. Clients can ask for up to 1024.
+	Decimate uint
+
+	// FrameSamples is the number of samples sent in each message in
+	// WebSocketIQ mode. This defaults to 16384.
+	FrameSamples int
+
+	// Realtime will pace the stream to its sample rate, which is what's
+	// wanted when streaming a capture file to something like a waterfall.
+	Realtime bool
+}
+
+// These are the largest settings a client can ask for, so that a client
+// can't make the server allocate or compute without bound.
+const (
+	wsMaxFFTSize  = 65536
+	wsMaxAverage  = 1024
+	wsMaxDecimate = 1024
+)
+
+// wsSettings are the settings of a stream that a client can change.
+type wsSettings struct {
+	mode     WebSocketMode
+	fftSize  int
+	window   Window
+	average  int
+	decimate uint
+}
+
+// wsControl is a control message sent by a client.
+type wsControl struct {
+	Command  string  `json:"command"`
+	Mode     *string `json:"mode"`
+	FFTSize  *int    `json:"fft_size"`
+	Window   *string `json:"window"`
+	Average  *int    `json:"average"`
+	Decimate *uint   `json:"decimate"`
+	Position string  `json:"position"`
+}
+
+// wsQueryControl will turn the query parameters of a request into a
+// configure control message.
+func wsQueryControl(values url.Values) (wsControl, error) {
+	ctl := wsControl{Command: "configure"}
+	if v, ok := values["mode"]; ok {
+		ctl.Mode = &v[0]
+	}
+	if v, ok := values["window"]; ok {
+		ctl.Window = &v[0]
+	}
+	for name, field := range map[string]**int{
+		"fft_size": &ctl.FFTSize,
+		"average":  &ctl.Average,
+	} {
+		if v := values.Get(name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return ctl, fmt.Errorf("rfcap: %s %q is not an integer", name, v)
+			}
+			*field = &i
+		}
+	}
+	if v := values.Get("decimate"); v != "" {
+		factor, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return ctl, fmt.Errorf("rfcap: decimate %q is not an integer", v)
+		}
+		d := uint(factor)
+		ctl.Decimate = &d
+	}
+	return ctl, nil
+}
+
+// apply will return the settings changed by a configure control message.
+func (s wsSettings) apply(ctl wsControl) (wsSettings, error) {
+	var err error
+	if ctl.Mode != nil {
+		if s.mode, err = ParseWebSocketMode(*ctl.Mode); err != nil {
+			return s, err
+		}
+	}
+	if ctl.FFTSize != nil {
+		if n := *ctl.FFTSize; n <= 0 || n&(n-1) != 0 {
+			return s, fmt.Errorf("rfcap: fft size %d is not a power of two", n)
+		}
+		if *ctl.FFTSize > wsMaxFFTSize {
+			return s, fmt.Errorf("rfcap: fft size %d is larger than %d", *ctl.FFTSize, wsMaxFFTSize)
+		}
+		s.fftSize = *ctl.FFTSize
+	}
+	if ctl.Window != nil {
+		if s.window, err = ParseWindow(*ctl.Window); err != nil {
+			return s, err
+		}
+	}
+	if ctl.Average != nil {
+		if *ctl.Average <= 0 {
+			return s, fmt.Errorf("rfcap: average must be positive")
+		}
+		if *ctl.Average > wsMaxAverage {
+			return s, fmt.Errorf("rfcap: average %d is larger than %d", *ctl.Average, wsMaxAverage)
+		}
+		s.average = *ctl.Average
+	}
+	if ctl.Decimate != nil {
+		if *ctl.Decimate > wsMaxDecimate {
+			return s, fmt.Errorf("rfcap: decimate %d is larger than %d", *ctl.Decimate, wsMaxDecimate)
+		}
+		s.decimate = *ctl.Decimate
+		if s.decimate == 0 {
+			s.decimate = 1
+		}
+	}
+	return s, nil
+}
+
+// wsHeaderMessage is sent to the client before the first binary message,
+// and again whenever what follows changes.
+type wsHeaderMessage struct {
+	Type     string `json:"type"`
+	Header   Header `json:"header"`
+	Mode     string `json:"mode"`
+	FFTSize  int    `json:"fft_size"`
+	Window   string `json:"window"`
+	Average  int    `json:"average"`
+	Decimate uint   `json:"decimate"`
+	Seekable bool   `json:"seekable"`
+}
+
+// wsMessage is any other message sent to the client.
+type wsMessage struct {
+	Type  string `json:"type"`
+	Error string `json:"error,omitempty"`
+}
+
+// wsStream is a single client's stream.
+type wsStream struct {
+	conn     *websocket.Conn
+	in       io.Reader
+	seekable bool
+	opts     WebSocketOptions
+	settings wsSettings
+	paused   bool
+
+	// base is the stream of samples from the capture described by origin,
+	// starting at sample start. consumed is how many have been read from it.
+	origin   Header
+	base     sdr.Reader
+	baseHdr  Header
+	start    int64
+	consumed int64
+
+	// r is base with the current settings applied, and hdr describes the
+	// samples read from r, starting sample samples ago.
+	r        sdr.Reader
+	hdr      Header
+	sample   int64
+	spectrum *spectrum
+
+	// paced is the number of samples sent since started, for pacing the
+	// stream to its sample rate.
+	started time.Time
+	paced   int64
+}
+
+// WebSocketHandler will return an http.Handler that streams the capture
+// returned by open to WebSocket clients. If the io.Reader is an io.Closer,
+// it's closed once the client goes away.
+//
+// The first message sent is a JSON text message:
+//
+//	{"type": "header", "header": {...}, "mode": "fft", "fft_size": 1024,
+//	 "window": "hann", "average": 1, "decimate": 1, "seekable": true}
+//
+// which is sent again whenever the stream changes, and describes the binary
+// messages that follow it. In "iq" mode, each binary message is a complete
+// capture: a Header with the CaptureTime of its first sample, followed by
+// the samples. In "fft" mode, each is a little endian uint64 sample index,
+// counting from the last header message, of the first sample in the FFTs,
+// followed by the power of each bin in dBFS as little endian float32s, with
+// the negative frequencies first.
+//
+// Clients control the stream by sending JSON text messages:
+//
+//	{"command": "configure", "mode": "fft", "fft_size": 2048,
+//	 "window": "hann", "average": 4, "decimate": 2}
+//	{"command": "pause"}
+//	{"command": "resume"}
+//	{"command": "seek", "position": "10s"}
+//
+// Fields left out of a configure are unchanged. A seek position is anything
+// ParseSlicePoint accepts. Pausing and seeking are only possible if the
+// io.Reader is an io.Seeker, such as a capture file. If a control message
+// can't be applied, {"type": "error", "error": "..."} is sent back, and the
+// stream carries on as it was. Once the capture ends, {"type": "end"} is
+// sent and the connection is closed.
+//
+// The configure fields can also be passed as query parameters of the
+// request, to set up the stream before anything is sent.
+func WebSocketHandler(
+	open func(*http.Request) (io.Reader, error),
+	opts WebSocketOptions,
+) http.Handler {
+	if opts.FFTSize == 0 {
+		opts.FFTSize = 1024
+	}
+	if opts.Average == 0 {
+		opts.Average = 1
+	}
+	if opts.Decimate == 0 {
+		opts.Decimate = 1
+	}
+	if opts.FrameSamples == 0 {
+		opts.FrameSamples = 16 * 1024
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := newWsStream(r, opts, open)
+		if ws != nil {
+			if closer, ok := ws.in.(io.Closer); ok {
+				defer closer.Close()
+			}
+		}
+		if err != nil {
+			if oe, ok := err.(wsOpenError); ok {
+				httpError(w, oe.err)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if ws.conn, err = websocket.Upgrade(w, r); err != nil {
+			return
+		}
+		defer ws.conn.Close()
+
+		var (
+			controls = make(chan wsControl, 16)
+			done     = make(chan struct{})
+		)
+		defer close(done)
+		go ws.readControls(controls, done)
+
+		ws.run(controls)
+	})
+}
+
+// wsOpenError is an error from the open function passed to
+// WebSocketHandler.
+type wsOpenError struct {
+	err error
+}
+
+func (e wsOpenError) Error() string {
+	return e.err.Error()
+}
+
+func newWsStream(
+	r *http.Request,
+	opts WebSocketOptions,
+	open func(*http.Request) (io.Reader, error),
+) (*wsStream, error) {
+	in, err := open(r)
+	if err != nil {
+		return nil, wsOpenError{err: err}
+	}
+	_, seekable := in.(io.Seeker)
+
+	ws := &wsStream{
+		in:       in,
+		seekable: seekable,
+		opts:     opts,
+		settings: wsSettings{
+			mode:     opts.Mode,
+			fftSize:  opts.FFTSize,
+			window:   opts.Window,
+			average:  opts.Average,
+			decimate: opts.Decimate,
+		},
+	}
+
+	ctl, err := wsQueryControl(r.URL.Query())
+	if err != nil {
+		return ws, err
+	}
+	if ws.settings, err = ws.settings.apply(ctl); err != nil {
+		return ws, err
+	}
+
+	hdr, base, err := sliceReader(in, SlicePoint{}, SlicePoint{})
+	if err != nil {
+		return ws, err
+	}
+	ws.origin, ws.base, ws.baseHdr = hdr, base, hdr
+	if err := ws.rebuild(); err != nil {
+		return ws, err
+	}
+	return ws, nil
+}
+
+// readControls will read control messages from the client until it goes
+// away, at which point the controls channel is closed.
+func (ws *wsStream) readControls(controls chan<- wsControl, done <-chan struct{}) {
+	defer close(controls)
+	for {
+		op, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if op != websocket.OpText {
+			continue
+		}
+		ctl := wsControl{}
+		if err := json.Unmarshal(data, &ctl); err != nil {
+			ws.send(wsMessage{Type: "error", Error: err.Error()})
+			continue
+		}
+		select {
+		case controls <- ctl:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (ws *wsStream) send(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.conn.WriteMessage(websocket.OpText, msg)
+}
+
+func (ws *wsStream) sendHeader() error {
+	return ws.send(wsHeaderMessage{
+		Type:     "header",
+		Header:   ws.hdr,
+		Mode:     ws.settings.mode.String(),
+		FFTSize:  ws.settings.fftSize,
+		Window:   ws.settings.window.String(),
+		Average:  ws.settings.average,
+		Decimate: ws.settings.decimate,
+		Seekable: ws.seekable,
+	})
+}
+
+// rebuild will apply the current settings to the base stream, from where it
+// has been read up to.
+func (ws *wsStream) rebuild() error {
+	var (
+		hdr = ws.baseHdr
+		r   = ws.base
+		sp  *spectrum
+		err error
+	)
+	hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(ws.consumed))
+
+	if ws.settings.decimate > 1 {
+		if r, hdr, err = Transformer(hdr, r, Decimate(ws.settings.decimate)); err != nil {
+			return err
+		}
+	}
+	if ws.settings.mode == WebSocketFFT {
+		if r, err = newConvertReader(r, sdr.SampleFormatC64); err != nil {
+			return err
+		}
+		if sp, err = newSpectrum(ws.settings.fftSize, ws.settings.window); err != nil {
+			return err
+		}
+	}
+
+	// Each frame is written as an uncompressed capture.
+	hdr.Compressed = false
+	if hdr.Endianness == nil && hdr.SampleFormat != sdr.SampleFormatU8 {
+		hdr.Endianness = binary.LittleEndian
+	}
+
+	ws.r, ws.hdr, ws.spectrum = r, hdr, sp
+	ws.sample = 0
+	ws.started, ws.paced = time.Now(), 0
+	return nil
+}
+
+// configure will change the settings, leaving them as they were if the new
+// settings can't be applied.
+func (ws *wsStream) configure(ctl wsControl) error {
+	settings, err := ws.settings.apply(ctl)
+	if err != nil {
+		return err
+	}
+	old := ws.settings
+	ws.settings = settings
+	if err := ws.rebuild(); err != nil {
+		ws.settings = old
+		ws.rebuild()
+		return err
+	}
+	return ws.sendHeader()
+}
+
+// reopen will start reading the capture again from the provided point.
+func (ws *wsStream) reopen(point SlicePoint) error {
+	if _, err := ws.in.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr, base, err := sliceReader(ws.in, point, SlicePoint{})
+	if err != nil {
+		return err
+	}
+
+	ws.base, ws.baseHdr, ws.consumed = base, hdr, 0
+	ws.start = 0
+	if start := point.Sample(ws.origin); start > 0 {
+		ws.start = start
+	}
+	return ws.rebuild()
+}
+
+// seek will move the stream to the provided position, leaving it where it
+// was if that's not possible.
+func (ws *wsStream) seek(position string) error {
+	if !ws.seekable {
+		return fmt.Errorf("rfcap: this stream can't seek")
+	}
+	point, err := ParseSlicePoint(position)
+	if err != nil {
+		return err
+	}
+
+	current := AtSample(ws.start + ws.consumed)
+	if err := ws.reopen(point); err != nil {
+		ws.reopen(current)
+		return err
+	}
+	return ws.sendHeader()
+}
+
+func (ws *wsStream) control(ctl wsControl) error {
+	switch ctl.Command {
+	case "configure":
+		return ws.configure(ctl)
+	case "pause":
+		if !ws.seekable {
+			return fmt.Errorf("rfcap: this stream can't be paused")
+		}
+		ws.paused = true
+	case "resume":
+		if ws.paused {
+			ws.paused = false
+			ws.started, ws.paced = time.Now(), 0
+		}
+	case "seek":
+		return ws.seek(ctl.Position)
+	default:
+		return fmt.Errorf("rfcap: unknown command %q", ctl.Command)
+	}
+	return nil
+}
+
+// run will send frames until the capture ends or the client goes away.
+func (ws *wsStream) run(controls <-chan wsControl) {
+	handle := func(ctl wsControl, ok bool) bool {
+		if !ok {
+			return false
+		}
+		if err := ws.control(ctl); err != nil {
+			ws.send(wsMessage{Type: "error", Error: err.Error()})
+		}
+		return true
+	}
+
+	if err := ws.sendHeader(); err != nil {
+		return
+	}
+
+	for {
+		if ws.paused {
+			ctl, ok := <-controls
+			if !handle(ctl, ok) {
+				return
+			}
+			continue
+		}
+
+		// Pick up any control messages before sending the next frame, and
+		// while waiting for it to be due.
+		if due := ws.due(); due > 0 {
+			select {
+			case ctl, ok := <-controls:
+				if !handle(ctl, ok) {
+					return
+				}
+				continue
+			case <-time.After(due):
+			}
+		} else {
+			select {
+			case ctl, ok := <-controls:
+				if !handle(ctl, ok) {
+					return
+				}
+				continue
+			default:
+			}
+		}
+
+		err := ws.frame()
+		if err == io.EOF {
+			ws.send(wsMessage{Type: "end"})
+			return
+		}
+		if err != nil {
+			ws.send(wsMessage{Type: "error", Error: err.Error()})
+			return
+		}
+	}
+}
+
+// due will return how long until the next frame should be sent.
+func (ws *wsStream) due() time.Duration {
+	if !ws.opts.Realtime {
+		return 0
+	}
+	var (
+		rate = int64(ws.hdr.SampleRate)
+		due  = ws.started.Add(
+			time.Duration(ws.paced/rate)*time.Second +
+				time.Duration(ws.paced%rate)*time.Second/time.Duration(rate),
+		)
+	)
+	return time.Until(due)
+}
+
+// frame will read and send the next frame.
+func (ws *wsStream) frame() error {
+	var (
+		msg []byte
+		n   int
+		err error
+	)
+	if ws.settings.mode == WebSocketFFT {
+		msg, n, err = ws.fftFrame()
+	} else {
+		msg, n, err = ws.iqFrame()
+	}
+	if n > 0 {
+		if werr := ws.conn.WriteMessage(websocket.OpBinary, msg); werr != nil {
+			return werr
+		}
+		ws.sample += int64(n)
+		ws.paced += int64(n)
+		ws.consumed += int64(n) * int64(ws.settings.decimate)
+	}
+	return err
+}
+
+func (ws *wsStream) iqFrame() ([]byte, int, error) {
+	buf, err := sdr.MakeSamples(ws.r.SampleFormat(), ws.opts.FrameSamples)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := readSamples(ws.r, buf)
+	if n == 0 {
+		return nil, 0, err
+	}
+	if err == io.EOF {
+		// We'll get it again next time around.
+		err = nil
+	}
+
+	hdr := ws.hdr
+	hdr.CaptureTime = hdr.CaptureTime.Add(hdr.Duration(ws.sample))
+	frame := bytes.Buffer{}
+	w, werr := Writer(&frame, hdr)
+	if werr != nil {
+		return nil, 0, werr
+	}
+	if _, werr := w.Write(buf.Slice(0, n)); werr != nil {
+		return nil, 0, werr
+	}
+	if werr := Flush(w); werr != nil {
+		return nil, 0, werr
+	}
+	return frame.Bytes(), n, err
+}
+
+func (ws *wsStream) fftFrame() ([]byte, int, error) {
+	var (
+		sp  = ws.spectrum
+		n   int
+		err error
+	)
+	for sp.count < ws.settings.average {
+		var read int
+		read, err = readSamples(ws.r, sp.iq)
+		if read < len(sp.iq) {
+			break
+		}
+		if err := sp.add(); err != nil {
+			return nil, 0, err
+		}
+		n += read
+	}
+	if sp.count == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, 0, err
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	row := sp.row()
+	msg := make([]byte, 8+4*len(row))
+	binary.LittleEndian.PutUint64(msg, uint64(ws.sample))
+	for i, db := range row {
+		binary.LittleEndian.PutUint32(msg[8+4*i:], math.Float32bits(db))
+	}
+	return msg, n, err
+}
+
+// vim: foldmethod=marker
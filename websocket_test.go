@@ -0,0 +1,266 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paul@k3xec.com>, 2023
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package rfcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hz.tools/rfcap"
+	"hz.tools/rfcap/internal/websocket"
+	"hz.tools/sdr"
+)
+
+// wsCapture is a testCapture, where the real part of each sample is its
+// index.
+func wsCapture(t *testing.T, length int) []byte {
+	samples := make(sdr.SamplesC64, length)
+	for i := range samples {
+		samples[i] = complex(float32(i), 0)
+	}
+	return testCapture(t, samples, nil).Bytes()
+}
+
+// wsServer will serve the capture with WebSocketHandler. If seekable is
+// false, the capture is hidden behind a plain io.Reader.
+func wsServer(capture []byte, seekable bool, opts rfcap.WebSocketOptions) *httptest.Server {
+	return httptest.NewServer(rfcap.WebSocketHandler(func(r *http.Request) (io.Reader, error) {
+		if !seekable {
+			return io.MultiReader(bytes.NewReader(capture)), nil
+		}
+		return bytes.NewReader(capture), nil
+	}, opts))
+}
+
+func wsDial(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	conn, err := websocket.Dial("ws" + strings.TrimPrefix(server.URL, "http") + query)
+	assert.NoError(t, err)
+	return conn
+}
+
+// wsMessage is any JSON message sent by the server.
+type wsMessage struct {
+	Type     string       `json:"type"`
+	Header   rfcap.Header `json:"header"`
+	Mode     string       `json:"mode"`
+	FFTSize  int          `json:"fft_size"`
+	Decimate uint         `json:"decimate"`
+	Seekable bool         `json:"seekable"`
+	Error    string       `json:"error"`
+}
+
+// wsRead will read the next message, decoding it if it's JSON.
+func wsRead(t *testing.T, conn *websocket.Conn) (wsMessage, []byte) {
+	op, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	if op != websocket.OpText {
+		return wsMessage{}, data
+	}
+	msg := wsMessage{}
+	assert.NoError(t, json.Unmarshal(data, &msg))
+	return msg, nil
+}
+
+func wsSend(t *testing.T, conn *websocket.Conn, ctl string) {
+	assert.NoError(t, conn.WriteMessage(websocket.OpText, []byte(ctl)))
+}
+
+func TestWebSocketIQ(t *testing.T) {
+	server := wsServer(wsCapture(t, 1000), true, rfcap.WebSocketOptions{FrameSamples: 300})
+	defer server.Close()
+	conn := wsDial(t, server, "")
+	defer conn.Close()
+
+	msg, _ := wsRead(t, conn)
+	assert.Equal(t, "header", msg.Type)
+	assert.Equal(t, "iq", msg.Mode)
+	assert.True(t, msg.Seekable)
+	assert.True(t, testEpoch.Equal(msg.Header.CaptureTime))
+	assert.Equal(t, uint(1000), msg.Header.SampleRate)
+
+	// Each frame is a capture of its own.
+	samples := sdr.SamplesC64{}
+	for {
+		msg, frame := wsRead(t, conn)
+		if frame == nil {
+			assert.Equal(t, "end", msg.Type)
+			break
+		}
+		iq, hdr := readAllC64(t, bytes.NewReader(frame))
+		assert.Equal(t, testEpoch.Add(time.Duration(len(samples))*time.Millisecond), hdr.CaptureTime)
+		samples = append(samples, iq...)
+	}
+
+	assert.Equal(t, 1000, len(samples))
+	for i, s := range samples {
+		assert.Equal(t, float32(i), real(s))
+	}
+	_, _, err := conn.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWebSocketFFT(t *testing.T) {
+	capture := toneCapture(t, 10, 64, 64*8).Bytes()
+	server := wsServer(capture, true, rfcap.WebSocketOptions{})
+	defer server.Close()
+	conn := wsDial(t, server, "?mode=fft&fft_size=64&average=2")
+	defer conn.Close()
+
+	msg, _ := wsRead(t, conn)
+	assert.Equal(t, "fft", msg.Mode)
+	assert.Equal(t, 64, msg.FFTSize)
+
+	for i := 0; i < 4; i++ {
+		_, frame := wsRead(t, conn)
+		assert.Equal(t, 8+4*64, len(frame))
+		assert.Equal(t, uint64(i*128), binary.LittleEndian.Uint64(frame))
+
+		peak, peakDB := -1, float32(-1000)
+		for bin := 0; bin < 64; bin++ {
+			db := math.Float32frombits(binary.LittleEndian.Uint32(frame[8+4*bin:]))
+			if db > peakDB {
+				peak, peakDB = bin, db
+			}
+		}
+		// Negative frequencies come first, so bin 10 is 32 bins in.
+		assert.Equal(t, 32+10, peak)
+	}
+
+	msg, _ = wsRead(t, conn)
+	assert.Equal(t, "end", msg.Type)
+}
+
+func TestWebSocketControl(t *testing.T) {
+	server := wsServer(wsCapture(t, 10000), true, rfcap.WebSocketOptions{
+		FrameSamples: 100,
+		Realtime:     true,
+	})
+	defer server.Close()
+	conn := wsDial(t, server, "")
+	defer conn.Close()
+
+	msg, _ := wsRead(t, conn)
+	assert.Equal(t, "header", msg.Type)
+
+	// nextHeader will skip frames sent before the control message was
+	// picked up.
+	nextHeader := func() (wsMessage, []byte) {
+		for {
+			msg, frame := wsRead(t, conn)
+			if frame != nil {
+				continue
+			}
+			assert.Equal(t, "header", msg.Type, msg.Error)
+			_, frame = wsRead(t, conn)
+			return msg, frame
+		}
+	}
+
+	wsSend(t, conn, `{"command": "seek", "position": "5s"}`)
+	msg, frame := nextHeader()
+	assert.True(t, testEpoch.Add(5*time.Second).Equal(msg.Header.CaptureTime))
+	iq, hdr := readAllC64(t, bytes.NewReader(frame))
+	assert.Equal(t, testEpoch.Add(5*time.Second), hdr.CaptureTime)
+	assert.Equal(t, float32(5000), real(iq[0]))
+
+	wsSend(t, conn, `{"command": "configure", "decimate": 2}`)
+	msg, frame = nextHeader()
+	assert.Equal(t, uint(2), msg.Decimate)
+	assert.Equal(t, uint(500), msg.Header.SampleRate)
+	_, hdr = readAllC64(t, bytes.NewReader(frame))
+	assert.Equal(t, uint(500), hdr.SampleRate)
+
+	wsSend(t, conn, `{"command": "configure", "fft_size": 1000}`)
+	for {
+		msg, frame := wsRead(t, conn)
+		if frame == nil {
+			assert.Equal(t, "error", msg.Type)
+			assert.Contains(t, msg.Error, "power of two")
+			break
+		}
+	}
+
+	wsSend(t, conn, `{"command": "configure", "fft_size": 8589934592}`)
+	for {
+		msg, frame := wsRead(t, conn)
+		if frame == nil {
+			assert.Equal(t, "error", msg.Type)
+			assert.Contains(t, msg.Error, "larger than")
+			break
+		}
+	}
+}
+
+func TestWebSocketNotSeekable(t *testing.T) {
+	server := wsServer(wsCapture(t, 100000), false, rfcap.WebSocketOptions{
+		Realtime: true,
+	})
+	defer server.Close()
+	conn := wsDial(t, server, "")
+	defer conn.Close()
+
+	msg, _ := wsRead(t, conn)
+	assert.False(t, msg.Seekable)
+
+	wsSend(t, conn, `{"command": "pause"}`)
+	for {
+		msg, frame := wsRead(t, conn)
+		if frame == nil {
+			assert.Equal(t, "error", msg.Type)
+			assert.Contains(t, msg.Error, "can't be paused")
+			break
+		}
+	}
+}
+
+func TestWebSocketBadRequest(t *testing.T) {
+	server := wsServer(wsCapture(t, 100), true, rfcap.WebSocketOptions{})
+	defer server.Close()
+
+	for _, query := range []string{
+		"?fft_size=3",
+		"?fft_size=8589934592",
+		"?fft_size=131072",
+		"?average=0",
+		"?average=1025",
+		"?decimate=1025",
+	} {
+		resp, err := http.Get(server.URL + query)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	_, err := websocket.Dial("ws" + strings.TrimPrefix(server.URL, "http") + "?mode=waterfall")
+	assert.Error(t, err)
+}
+
+// vim: foldmethod=marker
@@ -21,7 +21,6 @@
 package rfcap
 
 import (
-	"encoding/binary"
 	"io"
 
 	"hz.tools/rfcap/internal/packer"
@@ -33,20 +32,25 @@ type writer struct {
 	w      sdr.Writer
 }
 
-// Writer will create a new sdr.Reader that writes to the underlying Stream.
+// Writer will create a new sdr.Writer that writes to the underlying Stream.
+//
+// If the Header is Compressed, samples are packed in blocks of 4, and up to 3
+// samples are held back until the next Write. Flush must be called once the
+// last samples have been written, or those samples will be lost.
 func Writer(out io.Writer, header Header) (sdr.Writer, error) {
 	if err := header.validate(); err != nil {
 		return nil, err
 	}
 
-	bh := header.asBinaryHeader()
-	if err := bh.Validate(); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(out, binary.LittleEndian, bh); err != nil {
+	if err := writeHeader(out, header); err != nil {
 		return nil, err
 	}
+	return bodyWriter(out, header)
+}
 
+// bodyWriter will create a new sdr.Writer that writes samples described by
+// the Header to an io stream, without writing the Header itself.
+func bodyWriter(out io.Writer, header Header) (sdr.Writer, error) {
 	var (
 		sWriter = sdr.ByteWriter(out, header.Endianness, header.SampleRate, header.SampleFormat)
 		err     error
@@ -79,4 +83,21 @@ func (w writer) Write(samples sdr.Samples) (int, error) {
 	return w.w.Write(samples)
 }
 
+// Flush will write out any samples held back by the underlying sdr.Writer.
+func (w writer) Flush() error {
+	return Flush(w.w)
+}
+
+// Flush will write out any samples held back by an sdr.Writer returned by
+// Writer. Compressed samples are packed in blocks of 4, so the last partial
+// block is only written (padded out with silence) once this is called. This
+// must be called once the last samples have been written. Any other
+// sdr.Writer is left alone.
+func Flush(w sdr.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 // vim: foldmethod=marker